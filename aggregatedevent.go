@@ -0,0 +1,103 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+// AggregatedEvent is an event record together with the packet, buffer
+// and extra data records that belong to it.
+type AggregatedEvent struct {
+	Event     *EventRecord
+	Packets   []*PacketRecord
+	ExtraData []*ExtraDataRecord
+	Buffers   []*BufferRecord
+
+	// Enrichment holds data added to the event by Enrichers, keyed by
+	// the name of the enrichment.
+	Enrichment map[string]interface{}
+}
+
+// Enricher adds data, such as the location of its addresses, to an
+// event.
+type Enricher interface {
+	Enrich(event *AggregatedEvent)
+}
+
+// SetEnrichment sets the enrichment data for name.
+func (e *AggregatedEvent) SetEnrichment(name string, value interface{}) {
+	if e.Enrichment == nil {
+		e.Enrichment = map[string]interface{}{}
+	}
+	e.Enrichment[name] = value
+}
+
+// EventAggregator groups decoded records, in the order they are read
+// from a file or spool, into AggregatedEvents.
+type EventAggregator struct {
+	current *AggregatedEvent
+}
+
+// Add adds a record as returned by ReadRecord or a reader's Next
+// method.  When an event record is added the previous event is
+// complete and is returned, otherwise nil is returned.
+//
+// Packet, buffer and extra data records that do not belong to the
+// current event are discarded.
+func (a *EventAggregator) Add(record interface{}) *AggregatedEvent {
+	switch record := record.(type) {
+	case *EventRecord:
+		complete := a.current
+		a.current = &AggregatedEvent{Event: record}
+		return complete
+	case *PacketRecord:
+		if a.current != nil &&
+			record.EventId == a.current.Event.EventId &&
+			record.EventSecond == a.current.Event.EventSecond {
+			a.current.Packets = append(a.current.Packets, record)
+		}
+	case *ExtraDataRecord:
+		if a.current != nil &&
+			record.EventId == a.current.Event.EventId &&
+			record.EventSecond == a.current.Event.EventSecond {
+			a.current.ExtraData = append(a.current.ExtraData, record)
+		}
+	case *BufferRecord:
+		if a.current != nil &&
+			record.EventId == a.current.Event.EventId &&
+			record.EventSecond == a.current.Event.EventSecond {
+			a.current.Buffers = append(a.current.Buffers, record)
+		}
+	}
+	return nil
+}
+
+// Flush returns the current event, if any, as complete.  It should be
+// called at the end of the input, or when no more records are expected
+// for a while.
+func (a *EventAggregator) Flush() *AggregatedEvent {
+	complete := a.current
+	a.current = nil
+	return complete
+}
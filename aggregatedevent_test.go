@@ -0,0 +1,77 @@
+package unified2
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+// Read all the aggregated events from a file.
+func readAggregatedEvents(t *testing.T, filename string) []*AggregatedEvent {
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var aggregator EventAggregator
+	events := []*AggregatedEvent{}
+	for {
+		record, err := ReadRecord(file)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if event := aggregator.Add(record); event != nil {
+			events = append(events, event)
+		}
+	}
+	if event := aggregator.Flush(); event != nil {
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestEventAggregator(t *testing.T) {
+	events := readAggregatedEvents(t, "test/multi-record-event-x2.log")
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	for _, event := range events {
+		if len(event.Packets) != 15 || len(event.ExtraData) != 1 {
+			t.Fatalf("unexpected packets %d or extra data %d",
+				len(event.Packets), len(event.ExtraData))
+		}
+	}
+
+	var aggregator EventAggregator
+	if aggregator.Add(&PacketRecord{}) != nil || aggregator.Flush() != nil {
+		t.Fatal("expected nil event")
+	}
+}
+
+type testEnricher string
+
+func (e testEnricher) Enrich(event *AggregatedEvent) {
+	event.SetEnrichment(string(e), event.Event.SignatureId)
+}
+
+func TestEnrichment(t *testing.T) {
+	events := readAggregatedEvents(t, "test/multi-record-event.log")
+
+	var enricher Enricher = testEnricher("test")
+	enricher.Enrich(events[0])
+	if events[0].Enrichment["test"] != events[0].Event.SignatureId {
+		t.Fatalf("unexpected enrichment %v", events[0].Enrichment)
+	}
+
+	document := NewEventDocument(events[0], nil)
+	if document.Enrichment["test"] != events[0].Event.SignatureId {
+		t.Fatalf("enrichment not in document: %v", document.Enrichment)
+	}
+	event := &AggregatedEvent{Event: events[0].Event}
+	if document := NewEventDocument(event, nil); document.Enrichment != nil {
+		t.Fatalf("unexpected enrichment %v", document.Enrichment)
+	}
+}
@@ -0,0 +1,325 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// The length of an Anonymizer key.
+const ANONYMIZER_KEY_LEN = 32
+
+// IP protocol numbers of tunnels, whose inner headers the Anonymizer
+// does not rewrite.
+const (
+	IPPROTO_IPIP = 4
+	IPPROTO_IPV6 = 41
+	IPPROTO_GRE  = 47
+)
+
+// Anonymizer rewrites unified2 records so they can be shared without
+// revealing the addresses of the network they were captured on.
+//
+// Addresses are anonymized with the prefix-preserving Crypto-PAn
+// scheme: two addresses sharing an n bit prefix map to addresses
+// sharing an n bit prefix, and the same key always gives the same
+// mapping.  Addresses are rewritten in event records, in the IP
+// headers of packets and the IP headers quoted by ICMP errors, with
+// their checksums fixed up, and in address extra data.  Packets that
+// tunnel IP in IP or GRE are dropped, as are ICMP errors whose quoted
+// header can not be rewritten.
+type Anonymizer struct {
+	// TruncatePayload, if set, truncates packet payloads following
+	// the transport header, and buffers, to PayloadLength bytes.
+	TruncatePayload bool
+	PayloadLength   int
+
+	// ScrubExtraData, if set, drops extra data records that are not
+	// addresses, such as URIs, hostnames and mail headers.
+	ScrubExtraData bool
+
+	block cipher.Block
+	pad   [aes.BlockSize]byte
+}
+
+// NewAnonymizer creates an Anonymizer from a 32 byte key.  The first
+// 16 bytes are the AES key, the last 16 are encrypted to form the pad.
+func NewAnonymizer(key []byte) (*Anonymizer, error) {
+	if len(key) != ANONYMIZER_KEY_LEN {
+		return nil, fmt.Errorf("anonymizer key must be %d bytes",
+			ANONYMIZER_KEY_LEN)
+	}
+	block, err := aes.NewCipher(key[:16])
+	if err != nil {
+		return nil, err
+	}
+	a := &Anonymizer{block: block}
+	block.Encrypt(a.pad[:], key[16:])
+	return a, nil
+}
+
+// AnonymizeIP returns the anonymized form of an IPv4 or IPv6 address.
+// IPv4 addresses are returned as 4 bytes.
+func (a *Anonymizer) AnonymizeIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return a.anonymize(ip4)
+	} else if len(ip) == net.IPv6len {
+		return a.anonymize(ip)
+	}
+	return ip
+}
+
+// anonymize applies Crypto-PAn to an address of 4 or 16 bytes.  Bit i
+// of the result is bit i of the address xored with the first bit of
+// the encryption of the first i bits of the address followed by the
+// rest of the pad.
+func (a *Anonymizer) anonymize(address []byte) net.IP {
+	result := make(net.IP, len(address))
+	var input, output [aes.BlockSize]byte
+
+	for i := 0; i < len(address)*8; i++ {
+		input = a.pad
+		for j := 0; j < i/8; j++ {
+			input[j] = address[j]
+		}
+		if bits := uint(i % 8); bits > 0 {
+			mask := byte(0xff) << (8 - bits)
+			input[i/8] = address[i/8]&mask | a.pad[i/8]&^mask
+		}
+		a.block.Encrypt(output[:], input[:])
+		result[i/8] |= (output[0] >> 7) << (7 - uint(i%8))
+	}
+
+	for i := range result {
+		result[i] ^= address[i]
+	}
+	return result
+}
+
+// Anonymize reads the records of input and writes them, anonymized,
+// to output.  Records that can not be anonymized are dropped.
+func (a *Anonymizer) Anonymize(output io.Writer,
+	input io.ReadWriteSeeker) error {
+	for {
+		record, err := ReadRawRecord(input)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		record, err = a.AnonymizeRecord(record)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			continue
+		}
+		if err := WriteRawRecord(output, record); err != nil {
+			return err
+		}
+	}
+}
+
+// AnonymizeRecord returns an anonymized copy of a raw record, or nil if
+// the record should be dropped.  Records of unknown types, records
+// that fail to decode and packets whose addresses can not be found are
+// dropped, as they may hold addresses.
+func (a *Anonymizer) AnonymizeRecord(record *RawRecord) (*RawRecord, error) {
+	switch {
+	case isEventType(record.Type):
+		event, err := DecodeEventRecord(record.Type, record.Data)
+		if err != nil {
+			return nil, nil
+		}
+		event.IpSource = a.AnonymizeIP(event.IpSource)
+		event.IpDestination = a.AnonymizeIP(event.IpDestination)
+		return EncodeEventRecord(record.Type, event)
+	case record.Type == UNIFIED2_PACKET:
+		packet, err := DecodePacketRecord(record.Data)
+		if err != nil {
+			return nil, nil
+		}
+		data := append([]byte{}, packet.Data...)
+		if !a.anonymizePacket(packet.LinkType, &data) {
+			return nil, nil
+		}
+		packet.Data = data
+		return EncodePacketRecord(packet), nil
+	case record.Type == UNIFIED2_BUFFER:
+		buffer, err := DecodeBufferRecord(record.Data)
+		if err != nil {
+			return nil, nil
+		}
+		if a.TruncatePayload && len(buffer.Data) > a.PayloadLength {
+			buffer.Data = buffer.Data[:a.PayloadLength]
+		}
+		return EncodeBufferRecord(buffer), nil
+	case record.Type == UNIFIED2_EXTRA_DATA:
+		extra, err := DecodeExtraDataRecord(record.Data)
+		if err != nil {
+			return nil, nil
+		}
+		if ip := extra.IP(); ip != nil {
+			extra.Data = a.AnonymizeIP(ip)
+			if extra.Type != EXTRA_DATA_TYPE_XFF_IPV4 {
+				extra.Data = net.IP(extra.Data).To16()
+			}
+			return EncodeExtraDataRecord(extra), nil
+		}
+		switch extra.Type {
+		case EXTRA_DATA_TYPE_XFF_IPV4, EXTRA_DATA_TYPE_XFF_IPV6,
+			EXTRA_DATA_TYPE_IPV6_SRC, EXTRA_DATA_TYPE_IPV6_DST:
+			// An address of the wrong length.
+			return nil, nil
+		}
+		if a.ScrubExtraData {
+			return nil, nil
+		}
+		return EncodeExtraDataRecord(extra), nil
+	}
+	return nil, nil
+}
+
+// anonymizePacket rewrites the addresses in the IP header of a packet,
+// and in the IP header quoted by an ICMP error, adjusting the checksums
+// that cover them, and truncates the payload.  Returns false if the
+// packet should be dropped.
+func (a *Anonymizer) anonymizePacket(linkType uint32, data *[]byte) bool {
+	packet := a.anonymizeHeaders(linkType, *data)
+	if packet == nil {
+		return false
+	}
+
+	if a.TruncatePayload && packet.PayloadOffset >= 0 &&
+		len(*data) > packet.PayloadOffset+a.PayloadLength {
+		*data = (*data)[:packet.PayloadOffset+a.PayloadLength]
+	}
+
+	return true
+}
+
+// anonymizeHeaders rewrites the addresses of a packet in place,
+// returning the decoded packet, or nil if the packet has no IP header
+// or holds addresses that can not be rewritten.
+func (a *Anonymizer) anonymizeHeaders(linkType uint32, data []byte) *Packet {
+	packet, err := DecodePacket(linkType, data)
+	if err != nil || packet.NetworkOffset < 0 {
+		return nil
+	}
+	header := data[packet.NetworkOffset:]
+
+	var protocol uint8
+	if packet.IPv4 != nil {
+		protocol = packet.IPv4.Protocol
+	} else {
+		protocol = packet.IPv6.NextHeader
+	}
+	switch protocol {
+	case IPPROTO_IPIP, IPPROTO_IPV6, IPPROTO_GRE:
+		return nil
+	case IPPROTO_ICMP, IPPROTO_ICMPV6:
+		// Without the ICMP header, as in a later fragment, there is
+		// no telling if the payload quotes a header.
+		if packet.ICMP == nil && len(packet.Payload) > 0 {
+			return nil
+		}
+	}
+
+	var before, after []byte
+	switch {
+	case packet.IPv4 != nil:
+		before = append([]byte{}, header[12:20]...)
+		copy(header[12:], a.anonymize(header[12:16]))
+		copy(header[16:], a.anonymize(header[16:20]))
+		after = header[12:20]
+		binary.BigEndian.PutUint16(header[10:], adjustChecksum(
+			binary.BigEndian.Uint16(header[10:]), before, after))
+	case packet.IPv6 != nil:
+		before = append([]byte{}, header[8:40]...)
+		copy(header[8:], a.anonymize(header[8:24]))
+		copy(header[24:], a.anonymize(header[24:40]))
+		after = header[8:40]
+	}
+
+	if packet.TransportOffset >= 0 {
+		transport := data[packet.TransportOffset:]
+		offset := -1
+		switch {
+		case packet.TCP != nil:
+			offset = 16
+		case packet.UDP != nil:
+			// A zero UDP checksum over IPv4 means there is none.
+			if packet.IPv6 != nil || packet.UDP.Checksum != 0 {
+				offset = 6
+			}
+		case packet.ICMP != nil:
+			// Only the ICMPv6 checksum covers the addresses.
+			if packet.IPv6 != nil {
+				offset = 2
+			}
+		}
+		if offset >= 0 {
+			sum := adjustChecksum(binary.BigEndian.Uint16(
+				transport[offset:]), before, after)
+			if packet.UDP != nil && sum == 0 {
+				sum = 0xffff
+			}
+			binary.BigEndian.PutUint16(transport[offset:], sum)
+		}
+	}
+
+	if packet.ICMP != nil && isICMPError(protocol, packet.ICMP.Type) {
+		quoted := data[packet.PayloadOffset:]
+		before = append([]byte{}, quoted...)
+		if a.anonymizeHeaders(LINKTYPE_RAW, quoted) == nil {
+			return nil
+		}
+		transport := data[packet.TransportOffset:]
+		binary.BigEndian.PutUint16(transport[2:], adjustChecksum(
+			binary.BigEndian.Uint16(transport[2:]), before, quoted))
+	}
+
+	return packet
+}
+
+// isICMPError returns true if an ICMP or ICMPv6 message of the given
+// type quotes the packet that caused it.
+func isICMPError(protocol uint8, icmpType uint8) bool {
+	if protocol == IPPROTO_ICMPV6 {
+		return icmpType < 128
+	}
+	switch icmpType {
+	case 3, 4, 5, 11, 12:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,283 @@
+package unified2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+)
+
+var anonymizerTestKey = []byte{21, 34, 23, 141, 51, 164, 207, 128, 19, 10,
+	91, 22, 73, 144, 125, 16, 216, 152, 143, 131, 121, 121, 101, 39, 98,
+	87, 76, 45, 42, 132, 34, 2}
+
+func TestAnonymizeIP(t *testing.T) {
+	anonymizer, err := NewAnonymizer(anonymizerTestKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test vectors from the Crypto-PAn reference implementation.
+	tests := []struct {
+		ip       string
+		expected string
+	}{
+		{"128.11.68.132", "135.242.180.132"},
+		{"129.118.74.4", "134.136.186.123"},
+		{"130.132.252.244", "133.68.164.234"},
+		{"141.223.7.43", "141.167.8.160"},
+		{"141.233.145.108", "141.129.237.235"},
+		{"152.163.225.39", "151.140.114.167"},
+		{"156.29.3.236", "147.225.12.42"},
+		{"165.247.96.84", "162.9.99.234"},
+		{"166.107.77.190", "160.132.178.185"},
+		{"192.102.249.13", "252.138.62.131"},
+	}
+	for _, test := range tests {
+		result := anonymizer.AnonymizeIP(net.ParseIP(test.ip))
+		if result.String() != test.expected {
+			t.Fatalf("%s: expected %s, got %s", test.ip, test.expected,
+				result)
+		}
+		if len(result) != 4 {
+			t.Fatalf("expected 4 byte address, got %d", len(result))
+		}
+	}
+
+	// Prefixes are preserved for IPv6 too.
+	a := anonymizer.AnonymizeIP(net.ParseIP("2001:db8:1:2::1"))
+	b := anonymizer.AnonymizeIP(net.ParseIP("2001:db8:1:3::1"))
+	if !bytes.Equal(a[:6], b[:6]) || bytes.Equal(a[:8], b[:8]) {
+		t.Fatalf("prefix not preserved: %s, %s", a, b)
+	}
+
+	if _, err := NewAnonymizer(anonymizerTestKey[:16]); err == nil {
+		t.Fatal("expected error for short key")
+	}
+}
+
+func TestAnonymize(t *testing.T) {
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	generator := NewGenerator(1)
+	generator.EventTypes[UNIFIED2_EVENT_V3] = 1
+	generator.PacketsPerEvent = 2
+	generator.ExtraDataProbability = 1
+	generator.ExtraDataTypes = []uint32{EXTRA_DATA_TYPE_XFF_IPV4,
+		EXTRA_DATA_TYPE_IPV6_SRC, EXTRA_DATA_TYPE_HTTP_URI}
+	var generated bytes.Buffer
+	if err := generator.Write(&generated, 200); err != nil {
+		t.Fatal(err)
+	}
+
+	original := path.Join(tmpdir, "original.log")
+	if err := ioutil.WriteFile(original, generated.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	input, err := os.Open(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	anonymizer, err := NewAnonymizer(anonymizerTestKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anonymizer.TruncatePayload = true
+	anonymizer.PayloadLength = 4
+	anonymizer.ScrubExtraData = true
+
+	filename := path.Join(tmpdir, "anonymized.log")
+	output, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := anonymizer.Anonymize(output, input); err != nil {
+		t.Fatal(err)
+	}
+	output.Close()
+
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := Validate(file)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid() {
+		t.Fatalf("anonymized file is not valid: %v", report.Issues)
+	}
+
+	before := readRecords(t, original)
+	after := readRecords(t, filename)
+
+	var event, anonymized *EventRecord
+	i := 0
+	for _, record := range before {
+		switch record := record.(type) {
+		case *EventRecord:
+			event = record
+			anonymized = after[i].(*EventRecord)
+			i++
+			expected := anonymizer.AnonymizeIP(event.IpSource)
+			if !anonymized.IpSource.Equal(expected) {
+				t.Fatalf("expected source %s, got %s", expected,
+					anonymized.IpSource)
+			}
+			if anonymized.SignatureId != event.SignatureId {
+				t.Fatal("signature id changed")
+			}
+		case *PacketRecord:
+			packet, err := after[i].(*PacketRecord).Decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			i++
+			var source net.IP
+			if packet.IPv4 != nil {
+				source = packet.IPv4.Source
+			} else {
+				source = packet.IPv6.Source
+			}
+			if !source.Equal(anonymized.IpSource) {
+				t.Fatalf("packet source %s, event source %s", source,
+					anonymized.IpSource)
+			}
+			if len(packet.Payload) > 4 {
+				t.Fatalf("payload not truncated: %d bytes",
+					len(packet.Payload))
+			}
+			// The truncated packet can't be checked, so check the
+			// checksums of the original anonymized in full.
+			full := *anonymizer
+			full.TruncatePayload = false
+			data := append([]byte{}, record.Data...)
+			if !full.anonymizePacket(record.LinkType, &data) {
+				t.Fatal("packet not anonymized")
+			}
+			decoded, err := DecodePacket(record.LinkType, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fixed := append([]byte{}, data...)
+			updateChecksums(fixed, decoded)
+			if !bytes.Equal(fixed, data) {
+				t.Fatal("bad checksum in anonymized packet")
+			}
+		case *ExtraDataRecord:
+			if record.Type == EXTRA_DATA_TYPE_HTTP_URI {
+				continue
+			}
+			extra := after[i].(*ExtraDataRecord)
+			i++
+			if extra.Type != record.Type {
+				t.Fatalf("expected extra data type %d, got %d",
+					record.Type, extra.Type)
+			}
+			expected := anonymizer.AnonymizeIP(record.IP())
+			if !extra.IP().Equal(expected) {
+				t.Fatalf("expected %s, got %s", expected, extra.IP())
+			}
+		}
+	}
+	if i != len(after) {
+		t.Fatalf("expected %d records, got %d", i, len(after))
+	}
+}
+
+func TestAnonymizeICMPError(t *testing.T) {
+	anonymizer, err := NewAnonymizer(anonymizerTestKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generator := NewGenerator(1)
+
+	for _, ip6 := range []bool{false, true} {
+		// A UDP datagram and the ICMP error it caused, which quotes
+		// its IP and UDP headers.
+		event := &EventRecord{
+			IpSource:      generator.address(ip6),
+			IpDestination: generator.address(ip6),
+			Protocol:      IPPROTO_UDP,
+			SportItype:    1024,
+			DportIcode:    53,
+		}
+		original := generator.packet(event, 0).Data
+		headerLength := 14 + 20 + 8
+		if ip6 {
+			headerLength = 14 + 40 + 8
+		}
+		quoted := original[14:headerLength]
+
+		event.IpSource, event.IpDestination = event.IpDestination,
+			event.IpSource
+		if ip6 {
+			event.Protocol = IPPROTO_ICMPV6
+			event.SportItype, event.DportIcode = 1, 4
+		} else {
+			event.Protocol = IPPROTO_ICMP
+			event.SportItype, event.DportIcode = 3, 3
+		}
+		data := generator.packet(event, 0).Data[:headerLength]
+		data = append(data, quoted...)
+		if ip6 {
+			binary.BigEndian.PutUint16(data[14+4:], uint16(8+len(quoted)))
+		} else {
+			binary.BigEndian.PutUint16(data[14+2:], uint16(28+len(quoted)))
+		}
+		packet, err := DecodePacket(LINKTYPE_ETHERNET, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		updateChecksums(data, packet)
+
+		if !anonymizer.anonymizePacket(LINKTYPE_ETHERNET, &data) {
+			t.Fatal("packet not anonymized")
+		}
+		packet, err = DecodePacket(LINKTYPE_ETHERNET, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fixed := append([]byte{}, data...)
+		updateChecksums(fixed, packet)
+		if !bytes.Equal(fixed, data) {
+			t.Fatal("bad checksum in anonymized packet")
+		}
+
+		// The quoted headers match those of the anonymized datagram.
+		if !anonymizer.anonymizePacket(LINKTYPE_ETHERNET, &original) {
+			t.Fatal("packet not anonymized")
+		}
+		if !bytes.Equal(packet.Payload, original[14:headerLength]) {
+			t.Fatalf("unexpected quoted headers %x, expected %x",
+				packet.Payload, original[14:headerLength])
+		}
+
+		// An error quoting a header that can not be decoded.
+		data = data[:len(data)-len(quoted)+10]
+		if anonymizer.anonymizePacket(LINKTYPE_ETHERNET, &data) {
+			t.Fatal("expected packet to be dropped")
+		}
+	}
+
+	event := &EventRecord{
+		IpSource:      generator.address(false),
+		IpDestination: generator.address(false),
+		Protocol:      IPPROTO_GRE,
+	}
+	data := generator.packet(event, 0).Data
+	if anonymizer.anonymizePacket(LINKTYPE_ETHERNET, &data) {
+		t.Fatal("expected GRE packet to be dropped")
+	}
+}
@@ -0,0 +1,447 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+/*
+Package barnyard2 writes unified2 events into the database schema of
+barnyard2, as read by dashboards such as BASE and Snorby.
+
+Any database/sql driver can be used, for example MySQL, PostgreSQL or
+SQLite.  Packets are dissected to fill in the iphdr, tcphdr, udphdr,
+icmphdr and data tables.  As with barnyard2, the iphdr table only
+holds IPv4 addresses.
+*/
+package barnyard2
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jasonish/go-unified2"
+)
+
+// Output writes events to a barnyard2 database.
+//
+// Outputs should be created with New().
+type Output struct {
+	// Signatures, if set, provide the names, classifications and
+	// references of signatures.
+	Signatures *unified2.SignatureMap
+
+	db      *sql.DB
+	dialect Dialect
+
+	sensorId int64
+	lastCid  int64
+
+	// Caches of row ids.
+	signatureIds       map[[3]uint32]int64
+	classIds           map[string]int64
+	referenceSystemIds map[string]int64
+}
+
+// New creates an Output for the sensor identified by hostname and
+// interface, adding the sensor to the database if it is not already
+// there.  Event ids continue from the last event of the sensor.
+func New(db *sql.DB, dialect Dialect, hostname string,
+	iface string) (*Output, error) {
+
+	output := &Output{
+		db:      db,
+		dialect: dialect,
+	}
+	output.resetCache()
+
+	err := output.selectId(db, &output.sensorId,
+		"SELECT sid FROM sensor WHERE hostname = ? AND interface = ?",
+		hostname, iface)
+	if err == sql.ErrNoRows {
+		_, err = db.Exec(output.rebind(`INSERT INTO sensor
+			(hostname, interface, detail, encoding, last_cid)
+			VALUES (?, ?, 1, 0, 0)`), hostname, iface)
+		if err != nil {
+			return nil, err
+		}
+		err = output.selectId(db, &output.sensorId,
+			"SELECT sid FROM sensor WHERE hostname = ? AND interface = ?",
+			hostname, iface)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Use the greater of the sensor's last cid and the events
+	// stored, in case last_cid was not updated.
+	var lastCid, maxCid sql.NullInt64
+	err = db.QueryRow(output.rebind(
+		"SELECT last_cid FROM sensor WHERE sid = ?"),
+		output.sensorId).Scan(&lastCid)
+	if err != nil {
+		return nil, err
+	}
+	err = db.QueryRow(output.rebind(
+		"SELECT MAX(cid) FROM event WHERE sid = ?"),
+		output.sensorId).Scan(&maxCid)
+	if err != nil {
+		return nil, err
+	}
+	output.lastCid = lastCid.Int64
+	if maxCid.Int64 > output.lastCid {
+		output.lastCid = maxCid.Int64
+	}
+
+	return output, nil
+}
+
+// SensorId returns the id of the sensor events are written for.
+func (o *Output) SensorId() int64 {
+	return o.sensorId
+}
+
+func (o *Output) resetCache() {
+	o.signatureIds = map[[3]uint32]int64{}
+	o.classIds = map[string]int64{}
+	o.referenceSystemIds = map[string]int64{}
+}
+
+func (o *Output) rebind(statement string) string {
+	return rebind(o.dialect, statement)
+}
+
+// queryer is implemented by both sql.DB and sql.Tx.
+type queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func (o *Output) selectId(q queryer, id *int64, statement string,
+	args ...interface{}) error {
+	return q.QueryRow(o.rebind(statement), args...).Scan(id)
+}
+
+// selectOrInsert returns the id selected by query, first running
+// insert if there is no such row.  The row id is selected rather than
+// taken from the insert as not all drivers support LastInsertId.
+func (o *Output) selectOrInsert(tx *sql.Tx, query string,
+	queryArgs []interface{}, insert string,
+	insertArgs []interface{}) (int64, bool, error) {
+
+	var id int64
+	err := o.selectId(tx, &id, query, queryArgs...)
+	if err == nil {
+		return id, false, nil
+	} else if err != sql.ErrNoRows {
+		return 0, false, err
+	}
+	if _, err := tx.Exec(o.rebind(insert), insertArgs...); err != nil {
+		return 0, false, err
+	}
+	if err := o.selectId(tx, &id, query, queryArgs...); err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
+}
+
+// Insert writes a batch of events in a single transaction.
+//
+// Insert can be used as the Send function of a SpoolBatcher.
+func (o *Output) Insert(events []*unified2.AggregatedEvent) error {
+	tx, err := o.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	lastCid := o.lastCid
+	if err := o.insert(tx, events); err != nil {
+		tx.Rollback()
+
+		// Ids cached during the transaction may have been rolled
+		// back.
+		o.lastCid = lastCid
+		o.resetCache()
+
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		o.lastCid = lastCid
+		o.resetCache()
+		return err
+	}
+
+	return nil
+}
+
+func (o *Output) insert(tx *sql.Tx, events []*unified2.AggregatedEvent) error {
+	for _, event := range events {
+		signatureId, err := o.signatureId(tx, event.Event)
+		if err != nil {
+			return err
+		}
+
+		o.lastCid++
+		cid := o.lastCid
+
+		timestamp := time.Unix(int64(event.Event.EventSecond),
+			int64(event.Event.EventMicrosecond)*1000)
+		_, err = tx.Exec(o.rebind(`INSERT INTO event
+			(sid, cid, signature, timestamp) VALUES (?, ?, ?, ?)`),
+			o.sensorId, cid, signatureId, formatTime(timestamp))
+		if err != nil {
+			return err
+		}
+
+		if err := o.insertHeaders(tx, cid, event); err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec(o.rebind("UPDATE sensor SET last_cid = ? WHERE sid = ?"),
+		o.lastCid, o.sensorId)
+	return err
+}
+
+// firstPacket returns the first packet of the event that can be
+// dissected.
+func firstPacket(event *unified2.AggregatedEvent) *unified2.Packet {
+	for _, record := range event.Packets {
+		packet, err := record.Decode()
+		if err == nil && (packet.IPv4 != nil || packet.IPv6 != nil) {
+			return packet
+		}
+	}
+	return nil
+}
+
+// ipToInt returns an IPv4 address as an integer, as stored in the
+// iphdr table.
+func ipToInt(ip net.IP) uint32 {
+	if ip4 := ip.To4(); ip4 != nil {
+		return binary.BigEndian.Uint32(ip4)
+	}
+	return 0
+}
+
+// insertHeaders writes the header and payload tables for an event.
+// Header fields come from the first packet of the event, or if there
+// is none, from the event itself.
+func (o *Output) insertHeaders(tx *sql.Tx, cid int64,
+	event *unified2.AggregatedEvent) error {
+
+	record := event.Event
+	packet := firstPacket(event)
+
+	if packet != nil && packet.IPv4 != nil {
+		ip := packet.IPv4
+		_, err := tx.Exec(o.rebind(`INSERT INTO iphdr (sid, cid, ip_src,
+			ip_dst, ip_ver, ip_hlen, ip_tos, ip_len, ip_id, ip_flags,
+			ip_off, ip_ttl, ip_proto, ip_csum)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+			o.sensorId, cid, ipToInt(ip.Source),
+			ipToInt(ip.Destination), ip.Version, ip.HeaderLength/4,
+			ip.Tos, ip.Length, ip.Id, ip.Flags, ip.FragmentOffset,
+			ip.Ttl, ip.Protocol, ip.Checksum)
+		if err != nil {
+			return err
+		}
+	} else if record.IpSource.To4() != nil {
+		_, err := tx.Exec(o.rebind(`INSERT INTO iphdr (sid, cid, ip_src,
+			ip_dst, ip_ver, ip_proto) VALUES (?, ?, ?, ?, 4, ?)`),
+			o.sensorId, cid, ipToInt(record.IpSource),
+			ipToInt(record.IpDestination), record.Protocol)
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+	switch {
+	case packet != nil && packet.TCP != nil:
+		tcp := packet.TCP
+		_, err = tx.Exec(o.rebind(`INSERT INTO tcphdr (sid, cid,
+			tcp_sport, tcp_dport, tcp_seq, tcp_ack, tcp_off, tcp_res,
+			tcp_flags, tcp_win, tcp_csum, tcp_urp)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+			o.sensorId, cid, tcp.SourcePort, tcp.DestinationPort,
+			tcp.Seq, tcp.Ack, tcp.DataOffset, tcp.Reserved, tcp.Flags,
+			tcp.Window, tcp.Checksum, tcp.Urgent)
+	case packet != nil && packet.UDP != nil:
+		udp := packet.UDP
+		_, err = tx.Exec(o.rebind(`INSERT INTO udphdr (sid, cid,
+			udp_sport, udp_dport, udp_len, udp_csum)
+			VALUES (?, ?, ?, ?, ?, ?)`),
+			o.sensorId, cid, udp.SourcePort, udp.DestinationPort,
+			udp.Length, udp.Checksum)
+	case packet != nil && packet.ICMP != nil:
+		icmp := packet.ICMP
+		_, err = tx.Exec(o.rebind(`INSERT INTO icmphdr (sid, cid,
+			icmp_type, icmp_code, icmp_csum, icmp_id, icmp_seq)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`),
+			o.sensorId, cid, icmp.Type, icmp.Code, icmp.Checksum,
+			icmp.Id, icmp.Seq)
+	case packet == nil && record.Protocol == unified2.IPPROTO_TCP:
+		_, err = tx.Exec(o.rebind(`INSERT INTO tcphdr (sid, cid,
+			tcp_sport, tcp_dport, tcp_flags) VALUES (?, ?, ?, ?, 0)`),
+			o.sensorId, cid, record.SportItype, record.DportIcode)
+	case packet == nil && record.Protocol == unified2.IPPROTO_UDP:
+		_, err = tx.Exec(o.rebind(`INSERT INTO udphdr (sid, cid,
+			udp_sport, udp_dport) VALUES (?, ?, ?, ?)`),
+			o.sensorId, cid, record.SportItype, record.DportIcode)
+	case packet == nil && (record.Protocol == unified2.IPPROTO_ICMP ||
+		record.Protocol == unified2.IPPROTO_ICMPV6):
+		_, err = tx.Exec(o.rebind(`INSERT INTO icmphdr (sid, cid,
+			icmp_type, icmp_code) VALUES (?, ?, ?, ?)`),
+			o.sensorId, cid, record.SportItype, record.DportIcode)
+	}
+	if err != nil {
+		return err
+	}
+
+	if packet != nil && len(packet.Payload) > 0 {
+		_, err := tx.Exec(o.rebind(`INSERT INTO data (sid, cid,
+			data_payload) VALUES (?, ?, ?)`), o.sensorId, cid,
+			strings.ToUpper(hex.EncodeToString(packet.Payload)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// signatureId returns the id of the signature row for an event,
+// adding the signature, its class and references if needed.
+func (o *Output) signatureId(tx *sql.Tx, record *unified2.EventRecord) (int64, error) {
+	key := [3]uint32{record.GeneratorId, record.SignatureId,
+		record.SignatureRevision}
+	if id, ok := o.signatureIds[key]; ok {
+		return id, nil
+	}
+
+	var signature *unified2.Signature
+	if o.Signatures != nil {
+		signature = o.Signatures.Lookup(record.GeneratorId,
+			record.SignatureId)
+	}
+
+	name := fmt.Sprintf("Snort Alert [%d:%d:%d]", record.GeneratorId,
+		record.SignatureId, record.SignatureRevision)
+	var classId int64
+	if signature != nil {
+		name = signature.Msg
+		if signature.Classtype != "" {
+			var err error
+			classId, err = o.classId(tx, signature.Classtype)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	id, inserted, err := o.selectOrInsert(tx,
+		`SELECT sig_id FROM signature
+			WHERE sig_gid = ? AND sig_sid = ? AND sig_rev = ?`,
+		[]interface{}{key[0], key[1], key[2]},
+		`INSERT INTO signature (sig_name, sig_class_id, sig_priority,
+			sig_rev, sig_sid, sig_gid) VALUES (?, ?, ?, ?, ?, ?)`,
+		[]interface{}{name, classId, record.Priority, key[2], key[1],
+			key[0]})
+	if err != nil {
+		return 0, err
+	}
+
+	if inserted && signature != nil {
+		if err := o.insertReferences(tx, id, signature); err != nil {
+			return 0, err
+		}
+	}
+
+	o.signatureIds[key] = id
+	return id, nil
+}
+
+func (o *Output) classId(tx *sql.Tx, classtype string) (int64, error) {
+	if id, ok := o.classIds[classtype]; ok {
+		return id, nil
+	}
+	id, _, err := o.selectOrInsert(tx,
+		"SELECT sig_class_id FROM sig_class WHERE sig_class_name = ?",
+		[]interface{}{classtype},
+		"INSERT INTO sig_class (sig_class_name) VALUES (?)",
+		[]interface{}{classtype})
+	if err != nil {
+		return 0, err
+	}
+	o.classIds[classtype] = id
+	return id, nil
+}
+
+func (o *Output) insertReferences(tx *sql.Tx, signatureId int64,
+	signature *unified2.Signature) error {
+
+	for i, reference := range signature.References {
+		system, tag := unified2.ParseReference(reference)
+
+		systemId, ok := o.referenceSystemIds[system]
+		if !ok {
+			var err error
+			systemId, _, err = o.selectOrInsert(tx,
+				`SELECT ref_system_id FROM reference_system
+					WHERE ref_system_name = ?`,
+				[]interface{}{system},
+				`INSERT INTO reference_system (ref_system_name)
+					VALUES (?)`,
+				[]interface{}{system})
+			if err != nil {
+				return err
+			}
+			o.referenceSystemIds[system] = systemId
+		}
+
+		referenceId, _, err := o.selectOrInsert(tx,
+			`SELECT ref_id FROM reference
+				WHERE ref_system_id = ? AND ref_tag = ?`,
+			[]interface{}{systemId, tag},
+			"INSERT INTO reference (ref_system_id, ref_tag) VALUES (?, ?)",
+			[]interface{}{systemId, tag})
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(o.rebind(`INSERT INTO sig_reference
+			(sig_id, ref_seq, ref_id) VALUES (?, ?, ?)`),
+			signatureId, i+1, referenceId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,231 @@
+package barnyard2
+
+import (
+	"database/sql"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/jasonish/go-unified2"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func readEvents(t *testing.T, filename string) []*unified2.AggregatedEvent {
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var aggregator unified2.EventAggregator
+	events := []*unified2.AggregatedEvent{}
+	for {
+		record, err := unified2.ReadRecord(file)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if event := aggregator.Add(record); event != nil {
+			events = append(events, event)
+		}
+	}
+	if event := aggregator.Flush(); event != nil {
+		events = append(events, event)
+	}
+	return events
+}
+
+func openDatabase(t *testing.T) (*sql.DB, string) {
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", path.Join(tmpdir, "barnyard2.db"))
+	if err != nil {
+		os.RemoveAll(tmpdir)
+		t.Fatal(err)
+	}
+	if err := CreateSchema(db, DIALECT_SQLITE); err != nil {
+		db.Close()
+		os.RemoveAll(tmpdir)
+		t.Fatal(err)
+	}
+	return db, tmpdir
+}
+
+func count(t *testing.T, db *sql.DB, table string) int {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestOutput(t *testing.T) {
+	db, tmpdir := openDatabase(t)
+	defer os.RemoveAll(tmpdir)
+	defer db.Close()
+
+	events := readEvents(t, "../test/multi-record-event-x2.log")
+	event := events[0].Event
+
+	output, err := New(db, DIALECT_SQLITE, "sensor1", "eth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	output.Signatures = unified2.NewSignatureMap()
+	output.Signatures.Add(&unified2.Signature{
+		GeneratorId: event.GeneratorId,
+		SignatureId: event.SignatureId,
+		Revision:    event.SignatureRevision,
+		Msg:         "TEST SIGNATURE",
+		Classtype:   "misc-activity",
+		References:  []string{"url,www.example.com", "cve,2014-0160"},
+	})
+
+	if err := output.Insert(events); err != nil {
+		t.Fatal(err)
+	}
+
+	for table, expected := range map[string]int{
+		"sensor":           1,
+		"event":            2,
+		"signature":        1,
+		"sig_class":        1,
+		"sig_reference":    2,
+		"reference":        2,
+		"reference_system": 2,
+		"iphdr":            2,
+		"tcphdr":           2,
+		"udphdr":           0,
+		"data":             2,
+	} {
+		if n := count(t, db, table); n != expected {
+			t.Fatalf("expected %d rows in %s, got %d", expected, table, n)
+		}
+	}
+
+	var name string
+	var src, dst int64
+	var sport, dport int
+	err = db.QueryRow(`SELECT sig_name, ip_src, ip_dst, tcp_sport, tcp_dport
+		FROM event
+		JOIN signature ON event.signature = signature.sig_id
+		JOIN iphdr ON event.sid = iphdr.sid AND event.cid = iphdr.cid
+		JOIN tcphdr ON event.sid = tcphdr.sid AND event.cid = tcphdr.cid
+		WHERE event.cid = 1`).Scan(&name, &src, &dst, &sport, &dport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "TEST SIGNATURE" {
+		t.Fatalf("unexpected signature name %q", name)
+	}
+	if uint32(src) != ipToInt(event.IpSource) ||
+		uint32(dst) != ipToInt(event.IpDestination) {
+		t.Fatalf("unexpected addresses %d, %d", src, dst)
+	}
+	if sport != int(event.SportItype) || dport != int(event.DportIcode) {
+		t.Fatalf("unexpected ports %d, %d", sport, dport)
+	}
+
+	// A new output for the same sensor continues the event ids.
+	output, err = New(db, DIALECT_SQLITE, "sensor1", "eth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := output.Insert(events[:1]); err != nil {
+		t.Fatal(err)
+	}
+	var cid int
+	if err := db.QueryRow("SELECT MAX(cid) FROM event").Scan(&cid); err != nil {
+		t.Fatal(err)
+	}
+	if cid != 3 {
+		t.Fatalf("expected last cid 3, got %d", cid)
+	}
+	if n := count(t, db, "sensor"); n != 1 {
+		t.Fatalf("expected 1 sensor, got %d", n)
+	}
+}
+
+func TestOutputNoPackets(t *testing.T) {
+	db, tmpdir := openDatabase(t)
+	defer os.RemoveAll(tmpdir)
+	defer db.Close()
+
+	events := readEvents(t, "../test/multi-record-event-x2.log")
+	events[0].Packets = nil
+
+	output, err := New(db, DIALECT_SQLITE, "sensor1", "eth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := output.Insert(events[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	var name string
+	var sport int
+	err = db.QueryRow(`SELECT sig_name, tcp_sport FROM event
+		JOIN signature ON event.signature = signature.sig_id
+		JOIN tcphdr ON event.sid = tcphdr.sid AND event.cid = tcphdr.cid`).
+		Scan(&name, &sport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "Snort Alert [120:3:1]" {
+		t.Fatalf("unexpected signature name %q", name)
+	}
+	if sport != int(events[0].Event.SportItype) {
+		t.Fatalf("unexpected port %d", sport)
+	}
+	if n := count(t, db, "data"); n != 0 {
+		t.Fatalf("expected no data, got %d", n)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	statement := "SELECT a FROM b WHERE c = ? AND d = ?"
+	if rebind(DIALECT_MYSQL, statement) != statement {
+		t.Fatal("mysql statement should not change")
+	}
+	expected := "SELECT a FROM b WHERE c = $1 AND d = $2"
+	if s := rebind(DIALECT_POSTGRESQL, statement); s != expected {
+		t.Fatalf("unexpected statement %q", s)
+	}
+}
+
+// Only the SQLite schema is created by the tests, so check the quoting
+// of the schema table, a reserved word in MySQL, in the generated SQL.
+func TestSchemaStatements(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		create  string
+		insert  string
+	}{
+		{DIALECT_MYSQL, "CREATE TABLE `schema` (",
+			"INSERT INTO `schema` (vseq, ctime) VALUES (?, ?)"},
+		{DIALECT_POSTGRESQL, `CREATE TABLE "schema" (`,
+			`INSERT INTO "schema" (vseq, ctime) VALUES ($1, $2)`},
+		{DIALECT_SQLITE, `CREATE TABLE "schema" (`,
+			`INSERT INTO "schema" (vseq, ctime) VALUES (?, ?)`},
+	}
+	for _, test := range tests {
+		statements := schemaStatements(test.dialect)
+		if !strings.HasPrefix(statements[0], test.create) {
+			t.Fatalf("unexpected statement %q", statements[0])
+		}
+		if insert := statements[len(statements)-1]; insert != test.insert {
+			t.Fatalf("unexpected statement %q", insert)
+		}
+		for _, statement := range statements {
+			if strings.Contains(statement, "{") {
+				t.Fatalf("unreplaced placeholder in %q", statement)
+			}
+		}
+	}
+}
@@ -0,0 +1,224 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package barnyard2
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SCHEMA_VERSION is the barnyard2 schema version written and expected.
+const SCHEMA_VERSION = 107
+
+// Dialect selects the SQL dialect of the database.
+type Dialect int
+
+// Supported database dialects.
+const (
+	DIALECT_MYSQL Dialect = iota
+	DIALECT_POSTGRESQL
+	DIALECT_SQLITE
+)
+
+// Column types, and the quoting of the schema table whose name is a
+// reserved word in MySQL, that differ between dialects.
+var dialectTypes = map[Dialect]*strings.Replacer{
+	DIALECT_MYSQL: strings.NewReplacer(
+		"{SERIAL}", "INT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY",
+		"{DATETIME}", "DATETIME",
+		"{SCHEMA}", "`schema`"),
+	DIALECT_POSTGRESQL: strings.NewReplacer(
+		"{SERIAL}", "SERIAL PRIMARY KEY",
+		"{DATETIME}", "TIMESTAMP",
+		"{SCHEMA}", `"schema"`),
+	DIALECT_SQLITE: strings.NewReplacer(
+		"{SERIAL}", "INTEGER PRIMARY KEY",
+		"{DATETIME}", "DATETIME",
+		"{SCHEMA}", `"schema"`),
+}
+
+var schema = []string{
+	`CREATE TABLE {SCHEMA} (
+		vseq BIGINT NOT NULL,
+		ctime {DATETIME} NOT NULL,
+		PRIMARY KEY (vseq))`,
+	`CREATE TABLE event (
+		sid BIGINT NOT NULL,
+		cid BIGINT NOT NULL,
+		signature BIGINT NOT NULL,
+		timestamp {DATETIME} NOT NULL,
+		PRIMARY KEY (sid, cid))`,
+	`CREATE INDEX event_sig ON event (signature)`,
+	`CREATE INDEX event_time ON event (timestamp)`,
+	`CREATE TABLE signature (
+		sig_id {SERIAL},
+		sig_name VARCHAR(255) NOT NULL,
+		sig_class_id BIGINT NOT NULL,
+		sig_priority BIGINT,
+		sig_rev BIGINT,
+		sig_sid BIGINT,
+		sig_gid BIGINT)`,
+	`CREATE INDEX sig_name_idx ON signature (sig_name)`,
+	`CREATE INDEX sig_class_id_idx ON signature (sig_class_id)`,
+	`CREATE TABLE sig_reference (
+		sig_id BIGINT NOT NULL,
+		ref_seq BIGINT NOT NULL,
+		ref_id BIGINT NOT NULL,
+		PRIMARY KEY (sig_id, ref_seq))`,
+	`CREATE TABLE reference (
+		ref_id {SERIAL},
+		ref_system_id BIGINT NOT NULL,
+		ref_tag TEXT NOT NULL)`,
+	`CREATE TABLE reference_system (
+		ref_system_id {SERIAL},
+		ref_system_name VARCHAR(20))`,
+	`CREATE TABLE sig_class (
+		sig_class_id {SERIAL},
+		sig_class_name VARCHAR(60) NOT NULL)`,
+	`CREATE TABLE sensor (
+		sid {SERIAL},
+		hostname TEXT,
+		interface TEXT,
+		filter TEXT,
+		detail SMALLINT,
+		encoding SMALLINT,
+		last_cid BIGINT NOT NULL)`,
+	`CREATE TABLE iphdr (
+		sid BIGINT NOT NULL,
+		cid BIGINT NOT NULL,
+		ip_src BIGINT NOT NULL,
+		ip_dst BIGINT NOT NULL,
+		ip_ver SMALLINT,
+		ip_hlen SMALLINT,
+		ip_tos SMALLINT,
+		ip_len INTEGER,
+		ip_id INTEGER,
+		ip_flags SMALLINT,
+		ip_off INTEGER,
+		ip_ttl SMALLINT,
+		ip_proto SMALLINT NOT NULL,
+		ip_csum INTEGER,
+		PRIMARY KEY (sid, cid))`,
+	`CREATE INDEX ip_src ON iphdr (ip_src)`,
+	`CREATE INDEX ip_dst ON iphdr (ip_dst)`,
+	`CREATE TABLE tcphdr (
+		sid BIGINT NOT NULL,
+		cid BIGINT NOT NULL,
+		tcp_sport INTEGER NOT NULL,
+		tcp_dport INTEGER NOT NULL,
+		tcp_seq BIGINT,
+		tcp_ack BIGINT,
+		tcp_off SMALLINT,
+		tcp_res SMALLINT,
+		tcp_flags SMALLINT NOT NULL,
+		tcp_win INTEGER,
+		tcp_csum INTEGER,
+		tcp_urp INTEGER,
+		PRIMARY KEY (sid, cid))`,
+	`CREATE TABLE udphdr (
+		sid BIGINT NOT NULL,
+		cid BIGINT NOT NULL,
+		udp_sport INTEGER NOT NULL,
+		udp_dport INTEGER NOT NULL,
+		udp_len INTEGER,
+		udp_csum INTEGER,
+		PRIMARY KEY (sid, cid))`,
+	`CREATE TABLE icmphdr (
+		sid BIGINT NOT NULL,
+		cid BIGINT NOT NULL,
+		icmp_type SMALLINT NOT NULL,
+		icmp_code SMALLINT NOT NULL,
+		icmp_csum INTEGER,
+		icmp_id INTEGER,
+		icmp_seq INTEGER,
+		PRIMARY KEY (sid, cid))`,
+	`CREATE TABLE data (
+		sid BIGINT NOT NULL,
+		cid BIGINT NOT NULL,
+		data_payload TEXT,
+		PRIMARY KEY (sid, cid))`,
+}
+
+// CreateSchema creates the barnyard2 tables in an empty database.
+//
+// Existing barnyard2 databases, created with the scripts distributed
+// with barnyard2, can be used as is.  Tables only used by barnyard2
+// for packet options and detail levels are not created.
+func CreateSchema(db *sql.DB, dialect Dialect) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	statements := schemaStatements(dialect)
+	last := len(statements) - 1
+	for _, statement := range statements[:last] {
+		if _, err := tx.Exec(statement); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	_, err = tx.Exec(statements[last], SCHEMA_VERSION,
+		formatTime(time.Now()))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// schemaStatements returns the statements creating the schema in a
+// dialect, the last of which inserts the schema version.
+func schemaStatements(dialect Dialect) []string {
+	types := dialectTypes[dialect]
+	var statements []string
+	for _, statement := range schema {
+		statements = append(statements, types.Replace(statement))
+	}
+	return append(statements, rebind(dialect, types.Replace(
+		"INSERT INTO {SCHEMA} (vseq, ctime) VALUES (?, ?)")))
+}
+
+// rebind rewrites the ? placeholders of a statement into the form
+// used by the dialect.
+func rebind(dialect Dialect, statement string) string {
+	if dialect != DIALECT_POSTGRESQL {
+		return statement
+	}
+	parts := strings.Split(statement, "?")
+	buf := []string{parts[0]}
+	for i, part := range parts[1:] {
+		buf = append(buf, "$"+strconv.Itoa(i+1), part)
+	}
+	return strings.Join(buf, "")
+}
+
+// formatTime formats a time as barnyard2 does, in UTC.
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
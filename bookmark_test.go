@@ -0,0 +1,32 @@
+package unified2
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestBookmark(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	filename := tmpdir + "/bookmark.json"
+	if _, err := ReadBookmark(filename); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, got %v", err)
+	}
+
+	err = WriteBookmark(filename, &Bookmark{"unified2.log.1382627900", 68})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bookmark, err := ReadBookmark(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *bookmark != (Bookmark{"unified2.log.1382627900", 68}) {
+		t.Fatalf("unexpected bookmark: %+v", bookmark)
+	}
+}
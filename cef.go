@@ -0,0 +1,194 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// CEFSeverity maps an event priority to a CEF/LEEF severity of 0 to
+// 10, where priority 1 is the most severe.
+func CEFSeverity(priority uint32) int {
+	switch priority {
+	case 1:
+		return 10
+	case 2:
+		return 7
+	case 3:
+		return 4
+	}
+	return 1
+}
+
+// eventField is a key and value of a formatted event.
+type eventField struct {
+	key   string
+	value string
+}
+
+// eventName returns the signature message of an event, or a generic
+// name if the signature is not known.
+func eventName(event *EventRecord, signatures *SignatureMap) string {
+	if signatures != nil {
+		if signature := signatures.Lookup(event.GeneratorId,
+			event.SignatureId); signature != nil {
+			return signature.Msg
+		}
+	}
+	return fmt.Sprintf("Snort Alert [%d:%d:%d]", event.GeneratorId,
+		event.SignatureId, event.SignatureRevision)
+}
+
+// eventClasstype returns the classtype of an event's signature if
+// known.
+func eventClasstype(event *EventRecord, signatures *SignatureMap) string {
+	if signatures != nil {
+		if signature := signatures.Lookup(event.GeneratorId,
+			event.SignatureId); signature != nil {
+			return signature.Classtype
+		}
+	}
+	return ""
+}
+
+// eventAction describes the Blocked field of an event.
+func eventAction(event *EventRecord) string {
+	switch event.BlockedStatus() {
+	case BLOCKED_STATUS_BLOCKED:
+		return "blocked"
+	case BLOCKED_STATUS_WOULD_BLOCK:
+		return "would-block"
+	}
+	return "alert"
+}
+
+// CEFFormatter formats events in ArcSight Common Event Format.
+//
+// CEFFormatters should be created with NewCEFFormatter().
+type CEFFormatter struct {
+	Vendor  string
+	Product string
+	Version string
+
+	// Signatures, if set, are used to name events and categorize
+	// them by classtype.
+	Signatures *SignatureMap
+}
+
+// NewCEFFormatter creates a new CEFFormatter for Snort events.
+func NewCEFFormatter() *CEFFormatter {
+	return &CEFFormatter{
+		Vendor:  "Snort",
+		Product: "Snort",
+		Version: "2",
+	}
+}
+
+var cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`,
+	"\r", " ", "\n", " ")
+
+var cefValueEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`,
+	"\r", `\r`, "\n", `\n`)
+
+// Format formats an event and its extra data records, which may be
+// nil, as a CEF line without a trailing newline.
+func (f *CEFFormatter) Format(event *EventRecord, extra []*ExtraDataRecord) string {
+	fields := []eventField{
+		{"rt", strconv.FormatInt(int64(event.EventSecond)*1000+
+			int64(event.EventMicrosecond)/1000, 10)},
+	}
+
+	if event.IpSource.To4() != nil {
+		fields = append(fields, eventField{"src", event.IpSource.String()})
+	} else {
+		fields = append(fields,
+			eventField{"c6a2", event.IpSource.String()},
+			eventField{"c6a2Label", "Source IPv6 Address"})
+	}
+	if event.IpDestination.To4() != nil {
+		fields = append(fields,
+			eventField{"dst", event.IpDestination.String()})
+	} else {
+		fields = append(fields,
+			eventField{"c6a3", event.IpDestination.String()},
+			eventField{"c6a3Label", "Destination IPv6 Address"})
+	}
+	if event.HasPorts() {
+		fields = append(fields,
+			eventField{"spt", strconv.Itoa(int(event.SportItype))},
+			eventField{"dpt", strconv.Itoa(int(event.DportIcode))})
+	}
+	fields = append(fields,
+		eventField{"proto", event.ProtocolName()},
+		eventField{"act", eventAction(event)})
+	if classtype := eventClasstype(event, f.Signatures); classtype != "" {
+		fields = append(fields, eventField{"cat", classtype})
+	}
+	fields = append(fields,
+		eventField{"cn1", strconv.Itoa(int(event.SensorId))},
+		eventField{"cn1Label", "sensorId"},
+		eventField{"cn2", strconv.Itoa(int(event.VlanId))},
+		eventField{"cn2Label", "vlanId"},
+		eventField{"cn3", strconv.Itoa(int(event.Priority))},
+		eventField{"cn3Label", "priority"})
+	if event.AppId != "" {
+		fields = append(fields,
+			eventField{"cs1", event.AppId},
+			eventField{"cs1Label", "appId"})
+	}
+	if xff := XffAddress(extra); xff != nil {
+		fields = append(fields,
+			eventField{"cs2", xff.String()},
+			eventField{"cs2Label", "xForwardedFor"})
+	}
+
+	extension := make([]string, len(fields))
+	for i, field := range fields {
+		extension[i] = field.key + "=" + cefValueEscaper.Replace(field.value)
+	}
+
+	return fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s",
+		cefHeaderEscaper.Replace(f.Vendor),
+		cefHeaderEscaper.Replace(f.Product),
+		cefHeaderEscaper.Replace(f.Version),
+		fmt.Sprintf("%d:%d:%d", event.GeneratorId, event.SignatureId,
+			event.SignatureRevision),
+		cefHeaderEscaper.Replace(eventName(event, f.Signatures)),
+		CEFSeverity(event.Priority),
+		strings.Join(extension, " "))
+}
+
+// Write writes a formatted event to writer followed by a newline.
+// A SyslogWriter can be used to send the event over syslog.
+func (f *CEFFormatter) Write(writer io.Writer, event *EventRecord,
+	extra []*ExtraDataRecord) error {
+	_, err := io.WriteString(writer, f.Format(event, extra)+"\n")
+	return err
+}
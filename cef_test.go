@@ -0,0 +1,91 @@
+package unified2
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+func testXffRecord() *ExtraDataRecord {
+	return &ExtraDataRecord{
+		Type: EXTRA_DATA_TYPE_XFF_IPV4,
+		Data: []byte(net.ParseIP("192.168.1.1").To4()),
+	}
+}
+
+func TestCEFFormatter(t *testing.T) {
+	event := loadTestEvent(t)
+	event.AppId = "http"
+
+	signatures := NewSignatureMap()
+	signatures.Add(&Signature{GeneratorId: 120, SignatureId: 3,
+		Msg: `Pipe | and back\slash`, Classtype: "bad=class"})
+
+	formatter := NewCEFFormatter()
+	formatter.Signatures = signatures
+
+	var buf bytes.Buffer
+	err := formatter.Write(&buf, event, []*ExtraDataRecord{testXffRecord()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `CEF:0|Snort|Snort|2|120:3:1|Pipe \| and back\\slash|4|` +
+		`rt=964798804267 src=207.25.71.28 dst=10.20.11.123 spt=80 ` +
+		`dpt=2651 proto=TCP act=alert cat=bad\=class cn1=0 ` +
+		`cn1Label=sensorId cn2=0 cn2Label=vlanId cn3=3 ` +
+		`cn3Label=priority cs1=http cs1Label=appId cs2=192.168.1.1 ` +
+		`cs2Label=xForwardedFor` + "\n"
+	if buf.String() != expected {
+		t.Fatalf("unexpected output:\n%s\nexpected:\n%s", buf.String(),
+			expected)
+	}
+}
+
+func TestCEFFormatterIPv6(t *testing.T) {
+	event := loadTestEvent(t)
+	event.IpSource = net.ParseIP("2001:db8::1")
+	event.IpDestination = net.ParseIP("2001:db8::2")
+	event.Protocol = 58
+
+	line := NewCEFFormatter().Format(event, nil)
+	if !strings.Contains(line, "c6a2=2001:db8::1 ") ||
+		!strings.Contains(line, "c6a3=2001:db8::2 ") ||
+		strings.Contains(line, "spt=") ||
+		!strings.Contains(line, "proto=ICMPv6") {
+		t.Fatalf("unexpected output: %s", line)
+	}
+}
+
+func TestLEEFFormatter(t *testing.T) {
+	event := loadTestEvent(t)
+	event.Blocked = 1
+
+	formatter := NewLEEFFormatter()
+	formatter.Delimiter = '^'
+	formatter.Signatures = NewSignatureMap()
+	formatter.Signatures.Add(&Signature{GeneratorId: 120, SignatureId: 3,
+		Msg: "Caret ^ in message"})
+
+	line := formatter.Format(event, []*ExtraDataRecord{testXffRecord()})
+	expected := `LEEF:2.0|Snort|Snort|2|120:3:1|x5E|devTime=964798804267^` +
+		`devTimeFormat=epoch^src=207.25.71.28^dst=10.20.11.123^` +
+		`srcPort=80^dstPort=2651^proto=TCP^sev=4^` +
+		`name=Caret \^ in message^action=blocked^sensorId=0^vlan=0^` +
+		`priority=3^xForwardedFor=192.168.1.1`
+	if line != expected {
+		t.Fatalf("unexpected output:\n%s\nexpected:\n%s", line, expected)
+	}
+
+	line = NewLEEFFormatter().Format(event, nil)
+	if !strings.HasPrefix(line, "LEEF:2.0|Snort|Snort|2|120:3:1|x09|devTime=") {
+		t.Fatalf("unexpected output: %s", line)
+	}
+	// Only ICMP events have a type and code.
+	event.Protocol = 47
+	line = NewLEEFFormatter().Format(event, nil)
+	if strings.Contains(line, "icmpType=") || strings.Contains(line, "srcPort=") {
+		t.Fatalf("unexpected output: %s", line)
+	}
+}
@@ -31,7 +31,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
-	"log"
+	"net"
 )
 
 // DecodingError is the error returned if an error is encountered
@@ -52,6 +52,14 @@ func read(reader io.Reader, data interface{}) error {
 // This function will decode any of the event record types.
 func DecodeEventRecord(eventType uint32, data []byte) (*EventRecord, error) {
 
+	if !isEventType(eventType) {
+		return nil, DecodingError
+	}
+
+	if eventType == UNIFIED2_EVENT_V3 {
+		return decodeEventV3Record(data)
+	}
+
 	event := &EventRecord{}
 
 	reader := bytes.NewBuffer(data)
@@ -98,10 +106,10 @@ func DecodeEventRecord(eventType uint32, data []byte) (*EventRecord, error) {
 	/* Source and destination IP addresses. */
 	switch eventType {
 
-	case UNIFIED2_EVENT, UNIFIED2_EVENT_V2, UNIFIED2_EVENT_APPID:
+	case UNIFIED2_EVENT, UNIFIED2_EVENT_V2, UNIFIED2_EVENT_APPID,
+		UNIFIED2_EVENT_MPLS:
 		event.IpSource = make([]byte, 4)
 		if err := read(reader, &event.IpSource); err != nil {
-			log.Fatal(err)
 			return nil, err
 		}
 		event.IpDestination = make([]byte, 4)
@@ -109,7 +117,8 @@ func DecodeEventRecord(eventType uint32, data []byte) (*EventRecord, error) {
 			return nil, err
 		}
 
-	case UNIFIED2_EVENT_IP6, UNIFIED2_EVENT_V2_IP6, UNIFIED2_EVENT_APPID_IP6:
+	case UNIFIED2_EVENT_IP6, UNIFIED2_EVENT_V2_IP6, UNIFIED2_EVENT_APPID_IP6,
+		UNIFIED2_EVENT_MPLS_IP6:
 		event.IpSource = make([]byte, 16)
 		if err := read(reader, &event.IpSource); err != nil {
 			return nil, err
@@ -154,7 +163,9 @@ func DecodeEventRecord(eventType uint32, data []byte) (*EventRecord, error) {
 	case UNIFIED2_EVENT_V2,
 		UNIFIED2_EVENT_V2_IP6,
 		UNIFIED2_EVENT_APPID,
-		UNIFIED2_EVENT_APPID_IP6:
+		UNIFIED2_EVENT_APPID_IP6,
+		UNIFIED2_EVENT_MPLS,
+		UNIFIED2_EVENT_MPLS_IP6:
 
 		/* MplsLabel. */
 		if err := read(reader, &event.MplsLabel); err != nil {
@@ -186,10 +197,85 @@ func DecodeEventRecord(eventType uint32, data []byte) (*EventRecord, error) {
 	return event, nil
 }
 
+// The length of a Snort 3 event v3 record.
+const EVENT_V3_RECORD_LEN = 160
+
+// decodeEventV3Record decodes a Snort 3 event v3 record.  These
+// records always hold 16 byte addresses, IPv4 addresses are returned
+// as 4 bytes.
+func decodeEventV3Record(data []byte) (*EventRecord, error) {
+	if len(data) < EVENT_V3_RECORD_LEN {
+		return nil, DecodingError
+	}
+
+	event := &EventRecord{
+		SensorId:          binary.BigEndian.Uint32(data[0:]),
+		EventId:           binary.BigEndian.Uint32(data[4:]),
+		EventSecond:       binary.BigEndian.Uint32(data[8:]),
+		EventMicrosecond:  binary.BigEndian.Uint32(data[12:]),
+		GeneratorId:       binary.BigEndian.Uint32(data[16:]),
+		SignatureId:       binary.BigEndian.Uint32(data[20:]),
+		SignatureRevision: binary.BigEndian.Uint32(data[24:]),
+		ClassificationId:  binary.BigEndian.Uint32(data[28:]),
+		Priority:          binary.BigEndian.Uint32(data[32:]),
+		PolicyIdContext:   binary.BigEndian.Uint32(data[36:]),
+		PolicyIdInspect:   binary.BigEndian.Uint32(data[40:]),
+		PolicyIdDetect:    binary.BigEndian.Uint32(data[44:]),
+		MplsLabel:         binary.BigEndian.Uint32(data[80:]),
+		SportItype:        binary.BigEndian.Uint16(data[84:]),
+		DportIcode:        binary.BigEndian.Uint16(data[86:]),
+		VlanId:            binary.BigEndian.Uint16(data[88:]),
+		Pad2:              binary.BigEndian.Uint16(data[90:]),
+		Protocol:          data[93],
+		Status:            data[94],
+		Action:            data[95],
+	}
+
+	// The high nibble of the IP version is the version of the source
+	// address, the low nibble the destination.
+	version := data[92]
+	event.IpSource = eventV3Address(data[48:64], version>>4)
+	event.IpDestination = eventV3Address(data[64:80], version&0x0f)
+
+	// Map the status and action onto the blocked field of older
+	// events.
+	switch {
+	case event.Status == EVENT_STATUS_WOULD:
+		event.Blocked = 2
+	case event.Action == EVENT_ACTION_DROP ||
+		event.Action == EVENT_ACTION_BLOCK ||
+		event.Action == EVENT_ACTION_RESET:
+		event.Blocked = 1
+	}
+
+	appid := data[96:EVENT_V3_RECORD_LEN]
+	if end := bytes.IndexByte(appid, 0); end >= 0 {
+		appid = appid[:end]
+	}
+	event.AppId = string(appid)
+
+	return event, nil
+}
+
+// eventV3Address returns an event v3 address.  IPv4 addresses are
+// stored in the last 4 bytes.
+func eventV3Address(address []byte, version uint8) net.IP {
+	ip := make(net.IP, 16)
+	copy(ip, address)
+	if version == 4 {
+		return ip[12:]
+	}
+	return ip
+}
+
 // DecodePacketRecord decodes a raw unified2 record into a
 // PacketRecord.
 func DecodePacketRecord(data []byte) (packet *PacketRecord, err error) {
 
+	if len(data) < PACKET_RECORD_HDR_LEN {
+		return nil, DecodingError
+	}
+
 	packet = &PacketRecord{}
 
 	reader := bytes.NewBuffer(data)
@@ -230,10 +316,24 @@ error:
 	return nil, DecodingError
 }
 
+// DecodeBufferRecord decodes a raw unified2 buffer record into a
+// BufferRecord.
+func DecodeBufferRecord(data []byte) (*BufferRecord, error) {
+	packet, err := DecodePacketRecord(data)
+	if err != nil {
+		return nil, err
+	}
+	return (*BufferRecord)(packet), nil
+}
+
 // DecodeExtraDataRecord decodes a raw extra data record into an
 // ExtraDataRecord.
 func DecodeExtraDataRecord(data []byte) (extra *ExtraDataRecord, err error) {
 
+	if len(data) < EXTRA_DATA_RECORD_HDR_LEN {
+		return nil, DecodingError
+	}
+
 	extra = &ExtraDataRecord{}
 
 	reader := bytes.NewBuffer(data)
@@ -277,3 +377,31 @@ func DecodeExtraDataRecord(data []byte) (extra *ExtraDataRecord, err error) {
 error:
 	return nil, DecodingError
 }
+
+// DecodeAppStatRecord decodes a raw application statistics record into
+// an AppStatRecord.
+func DecodeAppStatRecord(data []byte) (*AppStatRecord, error) {
+	if len(data) < APPSTAT_RECORD_HDR_LEN {
+		return nil, DecodingError
+	}
+	record := &AppStatRecord{
+		TimestampSeconds: binary.BigEndian.Uint32(data[0:]),
+	}
+	count := binary.BigEndian.Uint32(data[4:])
+	if uint64(count) > uint64(len(data)-APPSTAT_RECORD_HDR_LEN)/APPSTAT_LEN {
+		return nil, DecodingError
+	}
+	for i := 0; i < int(count); i++ {
+		stat := data[APPSTAT_RECORD_HDR_LEN+i*APPSTAT_LEN:]
+		name := stat[:64]
+		if end := bytes.IndexByte(name, 0); end >= 0 {
+			name = name[:end]
+		}
+		record.Stats = append(record.Stats, AppStat{
+			AppName: string(name),
+			TxBytes: binary.BigEndian.Uint32(stat[64:]),
+			RxBytes: binary.BigEndian.Uint32(stat[68:]),
+		})
+	}
+	return record, nil
+}
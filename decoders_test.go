@@ -0,0 +1,203 @@
+package unified2
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Read all the decoded records of a file.
+func readRecords(t *testing.T, filename string) []interface{} {
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	records := []interface{}{}
+	for {
+		record, err := ReadRecord(file)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := record.(*RawRecord); ok {
+			t.Fatal("record type not decoded")
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestDecodeEventMpls(t *testing.T) {
+	records := readRecords(t, "test/event-mpls.log")
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(records))
+	}
+
+	event, ok := records[0].(*EventRecord)
+	if !ok {
+		t.Fatalf("expected *EventRecord, got %T", records[0])
+	}
+	if event.MplsLabel != 1234 {
+		t.Fatalf("unexpected mpls label %d", event.MplsLabel)
+	}
+	if len(event.IpSource) != 4 {
+		t.Fatal("expected IPv4 addresses")
+	}
+	if _, ok := records[1].(*PacketRecord); !ok {
+		t.Fatalf("expected *PacketRecord, got %T", records[1])
+	}
+
+	event, ok = records[2].(*EventRecord)
+	if !ok {
+		t.Fatalf("expected *EventRecord, got %T", records[2])
+	}
+	if !event.IpSource.Equal(net.ParseIP("2001:db8::1")) ||
+		!event.IpDestination.Equal(net.ParseIP("2001:db8::2")) {
+		t.Fatalf("unexpected addresses %s -> %s", event.IpSource,
+			event.IpDestination)
+	}
+	if event.MplsLabel != 5678 || event.VlanId != 100 {
+		t.Fatalf("unexpected mpls label %d or vlan %d", event.MplsLabel,
+			event.VlanId)
+	}
+}
+
+func TestDecodeEventV3(t *testing.T) {
+	records := readRecords(t, "test/event-v3.log")
+	if len(records) != 4 {
+		t.Fatalf("expected 4 records, got %d", len(records))
+	}
+
+	event, ok := records[0].(*EventRecord)
+	if !ok {
+		t.Fatalf("expected *EventRecord, got %T", records[0])
+	}
+	if event.GeneratorId != 1 || event.SignatureId != 1000001 ||
+		event.SignatureRevision != 2 {
+		t.Fatalf("unexpected signature %d:%d:%d", event.GeneratorId,
+			event.SignatureId, event.SignatureRevision)
+	}
+	if event.PolicyIdContext != 10 || event.PolicyIdInspect != 11 ||
+		event.PolicyIdDetect != 12 {
+		t.Fatal("unexpected policy ids")
+	}
+	if len(event.IpSource) != 4 ||
+		!event.IpSource.Equal(net.ParseIP("10.1.1.1")) ||
+		!event.IpDestination.Equal(net.ParseIP("10.2.2.2")) {
+		t.Fatalf("unexpected addresses %s -> %s", event.IpSource,
+			event.IpDestination)
+	}
+	if event.SportItype != 80 || event.DportIcode != 40000 ||
+		event.Protocol != 6 || event.VlanId != 7 {
+		t.Fatal("unexpected ports, protocol or vlan")
+	}
+	if event.Action != EVENT_ACTION_BLOCK || event.Blocked != 1 {
+		t.Fatalf("unexpected action %d, blocked %d", event.Action,
+			event.Blocked)
+	}
+	if event.AppId != "HTTP" {
+		t.Fatalf("unexpected appid %q", event.AppId)
+	}
+
+	buffer, ok := records[2].(*BufferRecord)
+	if !ok {
+		t.Fatalf("expected *BufferRecord, got %T", records[2])
+	}
+	if !strings.HasPrefix(string(buffer.Data), "GET /index.html") ||
+		int(buffer.Length) != len(buffer.Data) {
+		t.Fatal("unexpected buffer data")
+	}
+
+	event, ok = records[3].(*EventRecord)
+	if !ok {
+		t.Fatalf("expected *EventRecord, got %T", records[3])
+	}
+	if len(event.IpSource) != 16 ||
+		!event.IpSource.Equal(net.ParseIP("2001:db8::1")) {
+		t.Fatalf("unexpected source address %s", event.IpSource)
+	}
+	if event.Status != EVENT_STATUS_WOULD || event.Blocked != 2 {
+		t.Fatalf("unexpected status %d, blocked %d", event.Status,
+			event.Blocked)
+	}
+	if event.AppId != "" {
+		t.Fatalf("unexpected appid %q", event.AppId)
+	}
+
+	if _, err := DecodeEventRecord(UNIFIED2_EVENT_V3,
+		make([]byte, EVENT_V3_RECORD_LEN-1)); err != DecodingError {
+		t.Fatalf("expected DecodingError, got %v", err)
+	}
+}
+
+func TestNewRecordTypes(t *testing.T) {
+	for _, filename := range []string{
+		"test/event-mpls.log",
+		"test/event-v3.log",
+	} {
+		report := validateFile(t, filename)
+		if !report.Valid() {
+			t.Fatalf("%s: unexpected issues: %v", filename, report.Issues)
+		}
+	}
+
+	events := readAggregatedEvents(t, "test/event-v3.log")
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if len(events[0].Packets) != 1 || len(events[0].Buffers) != 1 {
+		t.Fatalf("expected 1 packet and 1 buffer, got %d and %d",
+			len(events[0].Packets), len(events[0].Buffers))
+	}
+}
+
+func TestDecodeAppStatAndUnknownRecords(t *testing.T) {
+	stat := make([]byte, APPSTAT_LEN)
+	copy(stat, "HTTP")
+	stat[67], stat[71] = 10, 20
+	appstat := append([]byte{0, 0, 0, 100, 0, 0, 0, 1}, stat...)
+
+	var buf bytes.Buffer
+	for _, record := range []*RawRecord{
+		{UNIFIED2_IDS_EVENT_APPSTAT, appstat},
+		{200, []byte{1, 2, 3, 4}},
+	} {
+		if err := WriteRawRecord(&buf, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := memoryFile{bytes.NewReader(buf.Bytes())}
+
+	record, err := ReadRecord(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, ok := record.(*AppStatRecord)
+	if !ok {
+		t.Fatalf("expected *AppStatRecord, got %T", record)
+	}
+	if decoded.TimestampSeconds != 100 || len(decoded.Stats) != 1 ||
+		decoded.Stats[0] != (AppStat{"HTTP", 10, 20}) {
+		t.Fatalf("unexpected record: %+v", decoded)
+	}
+
+	// Unknown record types are returned raw rather than as nil.
+	record, err = ReadRecord(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, ok := record.(*RawRecord)
+	if !ok || raw.Type != 200 || len(raw.Data) != 4 {
+		t.Fatalf("expected raw record of type 200, got %+v", record)
+	}
+
+	if _, err := DecodeAppStatRecord(appstat[:len(appstat)-1]); err != DecodingError {
+		t.Fatalf("expected DecodingError, got %v", err)
+	}
+}
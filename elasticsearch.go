@@ -0,0 +1,243 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ElasticsearchSink indexes events into Elasticsearch or OpenSearch
+// using the _bulk API.
+//
+// Its Send method can be used as the Send function of a SpoolBatcher
+// so the spool bookmark only advances once a batch is indexed.  Events
+// are indexed with an id made from their sensor id, event id and
+// second, so a batch sent again does not index its events twice.
+//
+// ElasticsearchSinks should be created with NewElasticsearchSink().
+type ElasticsearchSink struct {
+	// URL is the base URL of the server, such as
+	// "http://localhost:9200".
+	URL string
+
+	// Events are indexed into IndexPrefix followed by the event date
+	// formatted with the Go time layout IndexDateFormat, in UTC.
+	// With an empty IndexDateFormat all events go to IndexPrefix.
+	IndexPrefix     string
+	IndexDateFormat string
+
+	// Username and Password, if set, are used for basic
+	// authentication.
+	Username string
+	Password string
+
+	// MaxRetries is the number of times a request is retried when the
+	// server responds with 429 Too Many Requests or a 5xx error, or
+	// some of the documents were rejected for those reasons.
+	MaxRetries int
+
+	// Backoff is the time to wait before the first retry, doubling
+	// with each further retry.
+	Backoff time.Duration
+
+	// Signatures, if set, are used to add signature messages.
+	Signatures *SignatureMap
+
+	Client *http.Client
+
+	// Rejected is the number of documents Elasticsearch rejected for
+	// reasons that retrying would not fix, such as mapping errors.
+	// These documents are dropped.
+	Rejected int
+
+	logger *log.Logger
+}
+
+// NewElasticsearchSink creates a new ElasticsearchSink for the server
+// at url.
+func NewElasticsearchSink(url string) *ElasticsearchSink {
+	return &ElasticsearchSink{
+		URL:             strings.TrimRight(url, "/"),
+		IndexPrefix:     "snort-",
+		IndexDateFormat: "2006.01.02",
+		MaxRetries:      5,
+		Backoff:         500 * time.Millisecond,
+		Client:          http.DefaultClient,
+	}
+}
+
+// Logger sets a logger for rejected documents and retries.
+func (s *ElasticsearchSink) Logger(logger *log.Logger) {
+	s.logger = logger
+}
+
+func (s *ElasticsearchSink) log(format string, v ...interface{}) {
+	if s.logger != nil {
+		s.logger.Printf(format, v...)
+	}
+}
+
+// Index returns the name of the index an event is written to.
+func (s *ElasticsearchSink) Index(event *AggregatedEvent) string {
+	if s.IndexDateFormat == "" {
+		return s.IndexPrefix
+	}
+	timestamp := time.Unix(int64(event.Event.EventSecond), 0).UTC()
+	return s.IndexPrefix + timestamp.Format(s.IndexDateFormat)
+}
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// retryable returns true for HTTP status codes worth retrying.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// Id returns the document id of an event.
+func (s *ElasticsearchSink) Id(event *AggregatedEvent) string {
+	return fmt.Sprintf("%d-%d-%d", event.Event.SensorId,
+		event.Event.EventId, event.Event.EventSecond)
+}
+
+// Send indexes a batch of events, retrying with backoff as needed.
+// Returns nil once every event has been indexed or permanently
+// rejected.  Only documents rejected individually are dropped: if the
+// server rejects the request itself, such as with 401 Unauthorized
+// after a change of credentials, an error is returned so the batch is
+// sent again later.
+func (s *ElasticsearchSink) Send(events []*AggregatedEvent) error {
+	pending := make([][]byte, 0, len(events))
+	for _, event := range events {
+		action, err := json.Marshal(map[string]interface{}{
+			"index": map[string]string{
+				"_index": s.Index(event),
+				"_id":    s.Id(event),
+			},
+		})
+		if err != nil {
+			return err
+		}
+		document, err := json.Marshal(NewEventDocument(event,
+			s.Signatures))
+		if err != nil {
+			return err
+		}
+		pending = append(pending, append(append(append(action, '\n'),
+			document...), '\n'))
+	}
+
+	backoff := s.Backoff
+	for attempt := 0; ; attempt++ {
+		var err error
+		pending, err = s.bulk(pending)
+		if err == nil && len(pending) == 0 {
+			return nil
+		}
+		if attempt >= s.MaxRetries {
+			if err == nil {
+				err = fmt.Errorf("elasticsearch: %d documents not indexed",
+					len(pending))
+			}
+			return err
+		}
+		s.log("Retrying bulk request: %v", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// bulk makes a single bulk request with the provided action and
+// document pairs, returning those that should be retried.
+func (s *ElasticsearchSink) bulk(pending [][]byte) ([][]byte, error) {
+	request, err := http.NewRequest("POST", s.URL+"/_bulk",
+		bytes.NewReader(bytes.Join(pending, nil)))
+	if err != nil {
+		return pending, err
+	}
+	request.Header.Set("Content-Type", "application/x-ndjson")
+	if s.Username != "" {
+		request.SetBasicAuth(s.Username, s.Password)
+	}
+
+	response, err := s.Client.Do(request)
+	if err != nil {
+		return pending, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, 64<<20))
+	if err != nil {
+		return pending, err
+	}
+
+	if retryable(response.StatusCode) {
+		return pending, fmt.Errorf("elasticsearch: %s", response.Status)
+	} else if response.StatusCode != http.StatusOK {
+		return pending, fmt.Errorf("elasticsearch: %s: %s",
+			response.Status, body)
+	}
+
+	var result bulkResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return pending, err
+	}
+	if !result.Errors {
+		return nil, nil
+	}
+	if len(result.Items) != len(pending) {
+		return pending, fmt.Errorf(
+			"elasticsearch: expected %d items in response, got %d",
+			len(pending), len(result.Items))
+	}
+
+	retry := [][]byte{}
+	for i, item := range result.Items {
+		for _, status := range item {
+			if retryable(status.Status) {
+				retry = append(retry, pending[i])
+			} else if status.Status >= 300 {
+				s.Rejected++
+				s.log("Document rejected: %d: %s", status.Status,
+					status.Error)
+			}
+		}
+	}
+	return retry, nil
+}
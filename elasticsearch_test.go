@@ -0,0 +1,287 @@
+package unified2
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A fake Elasticsearch bulk endpoint.  Each call to respond returns the
+// status and item statuses for a request, and the documents of
+// successful items are recorded.
+type fakeElasticsearch struct {
+	lock      sync.Mutex
+	requests  int
+	indices   []string
+	ids       []string
+	documents []*EventDocument
+	respond   func(request int, items int) (int, []int)
+}
+
+func (f *fakeElasticsearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.requests++
+
+	lines := []string{}
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 1<<20), 1<<20)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	status, items := http.StatusOK, []int(nil)
+	if f.respond != nil {
+		status, items = f.respond(f.requests, len(lines)/2)
+	}
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
+
+	response := map[string]interface{}{"errors": items != nil}
+	responseItems := []interface{}{}
+	for i := 0; i < len(lines)/2; i++ {
+		itemStatus := 201
+		if items != nil {
+			itemStatus = items[i]
+		}
+		if itemStatus == 201 {
+			var action map[string]map[string]string
+			json.Unmarshal([]byte(lines[i*2]), &action)
+			f.indices = append(f.indices, action["index"]["_index"])
+			f.ids = append(f.ids, action["index"]["_id"])
+			document := &EventDocument{}
+			json.Unmarshal([]byte(lines[i*2+1]), document)
+			f.documents = append(f.documents, document)
+		}
+		responseItems = append(responseItems, map[string]interface{}{
+			"index": map[string]interface{}{"status": itemStatus},
+		})
+	}
+	response["items"] = responseItems
+	json.NewEncoder(w).Encode(response)
+}
+
+func (f *fakeElasticsearch) count() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.documents)
+}
+
+func TestElasticsearchSinkRetry(t *testing.T) {
+	events := readAggregatedEvents(t, "test/multi-record-event-x2.log")
+	events = append(events, events[0])
+
+	fake := &fakeElasticsearch{
+		respond: func(request int, items int) (int, []int) {
+			switch request {
+			case 1:
+				return http.StatusTooManyRequests, nil
+			case 2:
+				return http.StatusOK, []int{201, 503, 400}
+			}
+			return http.StatusOK, nil
+		},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	sink := NewElasticsearchSink(server.URL)
+	sink.Backoff = time.Millisecond
+	if err := sink.Send(events); err != nil {
+		t.Fatal(err)
+	}
+
+	if fake.requests != 3 {
+		t.Fatalf("expected 3 requests, got %d", fake.requests)
+	}
+	if len(fake.documents) != 2 || sink.Rejected != 1 {
+		t.Fatalf("expected 2 documents and 1 rejected, got %d and %d",
+			len(fake.documents), sink.Rejected)
+	}
+	if fake.indices[0] != "snort-2000.07.28" {
+		t.Fatalf("unexpected index %s", fake.indices[0])
+	}
+	if fake.ids[0] != "0-89-964798804" || fake.ids[1] != fake.ids[0] {
+		t.Fatalf("unexpected ids %v", fake.ids)
+	}
+	document := fake.documents[0]
+	if document.SignatureId != 3 || document.SrcIp != "207.25.71.28" ||
+		*document.DestPort != 2651 || len(document.Packets) != 15 {
+		t.Fatalf("unexpected document: %+v", document)
+	}
+}
+
+func TestElasticsearchSinkFailure(t *testing.T) {
+	events := readAggregatedEvents(t, "test/multi-record-event.log")
+
+	fake := &fakeElasticsearch{
+		respond: func(request int, items int) (int, []int) {
+			return http.StatusServiceUnavailable, nil
+		},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	sink := NewElasticsearchSink(server.URL)
+	sink.Backoff = time.Millisecond
+	sink.MaxRetries = 2
+	if err := sink.Send(events); err == nil {
+		t.Fatal("expected error")
+	}
+	if fake.requests != 3 {
+		t.Fatalf("expected 3 requests, got %d", fake.requests)
+	}
+}
+
+func TestSpoolBatcherElasticsearchUnauthorized(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	copyFile("test/multi-record-event-x2.log",
+		fmt.Sprintf("%s/merged.log.1382627900", tmpdir))
+
+	// A request rejected as a whole is retried, not dropped, so the
+	// bookmark must not be written.
+	fake := &fakeElasticsearch{
+		respond: func(request int, items int) (int, []int) {
+			return http.StatusUnauthorized, nil
+		},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	sink := NewElasticsearchSink(server.URL)
+	sink.Backoff = time.Millisecond
+	sink.MaxRetries = 0
+
+	batcher := &SpoolBatcher{
+		Reader:           NewSpoolRecordReader(tmpdir, "merged.log"),
+		Send:             sink.Send,
+		FlushInterval:    time.Millisecond,
+		PollInterval:     time.Millisecond,
+		RetryInterval:    time.Millisecond,
+		BookmarkFilename: tmpdir + "/bookmark",
+		Checkpoint: func(bookmark *Bookmark) {
+			t.Errorf("unexpected checkpoint %+v", bookmark)
+		},
+	}
+
+	stop := make(chan bool)
+	done := make(chan error)
+	go func() {
+		done <- batcher.Run(stop)
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		fake.lock.Lock()
+		requests := fake.requests
+		fake.lock.Unlock()
+		if requests >= 3 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("timed out")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(tmpdir + "/bookmark"); !os.IsNotExist(err) {
+		t.Fatalf("expected no bookmark, got %v", err)
+	}
+}
+
+func TestSpoolBatcherElasticsearch(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	copyFile("test/multi-record-event-x2.log",
+		fmt.Sprintf("%s/merged.log.1382627900", tmpdir))
+
+	// Fail the first request, so the bookmark must not be written
+	// until the retry succeeds.
+	fake := &fakeElasticsearch{
+		respond: func(request int, items int) (int, []int) {
+			if request == 1 {
+				return http.StatusInternalServerError, nil
+			}
+			return http.StatusOK, nil
+		},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	sink := NewElasticsearchSink(server.URL)
+	sink.MaxRetries = 0
+
+	bookmarks := make(chan *Bookmark, 10)
+	batcher := &SpoolBatcher{
+		Reader:           NewSpoolRecordReader(tmpdir, "merged.log"),
+		Send:             sink.Send,
+		FlushInterval:    time.Millisecond,
+		PollInterval:     time.Millisecond,
+		RetryInterval:    time.Millisecond,
+		BookmarkFilename: tmpdir + "/bookmark",
+		Checkpoint: func(bookmark *Bookmark) {
+			bookmarks <- bookmark
+		},
+	}
+
+	stop := make(chan bool)
+	done := make(chan error)
+	go func() {
+		done <- batcher.Run(stop)
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for fake.count() < 2 {
+		select {
+		case <-deadline:
+			t.Fatal("timed out")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat("test/multi-record-event-x2.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bookmark, err := ReadBookmark(tmpdir + "/bookmark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *bookmark != (Bookmark{"merged.log.1382627900", info.Size()}) {
+		t.Fatalf("unexpected bookmark: %+v", bookmark)
+	}
+	if len(bookmarks) == 0 {
+		t.Fatal("expected a checkpoint")
+	}
+	if !strings.HasPrefix(fake.indices[1], "snort-") {
+		t.Fatalf("unexpected index %s", fake.indices[1])
+	}
+}
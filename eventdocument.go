@@ -0,0 +1,156 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"time"
+	"unicode/utf8"
+)
+
+// EventDocument is a JSON friendly form of an aggregated event, as
+// sent to Elasticsearch and webhooks.
+type EventDocument struct {
+	Timestamp         time.Time `json:"timestamp"`
+	SensorId          uint32    `json:"sensor_id"`
+	EventId           uint32    `json:"event_id"`
+	GeneratorId       uint32    `json:"gid"`
+	SignatureId       uint32    `json:"sid"`
+	SignatureRevision uint32    `json:"rev"`
+	Signature         string    `json:"signature,omitempty"`
+	Classtype         string    `json:"classtype,omitempty"`
+	ClassificationId  uint32    `json:"classification_id"`
+	Priority          uint32    `json:"priority"`
+	SrcIp             string    `json:"src_ip"`
+	DestIp            string    `json:"dest_ip"`
+	SrcPort           *uint16   `json:"src_port,omitempty"`
+	DestPort          *uint16   `json:"dest_port,omitempty"`
+	IcmpType          *uint16   `json:"icmp_type,omitempty"`
+	IcmpCode          *uint16   `json:"icmp_code,omitempty"`
+	Protocol          string    `json:"proto"`
+	Action            string    `json:"action"`
+	ImpactFlag        uint8     `json:"impact_flag"`
+	Impact            uint8     `json:"impact"`
+	MplsLabel         uint32    `json:"mpls_label,omitempty"`
+	VlanId            uint16    `json:"vlan_id,omitempty"`
+	AppId             string    `json:"app_id,omitempty"`
+	Xff               string    `json:"xff,omitempty"`
+
+	Packets   []PacketDocument    `json:"packets,omitempty"`
+	ExtraData []ExtraDataDocument `json:"extra_data,omitempty"`
+
+	Enrichment map[string]interface{} `json:"enrichment,omitempty"`
+}
+
+// PacketDocument is the JSON friendly form of a packet record.  Data
+// is encoded as base64.
+type PacketDocument struct {
+	Timestamp time.Time `json:"timestamp"`
+	LinkType  uint32    `json:"linktype"`
+	Length    uint32    `json:"length"`
+	Data      []byte    `json:"data"`
+}
+
+// ExtraDataDocument is the JSON friendly form of an extra data
+// record.  Data that is valid UTF-8 is given as Value, anything else
+// as Data encoded as base64.
+type ExtraDataDocument struct {
+	Type  uint32 `json:"type"`
+	Value string `json:"value,omitempty"`
+	Data  []byte `json:"data,omitempty"`
+}
+
+// NewEventDocument creates an EventDocument from an aggregated event,
+// using signatures, if not nil, for the signature message and
+// classtype.
+func NewEventDocument(event *AggregatedEvent, signatures *SignatureMap) *EventDocument {
+	record := event.Event
+
+	document := &EventDocument{
+		Timestamp: time.Unix(int64(record.EventSecond),
+			int64(record.EventMicrosecond)*1000).UTC(),
+		SensorId:          record.SensorId,
+		EventId:           record.EventId,
+		GeneratorId:       record.GeneratorId,
+		SignatureId:       record.SignatureId,
+		SignatureRevision: record.SignatureRevision,
+		ClassificationId:  record.ClassificationId,
+		Priority:          record.Priority,
+		SrcIp:             record.IpSource.String(),
+		DestIp:            record.IpDestination.String(),
+		Protocol:          record.ProtocolName(),
+		Action:            eventAction(record),
+		ImpactFlag:        record.ImpactFlag,
+		Impact:            record.Impact,
+		MplsLabel:         record.MplsLabel,
+		VlanId:            record.VlanId,
+		AppId:             record.AppId,
+		Enrichment:        event.Enrichment,
+	}
+
+	if signatures != nil {
+		if signature := signatures.Lookup(record.GeneratorId,
+			record.SignatureId); signature != nil {
+			document.Signature = signature.Msg
+			document.Classtype = signature.Classtype
+		}
+	}
+
+	sport, dport := record.SportItype, record.DportIcode
+	if record.HasPorts() {
+		document.SrcPort, document.DestPort = &sport, &dport
+	} else if record.IsICMP() {
+		document.IcmpType, document.IcmpCode = &sport, &dport
+	}
+
+	if xff := XffAddress(event.ExtraData); xff != nil {
+		document.Xff = xff.String()
+	}
+
+	for _, packet := range event.Packets {
+		document.Packets = append(document.Packets, PacketDocument{
+			Timestamp: time.Unix(int64(packet.PacketSecond),
+				int64(packet.PacketMicrosecond)*1000).UTC(),
+			LinkType: packet.LinkType,
+			Length:   packet.Length,
+			Data:     packet.Data,
+		})
+	}
+
+	for _, extra := range event.ExtraData {
+		extraDocument := ExtraDataDocument{Type: extra.Type}
+		if ip := extra.IP(); ip != nil {
+			extraDocument.Value = ip.String()
+		} else if utf8.Valid(extra.Data) {
+			extraDocument.Value = string(extra.Data)
+		} else {
+			extraDocument.Data = extra.Data
+		}
+		document.ExtraData = append(document.ExtraData, extraDocument)
+	}
+
+	return document
+}
@@ -0,0 +1,189 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"strconv"
+	"strings"
+)
+
+var protocolNames = map[uint8]string{
+	1:   "ICMP",
+	2:   "IGMP",
+	6:   "TCP",
+	17:  "UDP",
+	47:  "GRE",
+	50:  "ESP",
+	51:  "AH",
+	58:  "ICMPv6",
+	132: "SCTP",
+}
+
+// IPPROTO_SCTP is the IP protocol number of SCTP.
+const IPPROTO_SCTP = 132
+
+// BlockedStatus is the value of the Blocked field of an event.
+type BlockedStatus uint8
+
+// Blocked statuses.
+const (
+	BLOCKED_STATUS_NOT_BLOCKED BlockedStatus = 0
+	BLOCKED_STATUS_BLOCKED     BlockedStatus = 1
+	BLOCKED_STATUS_WOULD_BLOCK BlockedStatus = 2
+	BLOCKED_STATUS_CANT_BLOCK  BlockedStatus = 3
+)
+
+var blockedStatusNames = map[BlockedStatus]string{
+	BLOCKED_STATUS_NOT_BLOCKED: "not-blocked",
+	BLOCKED_STATUS_BLOCKED:     "blocked",
+	BLOCKED_STATUS_WOULD_BLOCK: "would-block",
+	BLOCKED_STATUS_CANT_BLOCK:  "cant-block",
+}
+
+// String returns the name of the status, or its number if unknown.
+func (s BlockedStatus) String() string {
+	if name, ok := blockedStatusNames[s]; ok {
+		return name
+	}
+	return strconv.Itoa(int(s))
+}
+
+// ImpactFlags is the bit mask held in the ImpactFlag field of an
+// event.
+type ImpactFlags uint8
+
+// Impact flag bits.
+const (
+	IMPACT_FLAG_MONITORED_NETWORK     ImpactFlags = 0x01
+	IMPACT_FLAG_IN_NETWORK_MAP        ImpactFlags = 0x02
+	IMPACT_FLAG_SERVICE               ImpactFlags = 0x04
+	IMPACT_FLAG_OS_VULNERABILITY      ImpactFlags = 0x08
+	IMPACT_FLAG_SERVICE_VULNERABILITY ImpactFlags = 0x10
+	IMPACT_FLAG_BLOCKED               ImpactFlags = 0x20
+	IMPACT_FLAG_RULE_RED              ImpactFlags = 0x40
+	IMPACT_FLAG_COMPROMISED           ImpactFlags = 0x80
+)
+
+var impactFlagNames = []struct {
+	flag ImpactFlags
+	name string
+}{
+	{IMPACT_FLAG_MONITORED_NETWORK, "monitored-network"},
+	{IMPACT_FLAG_IN_NETWORK_MAP, "in-network-map"},
+	{IMPACT_FLAG_SERVICE, "service"},
+	{IMPACT_FLAG_OS_VULNERABILITY, "os-vulnerability"},
+	{IMPACT_FLAG_SERVICE_VULNERABILITY, "service-vulnerability"},
+	{IMPACT_FLAG_BLOCKED, "blocked"},
+	{IMPACT_FLAG_RULE_RED, "rule-red"},
+	{IMPACT_FLAG_COMPROMISED, "compromised"},
+}
+
+// Has returns true if all the bits of flag are set.
+func (f ImpactFlags) Has(flag ImpactFlags) bool {
+	return f&flag == flag
+}
+
+// String returns the names of the flags set separated by "|", or
+// "none".
+func (f ImpactFlags) String() string {
+	if f == 0 {
+		return "none"
+	}
+	names := []string{}
+	for _, flag := range impactFlagNames {
+		if f.Has(flag.flag) {
+			names = append(names, flag.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// ProtocolName returns the name of the event's IP protocol, such as
+// "TCP", or its number if the protocol is not known.
+func (e *EventRecord) ProtocolName() string {
+	if name, ok := protocolNames[e.Protocol]; ok {
+		return name
+	}
+	return strconv.Itoa(int(e.Protocol))
+}
+
+// IsICMP returns true if the event protocol is ICMP or ICMPv6, in
+// which case SportItype and DportIcode are the ICMP type and code.
+func (e *EventRecord) IsICMP() bool {
+	return e.Protocol == IPPROTO_ICMP || e.Protocol == IPPROTO_ICMPV6
+}
+
+// HasPorts returns true if the event protocol has ports, in which case
+// SportItype and DportIcode are the source and destination ports.
+func (e *EventRecord) HasPorts() bool {
+	return e.Protocol == IPPROTO_TCP || e.Protocol == IPPROTO_UDP ||
+		e.Protocol == IPPROTO_SCTP
+}
+
+// SourcePort returns the source port, or 0 if the protocol has no
+// ports.
+func (e *EventRecord) SourcePort() uint16 {
+	if e.HasPorts() {
+		return e.SportItype
+	}
+	return 0
+}
+
+// DestinationPort returns the destination port, or 0 if the protocol
+// has no ports.
+func (e *EventRecord) DestinationPort() uint16 {
+	if e.HasPorts() {
+		return e.DportIcode
+	}
+	return 0
+}
+
+// ICMPType returns the ICMP type, or 0 if the event is not ICMP.
+func (e *EventRecord) ICMPType() uint8 {
+	if e.IsICMP() {
+		return uint8(e.SportItype)
+	}
+	return 0
+}
+
+// ICMPCode returns the ICMP code, or 0 if the event is not ICMP.
+func (e *EventRecord) ICMPCode() uint8 {
+	if e.IsICMP() {
+		return uint8(e.DportIcode)
+	}
+	return 0
+}
+
+// BlockedStatus returns the Blocked field of the event.
+func (e *EventRecord) BlockedStatus() BlockedStatus {
+	return BlockedStatus(e.Blocked)
+}
+
+// ImpactFlags returns the ImpactFlag field of the event.
+func (e *EventRecord) ImpactFlags() ImpactFlags {
+	return ImpactFlags(e.ImpactFlag)
+}
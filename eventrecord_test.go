@@ -0,0 +1,120 @@
+package unified2
+
+import (
+	"testing"
+)
+
+func TestEventRecordProtocol(t *testing.T) {
+	tests := []struct {
+		protocol        uint8
+		name            string
+		icmp            bool
+		ports           bool
+		sourcePort      uint16
+		destinationPort uint16
+		icmpType        uint8
+		icmpCode        uint8
+	}{
+		{6, "TCP", false, true, 1024, 80, 0, 0},
+		{17, "UDP", false, true, 1024, 80, 0, 0},
+		{132, "SCTP", false, true, 1024, 80, 0, 0},
+		{1, "ICMP", true, false, 0, 0, 8, 0},
+		{58, "ICMPv6", true, false, 0, 0, 128, 0},
+		{47, "GRE", false, false, 0, 0, 0, 0},
+		{253, "253", false, false, 0, 0, 0, 0},
+	}
+
+	for _, test := range tests {
+		event := &EventRecord{Protocol: test.protocol}
+		if test.icmp {
+			event.SportItype = uint16(test.icmpType)
+			event.DportIcode = uint16(test.icmpCode)
+		} else {
+			event.SportItype = 1024
+			event.DportIcode = 80
+		}
+
+		if name := event.ProtocolName(); name != test.name {
+			t.Errorf("protocol %d: expected name %q, got %q",
+				test.protocol, test.name, name)
+		}
+		if event.IsICMP() != test.icmp {
+			t.Errorf("protocol %d: expected IsICMP %v", test.protocol,
+				test.icmp)
+		}
+		if event.HasPorts() != test.ports {
+			t.Errorf("protocol %d: expected HasPorts %v", test.protocol,
+				test.ports)
+		}
+		if event.SourcePort() != test.sourcePort ||
+			event.DestinationPort() != test.destinationPort {
+			t.Errorf("protocol %d: unexpected ports %d, %d",
+				test.protocol, event.SourcePort(),
+				event.DestinationPort())
+		}
+		if event.ICMPType() != test.icmpType ||
+			event.ICMPCode() != test.icmpCode {
+			t.Errorf("protocol %d: unexpected icmp type %d, code %d",
+				test.protocol, event.ICMPType(), event.ICMPCode())
+		}
+	}
+}
+
+func TestBlockedStatus(t *testing.T) {
+	tests := []struct {
+		blocked uint8
+		status  BlockedStatus
+		name    string
+	}{
+		{0, BLOCKED_STATUS_NOT_BLOCKED, "not-blocked"},
+		{1, BLOCKED_STATUS_BLOCKED, "blocked"},
+		{2, BLOCKED_STATUS_WOULD_BLOCK, "would-block"},
+		{3, BLOCKED_STATUS_CANT_BLOCK, "cant-block"},
+		{9, BlockedStatus(9), "9"},
+	}
+
+	for _, test := range tests {
+		event := &EventRecord{Blocked: test.blocked}
+		if event.BlockedStatus() != test.status {
+			t.Errorf("blocked %d: unexpected status %d", test.blocked,
+				event.BlockedStatus())
+		}
+		if name := event.BlockedStatus().String(); name != test.name {
+			t.Errorf("blocked %d: expected %q, got %q", test.blocked,
+				test.name, name)
+		}
+	}
+}
+
+func TestImpactFlags(t *testing.T) {
+	tests := []struct {
+		flag uint8
+		has  []ImpactFlags
+		name string
+	}{
+		{0x00, nil, "none"},
+		{0x20, []ImpactFlags{IMPACT_FLAG_BLOCKED}, "blocked"},
+		{0x05, []ImpactFlags{IMPACT_FLAG_MONITORED_NETWORK,
+			IMPACT_FLAG_SERVICE}, "monitored-network|service"},
+		{0xc0, []ImpactFlags{IMPACT_FLAG_RULE_RED,
+			IMPACT_FLAG_COMPROMISED}, "rule-red|compromised"},
+	}
+
+	for _, test := range tests {
+		event := &EventRecord{ImpactFlag: test.flag}
+		flags := event.ImpactFlags()
+		for _, flag := range test.has {
+			if !flags.Has(flag) {
+				t.Errorf("flag 0x%02x: expected %s to be set", test.flag,
+					flag)
+			}
+		}
+		if test.flag != 0x20 && flags.Has(IMPACT_FLAG_BLOCKED) {
+			t.Errorf("flag 0x%02x: blocked should not be set", test.flag)
+		}
+		if name := flags.String(); name != test.name {
+			t.Errorf("flag 0x%02x: expected %q, got %q", test.flag,
+				test.name, name)
+		}
+	}
+}
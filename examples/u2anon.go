@@ -0,0 +1,79 @@
+// Anonymize a unified2 file so it can be shared.
+package main
+
+import "os"
+import "flag"
+import "fmt"
+import "log"
+import "bufio"
+import "strings"
+import "io/ioutil"
+import "encoding/hex"
+import "crypto/rand"
+import "github.com/jasonish/go-unified2"
+
+func main() {
+
+	var keyFilename string
+	var truncate int
+	var scrub bool
+
+	flag.StringVar(&keyFilename, "key", "",
+		"file holding a 64 hex digit key (default a random key)")
+	flag.IntVar(&truncate, "truncate", -1,
+		"truncate packet payloads to this many bytes")
+	flag.BoolVar(&scrub, "scrub", false,
+		"drop URI, hostname and other non-address extra data")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) != 2 {
+		log.Fatalf("usage: u2anon [options] <input> <output>")
+	}
+
+	key := make([]byte, unified2.ANONYMIZER_KEY_LEN)
+	if keyFilename != "" {
+		buf, err := ioutil.ReadFile(keyFilename)
+		if err != nil {
+			log.Fatal(err)
+		}
+		key, err = hex.DecodeString(strings.TrimSpace(string(buf)))
+		if err != nil {
+			log.Fatalf("%s: %s", keyFilename, err)
+		}
+	} else {
+		if _, err := rand.Read(key); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(os.Stderr, "key: %x\n", key)
+	}
+
+	anonymizer, err := unified2.NewAnonymizer(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	anonymizer.TruncatePayload = truncate >= 0
+	anonymizer.PayloadLength = truncate
+	anonymizer.ScrubExtraData = scrub
+
+	input, err := os.Open(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer input.Close()
+
+	output, err := os.Create(args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	writer := bufio.NewWriter(output)
+	if err := anonymizer.Anonymize(writer, input); err != nil {
+		log.Fatal(err)
+	}
+	if err := writer.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if err := output.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
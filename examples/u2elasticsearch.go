@@ -0,0 +1,125 @@
+// Index events from a unified2 spool directory into Elasticsearch.
+package main
+
+import "os"
+import "os/signal"
+import "flag"
+import "log"
+import "github.com/jasonish/go-unified2"
+import "github.com/jasonish/go-unified2/geoip"
+import "github.com/jasonish/go-unified2/reputation"
+import "strings"
+import "fmt"
+
+// listFlags collects repeated -list name=filename options.
+type listFlags []reputation.List
+
+func (l *listFlags) String() string {
+	return fmt.Sprint(*l)
+}
+
+func (l *listFlags) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("expected name=filename")
+	}
+	*l = append(*l, reputation.List{Name: parts[0], Filename: parts[1]})
+	return nil
+}
+
+func main() {
+
+	var url string
+	var bookmark string
+	var sidMsgMap string
+	var geoipCity string
+	var geoipAsn string
+	var lists listFlags
+	var thresholdConf string
+
+	flag.StringVar(&url, "url", "http://localhost:9200", "elasticsearch url")
+	flag.StringVar(&bookmark, "bookmark", "", "bookmark filename")
+	flag.StringVar(&sidMsgMap, "sid-msg-map", "", "sid-msg.map filename")
+	flag.StringVar(&geoipCity, "geoip-city", "", "GeoIP2 City database")
+	flag.StringVar(&geoipAsn, "geoip-asn", "", "GeoLite2 ASN database")
+	flag.Var(&lists, "list", "tag addresses on list (name=filename)")
+	flag.StringVar(&thresholdConf, "threshold", "", "threshold.conf filename")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) != 2 {
+		log.Fatalf("usage: u2elasticsearch [options] <directory> <prefix>")
+	}
+
+	sink := unified2.NewElasticsearchSink(url)
+	sink.Logger(log.New(os.Stderr, "elasticsearch: ", log.LstdFlags))
+
+	if sidMsgMap != "" {
+		file, err := os.Open(sidMsgMap)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sink.Signatures = unified2.NewSignatureMap()
+		if err := sink.Signatures.LoadSidMsgMap(file); err != nil {
+			log.Fatal(err)
+		}
+		file.Close()
+	}
+
+	batcher := &unified2.SpoolBatcher{
+		Reader:           unified2.NewSpoolRecordReader(args[0], args[1]),
+		Send:             sink.Send,
+		BookmarkFilename: bookmark,
+		Errors: func(err error) {
+			log.Println(err)
+		},
+	}
+
+	if thresholdConf != "" {
+		file, err := os.Open(thresholdConf)
+		if err != nil {
+			log.Fatal(err)
+		}
+		thresholder := unified2.NewThresholder()
+		if err := thresholder.LoadThresholdConf(file); err != nil {
+			log.Fatalf("%s: %s", thresholdConf, err)
+		}
+		file.Close()
+		batcher.Filter = thresholder.AllowEvent
+	}
+
+	if geoipCity != "" || geoipAsn != "" {
+		enricher, err := geoip.New(geoipCity, geoipAsn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		enricher.Errors = func(err error) {
+			log.Println(err)
+		}
+		defer enricher.Close()
+		batcher.Enrichers = append(batcher.Enrichers, enricher)
+	}
+
+	if len(lists) > 0 {
+		watchlist, err := reputation.New(lists...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		watchlist.Errors = func(err error) {
+			log.Println(err)
+		}
+		batcher.Enrichers = append(batcher.Enrichers, watchlist)
+	}
+
+	stop := make(chan bool)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+
+	if err := batcher.Run(stop); err != nil {
+		log.Fatal(err)
+	}
+}
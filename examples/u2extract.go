@@ -7,32 +7,6 @@ import "flag"
 import "log"
 import "io"
 import "github.com/jasonish/go-unified2"
-import "encoding/binary"
-
-func writeRecord(out *os.File, record *unified2.RawRecord) (err error) {
-
-	recordType := record.Type
-	recordLen := uint32(len(record.Data))
-
-	err = binary.Write(out, binary.BigEndian, &recordType)
-	if err != nil {
-		return err
-	}
-
-	err = binary.Write(out, binary.BigEndian, &recordLen)
-	if err != nil {
-		return err
-	}
-
-	n, err := out.Write(record.Data)
-	if err != nil {
-		return err
-	} else if n != len(record.Data) {
-		return io.ErrShortWrite
-	}
-
-	return nil
-}
 
 func main() {
 
@@ -92,7 +66,7 @@ func main() {
 			}
 
 			if currentEvent != nil {
-				writeRecord(os.Stdout, raw)
+				unified2.WriteRawRecord(os.Stdout, raw)
 				written++
 			}
 
@@ -0,0 +1,93 @@
+// Generate a synthetic unified2 file for testing.
+package main
+
+import "os"
+import "flag"
+import "log"
+import "time"
+import "bufio"
+import "strings"
+import "strconv"
+import "github.com/jasonish/go-unified2"
+
+var eventTypeNames = map[string]uint32{
+	"ip4":       unified2.UNIFIED2_EVENT,
+	"ip6":       unified2.UNIFIED2_EVENT_IP6,
+	"v2":        unified2.UNIFIED2_EVENT_V2,
+	"v2-ip6":    unified2.UNIFIED2_EVENT_V2_IP6,
+	"appid":     unified2.UNIFIED2_EVENT_APPID,
+	"appid-ip6": unified2.UNIFIED2_EVENT_APPID_IP6,
+	"mpls":      unified2.UNIFIED2_EVENT_MPLS,
+	"mpls-ip6":  unified2.UNIFIED2_EVENT_MPLS_IP6,
+	"v3":        unified2.UNIFIED2_EVENT_V3,
+}
+
+// parseEventTypes parses a list like "v2:3,v2-ip6:1" of event type
+// names with optional weights.
+func parseEventTypes(value string) map[uint32]int {
+	types := map[uint32]int{}
+	for _, field := range strings.Split(value, ",") {
+		parts := strings.SplitN(field, ":", 2)
+		eventType, ok := eventTypeNames[parts[0]]
+		if !ok {
+			log.Fatalf("unknown event type: %s", parts[0])
+		}
+		weight := 1
+		if len(parts) == 2 {
+			var err error
+			if weight, err = strconv.Atoi(parts[1]); err != nil {
+				log.Fatalf("bad weight: %s", field)
+			}
+		}
+		types[eventType] = weight
+	}
+	return types
+}
+
+func main() {
+
+	var seed int64
+	var events int
+	var types string
+	var packets int
+	var extra float64
+	var sensorId uint
+	var start int64
+
+	flag.Int64Var(&seed, "seed", 1, "random seed")
+	flag.IntVar(&events, "events", 1000, "number of events")
+	flag.StringVar(&types, "types", "v2,v2-ip6,appid",
+		"event types with optional weights, eg. v2:3,v3:1")
+	flag.IntVar(&packets, "packets", 1, "maximum packets per event")
+	flag.Float64Var(&extra, "extra", 0.25, "extra data probability")
+	flag.UintVar(&sensorId, "sensor-id", 0, "sensor id")
+	flag.Int64Var(&start, "start", 1400000000, "time of first event")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) != 1 {
+		log.Fatalf("usage: u2gen [options] <output>")
+	}
+
+	generator := unified2.NewGenerator(seed)
+	generator.EventTypes = parseEventTypes(types)
+	generator.PacketsPerEvent = packets
+	generator.ExtraDataProbability = extra
+	generator.SensorId = uint32(sensorId)
+	generator.Time = time.Unix(start, 0)
+
+	file, err := os.Create(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	writer := bufio.NewWriter(file)
+	if err := generator.Write(writer, events); err != nil {
+		log.Fatal(err)
+	}
+	if err := writer.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
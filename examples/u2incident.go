@@ -0,0 +1,92 @@
+// Collapse events from a unified2 spool directory into incidents,
+// printed as JSON when they close.
+package main
+
+import "os"
+import "os/signal"
+import "flag"
+import "log"
+import "io"
+import "time"
+import "encoding/json"
+import "github.com/jasonish/go-unified2"
+
+func main() {
+
+	var key string
+	var window time.Duration
+	var maxDuration time.Duration
+
+	flag.StringVar(&key, "key", "sid,src,dst,dport",
+		"fields to group events by: sid, src, dst, dport")
+	flag.DurationVar(&window, "window", time.Minute,
+		"how long an incident stays open after its last event")
+	flag.DurationVar(&maxDuration, "max-duration", 0,
+		"maximum length of an incident (0 for no limit)")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) != 2 {
+		log.Fatalf("usage: u2incident [options] <directory> <prefix>")
+	}
+
+	incidents := unified2.NewIncidentAggregator()
+	var err error
+	if incidents.Key, err = unified2.ParseIncidentKey(key); err != nil {
+		log.Fatal(err)
+	}
+	incidents.Window = window
+	incidents.MaxDuration = maxDuration
+
+	encoder := json.NewEncoder(os.Stdout)
+	output := func(closed []*unified2.Incident) {
+		for _, incident := range closed {
+			encoder.Encode(incident)
+		}
+	}
+
+	stop := make(chan bool)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+
+	reader := unified2.NewSpoolRecordReader(args[0], args[1])
+	var aggregator unified2.EventAggregator
+
+	for {
+		select {
+		case <-stop:
+			if event := aggregator.Flush(); event != nil {
+				output(incidents.Add(event))
+			}
+			output(incidents.Flush())
+			return
+		default:
+		}
+
+		// io.ErrUnexpectedEOF is returned while Snort is part way
+		// through writing a record.
+		record, err := reader.Next()
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			log.Println(err)
+		}
+
+		if record != nil {
+			if event := aggregator.Add(record); event != nil {
+				output(incidents.Add(event))
+			}
+			continue
+		}
+
+		// Nothing new to read, so close the incidents whose window
+		// has passed in real time.
+		if event := aggregator.Flush(); event != nil {
+			output(incidents.Add(event))
+		}
+		output(incidents.Expire(time.Now()))
+		time.Sleep(100 * time.Millisecond)
+	}
+}
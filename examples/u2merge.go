@@ -0,0 +1,48 @@
+// Merge unified2 log files into a single file ordered by event time.
+package main
+
+import "os"
+import "flag"
+import "log"
+import "io"
+import "github.com/jasonish/go-unified2"
+
+func main() {
+
+	var outputFilename string
+
+	flag.StringVar(&outputFilename, "o", "", "output filename (default stdout)")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		log.Fatalf("error: no input files specified")
+	}
+
+	inputs := make([]io.ReadWriteSeeker, 0, len(args))
+	for _, arg := range args {
+		file, err := os.Open(arg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		inputs = append(inputs, file)
+	}
+
+	output := os.Stdout
+	if outputFilename != "" {
+		file, err := os.Create(outputFilename)
+		if err != nil {
+			log.Fatal(err)
+		}
+		output = file
+	}
+
+	if err := unified2.Merge(output, inputs...); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := output.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,63 @@
+// Receive records from u2send into a spool directory per sensor.
+package main
+
+import "os"
+import "os/signal"
+import "flag"
+import "log"
+import "crypto/tls"
+import "github.com/jasonish/go-unified2"
+
+func main() {
+
+	var address string
+	var prefix string
+	var maxSize int64
+	var certFilename string
+	var keyFilename string
+
+	flag.StringVar(&address, "address", ":7070", "listen address")
+	flag.StringVar(&prefix, "prefix", "unified2.log", "spool file prefix")
+	flag.Int64Var(&maxSize, "max-size", 128*1024*1024, "spool file size")
+	flag.StringVar(&certFilename, "cert", "", "tls certificate filename")
+	flag.StringVar(&keyFilename, "key", "", "tls key filename")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) != 1 {
+		log.Fatalf("usage: u2receive [options] <directory>")
+	}
+
+	writer := unified2.NewSpoolWriter(args[0], prefix)
+	writer.MaxSize = maxSize
+	defer writer.Close()
+
+	receiver := &unified2.Receiver{
+		Handler: writer.Write,
+		Sync:    writer.Sync,
+		Errors: func(err error) {
+			log.Println(err)
+		},
+	}
+
+	if certFilename != "" {
+		certificate, err := tls.LoadX509KeyPair(certFilename, keyFilename)
+		if err != nil {
+			log.Fatal(err)
+		}
+		receiver.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{certificate},
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		receiver.Close()
+	}()
+
+	if err := receiver.ListenAndServe(address); err != nil {
+		log.Println(err)
+	}
+}
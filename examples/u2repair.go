@@ -0,0 +1,61 @@
+// Repair a unified2 log file by copying its complete records to a new
+// file, dropping corrupt regions and any incomplete trailing record.
+package main
+
+import "os"
+import "flag"
+import "log"
+import "fmt"
+import "io"
+import "github.com/jasonish/go-unified2"
+
+func main() {
+
+	var outputFilename string
+	var quarantineFilename string
+
+	flag.StringVar(&outputFilename, "o", "", "output filename")
+	flag.StringVar(&quarantineFilename, "q", "", "filename to write removed data to")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) != 1 || outputFilename == "" {
+		log.Fatalf("usage: u2repair -o <output> [-q <quarantine>] <input>")
+	}
+
+	input, err := os.Open(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer input.Close()
+
+	output, err := os.Create(outputFilename)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var quarantine io.Writer
+	if quarantineFilename != "" {
+		file, err := os.Create(quarantineFilename)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		quarantine = file
+	}
+
+	report, err := unified2.Repair(input, output, quarantine)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := output.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	for _, region := range report.Removed {
+		fmt.Printf("Removed %d bytes of %s data at offset %d\n",
+			region.Length, region.Reason, region.Offset)
+	}
+	fmt.Printf("Records copied: %d; Bytes removed: %d\n", report.Records,
+		report.BytesRemoved())
+}
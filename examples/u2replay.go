@@ -0,0 +1,67 @@
+// Replay unified2 log files into a spool directory as a live sensor
+// would write them.
+package main
+
+import "os"
+import "os/signal"
+import "flag"
+import "log"
+import "github.com/jasonish/go-unified2"
+
+func main() {
+
+	var prefix string
+	var speed float64
+	var maxSize int64
+	var now bool
+	var loop bool
+
+	flag.StringVar(&prefix, "prefix", "unified2.log", "spool file prefix")
+	flag.Float64Var(&speed, "speed", 1, "replay speed (0 for no delay)")
+	flag.Int64Var(&maxSize, "max-size", 128*1024*1024, "spool file size")
+	flag.BoolVar(&now, "now", false, "rewrite timestamps to now")
+	flag.BoolVar(&loop, "loop", false, "replay the files until interrupted")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 2 {
+		log.Fatalf("usage: u2replay [options] <directory> <file>...")
+	}
+
+	replayer := unified2.NewReplayer(args[0], prefix)
+	replayer.Speed = speed
+	replayer.RewriteTimestamps = now || loop
+	replayer.SetMaxSize(maxSize)
+	defer replayer.Close()
+
+	stop := make(chan bool)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+
+	for {
+		replayer.Restart()
+		for _, filename := range args[1:] {
+			file, err := os.Open(filename)
+			if err != nil {
+				log.Fatal(err)
+			}
+			err = replayer.Replay(file, stop)
+			file.Close()
+			if err != nil {
+				log.Fatal(err)
+			}
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+		if !loop {
+			break
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Send the records of a unified2 spool directory to u2receive.
+package main
+
+import "os"
+import "os/signal"
+import "flag"
+import "log"
+import "crypto/tls"
+import "crypto/x509"
+import "io/ioutil"
+import "github.com/jasonish/go-unified2"
+
+func main() {
+
+	var address string
+	var sensor string
+	var bookmark string
+	var useTLS bool
+	var caFilename string
+
+	flag.StringVar(&address, "address", "localhost:7070", "receiver address")
+	flag.StringVar(&sensor, "sensor", "", "sensor name (default hostname)")
+	flag.StringVar(&bookmark, "bookmark", "", "bookmark filename")
+	flag.BoolVar(&useTLS, "tls", false, "use tls")
+	flag.StringVar(&caFilename, "ca", "", "ca certificate filename")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) != 2 {
+		log.Fatalf("usage: u2send [options] <directory> <prefix>")
+	}
+
+	if sensor == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Fatal(err)
+		}
+		sensor = hostname
+	}
+
+	sender := &unified2.Sender{
+		Reader:           unified2.NewSpoolRecordReader(args[0], args[1]),
+		Address:          address,
+		Sensor:           sensor,
+		BookmarkFilename: bookmark,
+		Errors: func(err error) {
+			log.Println(err)
+		},
+	}
+
+	if useTLS || caFilename != "" {
+		sender.TLSConfig = &tls.Config{}
+		if caFilename != "" {
+			pem, err := ioutil.ReadFile(caFilename)
+			if err != nil {
+				log.Fatal(err)
+			}
+			sender.TLSConfig.RootCAs = x509.NewCertPool()
+			if !sender.TLSConfig.RootCAs.AppendCertsFromPEM(pem) {
+				log.Fatalf("no certificates found in %s", caFilename)
+			}
+		}
+	}
+
+	stop := make(chan bool)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+
+	if err := sender.Run(stop); err != nil {
+		log.Fatal(err)
+	}
+}
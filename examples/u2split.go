@@ -0,0 +1,63 @@
+// Split a unified2 log file into multiple spool files by time, size,
+// sensor or signature.
+package main
+
+import "os"
+import "flag"
+import "log"
+import "fmt"
+import "time"
+import "github.com/jasonish/go-unified2"
+
+func main() {
+
+	var options unified2.SplitOptions
+	var interval string
+	var by string
+
+	flag.StringVar(&options.Directory, "d", ".", "output directory")
+	flag.StringVar(&options.Prefix, "p", "unified2.log", "output filename prefix")
+	flag.StringVar(&interval, "interval", "", "split by time: hour or day")
+	flag.Int64Var(&options.MaxSize, "max-size", 0, "maximum output file size in bytes")
+	flag.StringVar(&by, "by", "", "partition by: sensor or signature")
+	flag.Parse()
+
+	switch interval {
+	case "":
+	case "hour":
+		options.Interval = time.Hour
+	case "day":
+		options.Interval = 24 * time.Hour
+	default:
+		log.Fatalf("error: bad interval: %s", interval)
+	}
+
+	switch by {
+	case "":
+	case "sensor":
+		options.By = unified2.SplitBySensor
+	case "signature":
+		options.By = unified2.SplitBySignature
+	default:
+		log.Fatalf("error: bad partition: %s", by)
+	}
+
+	for _, arg := range flag.Args() {
+
+		file, err := os.Open(arg)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		filenames, err := unified2.Split(file, options)
+		for _, filename := range filenames {
+			fmt.Println(filename)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		file.Close()
+	}
+
+}
@@ -0,0 +1,65 @@
+// Store events from a unified2 spool directory in a SQLite database.
+package main
+
+import "os"
+import "os/signal"
+import "flag"
+import "log"
+import "github.com/jasonish/go-unified2"
+import "github.com/jasonish/go-unified2/sqlite"
+
+func main() {
+
+	var database string
+	var bookmark string
+	var sidMsgMap string
+
+	flag.StringVar(&database, "database", "unified2.db", "sqlite database filename")
+	flag.StringVar(&bookmark, "bookmark", "", "bookmark filename")
+	flag.StringVar(&sidMsgMap, "sid-msg-map", "", "sid-msg.map filename")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) != 2 {
+		log.Fatalf("usage: u2sqlite [options] <directory> <prefix>")
+	}
+
+	store, err := sqlite.Open(database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	if sidMsgMap != "" {
+		file, err := os.Open(sidMsgMap)
+		if err != nil {
+			log.Fatal(err)
+		}
+		store.Signatures = unified2.NewSignatureMap()
+		if err := store.Signatures.LoadSidMsgMap(file); err != nil {
+			log.Fatal(err)
+		}
+		file.Close()
+	}
+
+	batcher := &unified2.SpoolBatcher{
+		Reader:           unified2.NewSpoolRecordReader(args[0], args[1]),
+		Send:             store.Insert,
+		BookmarkFilename: bookmark,
+		Errors: func(err error) {
+			log.Println(err)
+		},
+	}
+
+	stop := make(chan bool)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+
+	if err := batcher.Run(stop); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,85 @@
+// Print summary statistics for unified2 log files.
+package main
+
+import "os"
+import "fmt"
+import "io"
+import "flag"
+import "log"
+import "time"
+import "encoding/json"
+import "text/tabwriter"
+import "github.com/jasonish/go-unified2"
+
+func printCounts(out io.Writer, title string, counts []unified2.StatsCount) {
+	fmt.Fprintf(out, "\n%s\n", title)
+	for _, count := range counts {
+		fmt.Fprintf(out, "  %s\t%d\n", count.Key, count.Count)
+	}
+}
+
+func main() {
+
+	var asJson bool
+	var top int
+
+	flag.BoolVar(&asJson, "json", false, "output as json")
+	flag.IntVar(&top, "top", 10, "number of entries in top lists")
+	flag.Parse()
+
+	stats := unified2.NewStats()
+
+	for _, arg := range flag.Args() {
+
+		file, err := os.Open(arg)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for {
+			record, err := unified2.ReadRawRecord(file)
+			if err != nil {
+				if err != io.EOF {
+					log.Printf("%s: failed to read record: %s", arg, err)
+				}
+				break
+			}
+			if err := stats.AddRaw(record); err != nil {
+				log.Printf("%s: failed to decode record: %s", arg, err)
+			}
+		}
+
+		file.Close()
+	}
+
+	report := stats.Report(top)
+
+	if asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.Encode(report)
+		return
+	}
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(out, "Events:\t%d\n", report.Events)
+	fmt.Fprintf(out, "Packets:\t%d\n", report.Packets)
+	fmt.Fprintf(out, "Extra data:\t%d\n", report.ExtraData)
+	if report.FirstEvent != nil {
+		fmt.Fprintf(out, "First event:\t%s\n",
+			report.FirstEvent.Format(time.RFC3339Nano))
+		fmt.Fprintf(out, "Last event:\t%s\n",
+			report.LastEvent.Format(time.RFC3339Nano))
+	}
+	printCounts(out, "Records by type:", unified2.Top(report.Records, 0))
+	printCounts(out, "Top signatures (gid:sid):", report.TopSignatures)
+	printCounts(out, "Top source addresses:", report.TopSourceAddresses)
+	printCounts(out, "Top destination addresses:", report.TopDestinationAddresses)
+	printCounts(out, "Top source ports:", report.TopSourcePorts)
+	printCounts(out, "Top destination ports:", report.TopDestinationPorts)
+	printCounts(out, "Protocols:", report.Protocols)
+	printCounts(out, "Sensors:", report.Sensors)
+	printCounts(out, "Blocked:", report.Blocked)
+	printCounts(out, "VLANs:", report.Vlans)
+	printCounts(out, "MPLS labels:", report.MplsLabels)
+	out.Flush()
+}
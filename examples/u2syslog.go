@@ -0,0 +1,108 @@
+// Send events from a unified2 spool directory to a syslog server.
+package main
+
+import "os"
+import "flag"
+import "log"
+import "io"
+import "time"
+import "github.com/jasonish/go-unified2"
+
+func loadMap(filename string, load func(io.Reader) error) {
+	if filename == "" {
+		return
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	if err := load(file); err != nil {
+		log.Fatalf("%s: %s", filename, err)
+	}
+}
+
+func main() {
+
+	var network string
+	var address string
+	var rfc3164 bool
+	var format string
+	var sidMsgMap string
+	var genMsgMap string
+
+	flag.StringVar(&network, "network", "udp", "network: udp, tcp or unix")
+	flag.StringVar(&address, "address", "127.0.0.1:514", "syslog server address")
+	flag.BoolVar(&rfc3164, "rfc3164", false, "use RFC 3164 format")
+	flag.StringVar(&format, "format", "snort", "message format: snort, cef or leef")
+	flag.StringVar(&sidMsgMap, "sid-msg-map", "", "sid-msg.map filename")
+	flag.StringVar(&genMsgMap, "gen-msg-map", "", "gen-msg.map filename")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) != 2 {
+		log.Fatalf("usage: u2syslog [options] <directory> <prefix>")
+	}
+
+	writer := unified2.NewSyslogWriter(network, address)
+	if rfc3164 {
+		writer.Format = unified2.SYSLOG_RFC3164
+	}
+	writer.Signatures = unified2.NewSignatureMap()
+	loadMap(sidMsgMap, writer.Signatures.LoadSidMsgMap)
+	loadMap(genMsgMap, writer.Signatures.LoadGenMsgMap)
+
+	cef := unified2.NewCEFFormatter()
+	cef.Signatures = writer.Signatures
+	leef := unified2.NewLEEFFormatter()
+	leef.Signatures = writer.Signatures
+
+	// Events are held until the next event, or the end of the spool,
+	// so their extra data can be included.
+	var event *unified2.EventRecord
+	var extra []*unified2.ExtraDataRecord
+
+	flush := func() {
+		if event == nil {
+			return
+		}
+		var err error
+		switch format {
+		case "cef":
+			err = cef.Write(writer, event, extra)
+		case "leef":
+			err = leef.Write(writer, event, extra)
+		default:
+			err = writer.WriteEvent(event)
+		}
+		if err != nil {
+			log.Println(err)
+		}
+		event = nil
+		extra = nil
+	}
+
+	reader := unified2.NewSpoolRecordReader(args[0], args[1])
+
+	for {
+		// io.ErrUnexpectedEOF is returned while Snort is part way
+		// through writing a record, so is not fatal.
+		record, err := reader.Next()
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			log.Println(err)
+		}
+		if record == nil {
+			flush()
+			time.Sleep(time.Second)
+			continue
+		}
+
+		switch record := record.(type) {
+		case *unified2.EventRecord:
+			flush()
+			event = record
+		case *unified2.ExtraDataRecord:
+			extra = append(extra, record)
+		}
+	}
+}
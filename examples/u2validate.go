@@ -0,0 +1,57 @@
+// Validate unified2 log files, reporting any problems found.
+package main
+
+import "os"
+import "flag"
+import "log"
+import "fmt"
+import "encoding/json"
+import "github.com/jasonish/go-unified2"
+
+func main() {
+
+	var asJson bool
+
+	flag.BoolVar(&asJson, "json", false, "output report as json")
+	flag.Parse()
+
+	encoder := json.NewEncoder(os.Stdout)
+	valid := true
+
+	for _, arg := range flag.Args() {
+
+		file, err := os.Open(arg)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		report, err := unified2.Validate(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		file.Close()
+
+		if !report.Valid() {
+			valid = false
+		}
+
+		if asJson {
+			encoder.Encode(struct {
+				Filename string `json:"filename"`
+				Valid    bool   `json:"valid"`
+				*unified2.ValidationReport
+			}{arg, report.Valid(), report})
+			continue
+		}
+
+		for _, issue := range report.Issues {
+			fmt.Printf("%s: %s\n", arg, issue)
+		}
+		fmt.Printf("%s: %d records, %d issues\n", arg, report.Records,
+			len(report.Issues))
+	}
+
+	if !valid {
+		os.Exit(1)
+	}
+}
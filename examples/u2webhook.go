@@ -0,0 +1,67 @@
+// Post events from a unified2 spool directory to an HTTP webhook.
+package main
+
+import "os"
+import "os/signal"
+import "flag"
+import "log"
+import "crypto/tls"
+import "github.com/jasonish/go-unified2"
+
+func main() {
+
+	var url string
+	var token string
+	var bookmark string
+	var queue string
+	var maxQueued int
+	var batch bool
+	var insecure bool
+
+	flag.StringVar(&url, "url", "", "webhook url")
+	flag.StringVar(&token, "token", "", "bearer token")
+	flag.StringVar(&bookmark, "bookmark", "", "bookmark filename")
+	flag.StringVar(&queue, "queue", "", "directory to queue undelivered events in")
+	flag.IntVar(&maxQueued, "max-queued", 10000, "maximum number of queued requests")
+	flag.BoolVar(&batch, "batch", false, "post events in batches")
+	flag.BoolVar(&insecure, "insecure", false, "do not verify server certificate")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) != 2 || url == "" {
+		log.Fatalf("usage: u2webhook -url <url> [options] <directory> <prefix>")
+	}
+
+	sink := unified2.NewWebhookSink(url,
+		&tls.Config{InsecureSkipVerify: insecure})
+	sink.Token = token
+	sink.Batch = batch
+	sink.QueueDirectory = queue
+	sink.MaxQueued = maxQueued
+
+	batcher := &unified2.SpoolBatcher{
+		Reader:           unified2.NewSpoolRecordReader(args[0], args[1]),
+		Send:             sink.Send,
+		BookmarkFilename: bookmark,
+		Errors: func(err error) {
+			log.Println(err)
+		},
+		IdleHook: func() {
+			if err := sink.Drain(); err != nil {
+				log.Println(err)
+			}
+		},
+	}
+
+	stop := make(chan bool)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+
+	if err := batcher.Run(stop); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,86 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"net"
+)
+
+// Extra data types, the Type field of an ExtraDataRecord.
+const (
+	EXTRA_DATA_TYPE_XFF_IPV4        = 1
+	EXTRA_DATA_TYPE_XFF_IPV6        = 2
+	EXTRA_DATA_TYPE_REVIEWED_BY     = 3
+	EXTRA_DATA_TYPE_GZIP_DATA       = 4
+	EXTRA_DATA_TYPE_SMTP_FILENAME   = 5
+	EXTRA_DATA_TYPE_SMTP_MAIL_FROM  = 6
+	EXTRA_DATA_TYPE_SMTP_RCPT_TO    = 7
+	EXTRA_DATA_TYPE_SMTP_EMAIL_HDRS = 8
+	EXTRA_DATA_TYPE_HTTP_URI        = 9
+	EXTRA_DATA_TYPE_HTTP_HOSTNAME   = 10
+	EXTRA_DATA_TYPE_IPV6_SRC        = 11
+	EXTRA_DATA_TYPE_IPV6_DST        = 12
+	EXTRA_DATA_TYPE_JS_NORMALIZED   = 13
+)
+
+// Extra data data types, the DataType field of an ExtraDataRecord.
+const (
+	EXTRA_DATA_DATA_TYPE_BLOB = 1
+)
+
+// IP returns the IP address held by an extra data record of one of
+// the address types, such as the X-Forwarded-For address, or nil for
+// other types.
+func (e *ExtraDataRecord) IP() net.IP {
+	switch e.Type {
+	case EXTRA_DATA_TYPE_XFF_IPV4:
+		if len(e.Data) == 4 {
+			return net.IP(e.Data)
+		}
+	case EXTRA_DATA_TYPE_XFF_IPV6,
+		EXTRA_DATA_TYPE_IPV6_SRC,
+		EXTRA_DATA_TYPE_IPV6_DST:
+		if len(e.Data) == 16 {
+			return net.IP(e.Data)
+		}
+	}
+	return nil
+}
+
+// XffAddress returns the X-Forwarded-For address from the first of the
+// extra data records holding one, or nil.
+func XffAddress(extra []*ExtraDataRecord) net.IP {
+	for _, record := range extra {
+		if record.Type == EXTRA_DATA_TYPE_XFF_IPV4 ||
+			record.Type == EXTRA_DATA_TYPE_XFF_IPV6 {
+			if ip := record.IP(); ip != nil {
+				return ip
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+//go:build go1.18
+// +build go1.18
+
+package unified2
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// seedFiles returns the contents of each test log file.
+func seedFiles(f *testing.F) [][]byte {
+	filenames, err := filepath.Glob("test/*.log")
+	if err != nil {
+		f.Fatal(err)
+	}
+	var files [][]byte
+	for _, filename := range filenames {
+		buf, err := ioutil.ReadFile(filename)
+		if err != nil {
+			f.Fatal(err)
+		}
+		files = append(files, buf)
+	}
+	return files
+}
+
+// seedRecords returns the raw records of the test log files.
+func seedRecords(f *testing.F) []*RawRecord {
+	var records []*RawRecord
+	for _, buf := range seedFiles(f) {
+		file := memoryFile{bytes.NewReader(buf)}
+		for {
+			record, err := ReadRawRecord(file)
+			if err != nil {
+				break
+			}
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func FuzzReadRecord(f *testing.F) {
+	for _, buf := range seedFiles(f) {
+		f.Add(buf)
+	}
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		file := memoryFile{bytes.NewReader(buf)}
+		for {
+			record, err := ReadRecord(file)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			} else if err != nil {
+				// Skip the record that failed to decode.
+				continue
+			}
+			if packet, ok := record.(*PacketRecord); ok {
+				packet.Decode()
+			}
+		}
+	})
+}
+
+func FuzzDecodeEventRecord(f *testing.F) {
+	for _, record := range seedRecords(f) {
+		if isEventType(record.Type) {
+			f.Add(record.Type, record.Data)
+		}
+	}
+	f.Fuzz(func(t *testing.T, eventType uint32, data []byte) {
+		event, err := DecodeEventRecord(eventType, data)
+		if err == nil {
+			_ = event.ProtocolName()
+			_ = event.BlockedStatus().String()
+			_ = event.ImpactFlags().String()
+		}
+	})
+}
+
+func FuzzDecodePacketRecord(f *testing.F) {
+	for _, record := range seedRecords(f) {
+		if isPacketType(record.Type) {
+			f.Add(record.Data)
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		packet, err := DecodePacketRecord(data)
+		if err != nil {
+			return
+		}
+		if len(packet.Data) != len(data)-PACKET_RECORD_HDR_LEN {
+			t.Fatalf("expected %d bytes of packet data, got %d",
+				len(data)-PACKET_RECORD_HDR_LEN, len(packet.Data))
+		}
+		if _, err := DecodeBufferRecord(data); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func FuzzDecodeExtraDataRecord(f *testing.F) {
+	for _, record := range seedRecords(f) {
+		if record.Type == UNIFIED2_EXTRA_DATA {
+			f.Add(record.Data)
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		extra, err := DecodeExtraDataRecord(data)
+		if err != nil {
+			return
+		}
+		if len(extra.Data) != len(data)-EXTRA_DATA_RECORD_HDR_LEN {
+			t.Fatalf("expected %d bytes of extra data, got %d",
+				len(data)-EXTRA_DATA_RECORD_HDR_LEN, len(extra.Data))
+		}
+	})
+}
+
+func FuzzDecodePacket(f *testing.F) {
+	for _, record := range seedRecords(f) {
+		if isPacketType(record.Type) {
+			packet, err := DecodePacketRecord(record.Data)
+			if err == nil {
+				f.Add(packet.LinkType, packet.Data)
+			}
+		}
+	}
+	// Packets from the generator cover the IPv6, UDP and ICMP decoders.
+	generator := NewGenerator(1)
+	generator.EventTypes[UNIFIED2_EVENT_V3] = 1
+	for i := 0; i < 20; i++ {
+		records, err := generator.Next()
+		if err != nil {
+			f.Fatal(err)
+		}
+		for _, record := range records {
+			if record.Type == UNIFIED2_PACKET {
+				f.Add(uint32(LINKTYPE_ETHERNET),
+					record.Data[PACKET_RECORD_HDR_LEN:])
+			}
+		}
+	}
+	f.Fuzz(func(t *testing.T, linkType uint32, data []byte) {
+		packet, err := DecodePacket(linkType, data)
+		if err != nil {
+			return
+		}
+		// Checksums can only be updated on a copy of the packet.
+		updateChecksums(append([]byte{}, data...), packet)
+	})
+}
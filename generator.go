@@ -0,0 +1,422 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"time"
+)
+
+// Generator produces synthetic but valid unified2 records for use as
+// test fixtures and for load testing.  The output is determined by the
+// seed, so the same seed and settings always produce the same stream.
+type Generator struct {
+	// EventTypes maps the event record types to generate to their
+	// relative weights.  Defaults to equal parts IPv4 and IPv6 v2
+	// events and IPv4 AppId events.
+	EventTypes map[uint32]int
+
+	// PacketsPerEvent is the maximum number of packets generated for
+	// an event.  Each event gets between 1 and PacketsPerEvent packets,
+	// or none if 0.
+	PacketsPerEvent int
+
+	// ExtraDataTypes are the extra data types that may be attached to
+	// an event, each with probability ExtraDataProbability.
+	ExtraDataTypes       []uint32
+	ExtraDataProbability float64
+
+	SensorId uint32
+
+	// Time is the time of the next event.  Successive events are a
+	// random interval of up to twice Interval apart.
+	Time     time.Time
+	Interval time.Duration
+
+	rand    *rand.Rand
+	eventId uint32
+}
+
+// NewGenerator creates a Generator with default settings seeded with
+// seed.
+func NewGenerator(seed int64) *Generator {
+	return &Generator{
+		EventTypes: map[uint32]int{
+			UNIFIED2_EVENT_V2:     1,
+			UNIFIED2_EVENT_V2_IP6: 1,
+			UNIFIED2_EVENT_APPID:  1,
+		},
+		PacketsPerEvent: 1,
+		ExtraDataTypes: []uint32{
+			EXTRA_DATA_TYPE_XFF_IPV4,
+			EXTRA_DATA_TYPE_HTTP_URI,
+			EXTRA_DATA_TYPE_HTTP_HOSTNAME,
+		},
+		ExtraDataProbability: 0.25,
+		Time:                 time.Unix(1400000000, 0),
+		Interval:             time.Second,
+		rand:                 rand.New(rand.NewSource(seed)),
+	}
+}
+
+var generatorAppIds = []string{"HTTP", "DNS", "SSH", "SMTP", "SSL"}
+
+var generatorPorts = map[uint8][]uint16{
+	IPPROTO_TCP: {22, 25, 80, 443, 445, 3389, 8080},
+	IPPROTO_UDP: {53, 123, 161, 514, 5060},
+}
+
+// Next returns the records of the next event: the event record
+// followed by its packet and extra data records.
+func (g *Generator) Next() ([]*RawRecord, error) {
+	eventType, err := g.eventType()
+	if err != nil {
+		return nil, err
+	}
+
+	g.eventId++
+	if g.Interval > 0 {
+		g.Time = g.Time.Add(time.Duration(g.rand.Int63n(
+			int64(2 * g.Interval))))
+	}
+
+	event := g.event(eventType)
+
+	record, err := EncodeEventRecord(eventType, event)
+	if err != nil {
+		return nil, err
+	}
+	records := []*RawRecord{record}
+
+	if g.PacketsPerEvent > 0 {
+		packets := 1 + g.rand.Intn(g.PacketsPerEvent)
+		for i := 0; i < packets; i++ {
+			records = append(records, EncodePacketRecord(
+				g.packet(event, i)))
+		}
+	}
+
+	for _, extraType := range g.ExtraDataTypes {
+		if g.rand.Float64() < g.ExtraDataProbability {
+			records = append(records, EncodeExtraDataRecord(
+				g.extraData(event, extraType)))
+		}
+	}
+
+	return records, nil
+}
+
+// Write writes the records of count events to writer.
+func (g *Generator) Write(writer io.Writer, count int) error {
+	for i := 0; i < count; i++ {
+		records, err := g.Next()
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if err := WriteRawRecord(writer, record); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// eventType picks an event type by weight.  The types are sorted so
+// the choice does not depend on map iteration order.
+func (g *Generator) eventType() (uint32, error) {
+	var types []int
+	total := 0
+	for eventType, weight := range g.EventTypes {
+		if !isEventType(eventType) {
+			return 0, fmt.Errorf("not an event type: %d", eventType)
+		}
+		if weight > 0 {
+			types = append(types, int(eventType))
+			total += weight
+		}
+	}
+	if total == 0 {
+		return 0, fmt.Errorf("no event types to generate")
+	}
+	sort.Ints(types)
+
+	n := g.rand.Intn(total)
+	for _, eventType := range types {
+		n -= g.EventTypes[uint32(eventType)]
+		if n < 0 {
+			return uint32(eventType), nil
+		}
+	}
+	panic("not reached")
+}
+
+func (g *Generator) event(eventType uint32) *EventRecord {
+	event := &EventRecord{
+		SensorId:          g.SensorId,
+		EventId:           g.eventId,
+		EventSecond:       uint32(g.Time.Unix()),
+		EventMicrosecond:  uint32(g.Time.Nanosecond() / 1000),
+		GeneratorId:       1,
+		SignatureId:       2000000 + uint32(g.rand.Intn(50)),
+		SignatureRevision: 1 + uint32(g.rand.Intn(3)),
+		ClassificationId:  1 + uint32(g.rand.Intn(30)),
+		Priority:          1 + uint32(g.rand.Intn(3)),
+	}
+
+	ip6 := false
+	switch eventType {
+	case UNIFIED2_EVENT_IP6, UNIFIED2_EVENT_V2_IP6,
+		UNIFIED2_EVENT_APPID_IP6, UNIFIED2_EVENT_MPLS_IP6:
+		ip6 = true
+	case UNIFIED2_EVENT_V3:
+		ip6 = g.rand.Intn(2) == 0
+	}
+	event.IpSource = g.address(ip6)
+	event.IpDestination = g.address(ip6)
+
+	switch n := g.rand.Intn(10); {
+	case n < 7:
+		event.Protocol = IPPROTO_TCP
+	case n < 9:
+		event.Protocol = IPPROTO_UDP
+	case ip6:
+		event.Protocol = IPPROTO_ICMPV6
+	default:
+		event.Protocol = IPPROTO_ICMP
+	}
+
+	if event.HasPorts() {
+		ports := generatorPorts[event.Protocol]
+		event.SportItype = 1024 + uint16(g.rand.Intn(64511))
+		event.DportIcode = ports[g.rand.Intn(len(ports))]
+	} else if ip6 {
+		// Echo request.
+		event.SportItype = 128
+	} else {
+		event.SportItype = 8
+	}
+
+	if g.rand.Intn(4) == 0 {
+		event.VlanId = 1 + uint16(g.rand.Intn(4094))
+	}
+	if eventType == UNIFIED2_EVENT_MPLS ||
+		eventType == UNIFIED2_EVENT_MPLS_IP6 {
+		event.MplsLabel = 16 + uint32(g.rand.Intn(1<<20-16))
+	}
+
+	switch eventType {
+	case UNIFIED2_EVENT_APPID, UNIFIED2_EVENT_APPID_IP6,
+		UNIFIED2_EVENT_V3:
+		event.AppId = generatorAppIds[g.rand.Intn(len(generatorAppIds))]
+	}
+
+	if eventType == UNIFIED2_EVENT_V3 {
+		if g.rand.Intn(5) == 0 {
+			event.Action = EVENT_ACTION_DROP
+		}
+	} else if g.rand.Intn(5) == 0 {
+		event.Blocked = 1
+	}
+
+	return event
+}
+
+// address returns a random private IPv4 address or documentation
+// IPv6 address.
+func (g *Generator) address(ip6 bool) net.IP {
+	if ip6 {
+		ip := make(net.IP, 16)
+		copy(ip, []byte{0x20, 0x01, 0x0d, 0xb8})
+		g.rand.Read(ip[4:])
+		return ip
+	}
+	return net.IPv4(10, byte(g.rand.Intn(256)), byte(g.rand.Intn(256)),
+		byte(1+g.rand.Intn(254))).To4()
+}
+
+// packet builds an Ethernet packet matching the addresses, protocol
+// and ports of the event.
+func (g *Generator) packet(event *EventRecord, index int) *PacketRecord {
+	payload := g.payload(event)
+
+	var transport []byte
+	switch event.Protocol {
+	case IPPROTO_TCP:
+		transport = make([]byte, 20)
+		binary.BigEndian.PutUint16(transport[0:], event.SportItype)
+		binary.BigEndian.PutUint16(transport[2:], event.DportIcode)
+		binary.BigEndian.PutUint32(transport[4:], g.rand.Uint32())
+		binary.BigEndian.PutUint32(transport[8:], g.rand.Uint32())
+		transport[12] = 5 << 4
+		transport[13] = 0x18 // PSH, ACK
+		binary.BigEndian.PutUint16(transport[14:], 65535)
+	case IPPROTO_UDP:
+		transport = make([]byte, 8)
+		binary.BigEndian.PutUint16(transport[0:], event.SportItype)
+		binary.BigEndian.PutUint16(transport[2:], event.DportIcode)
+		binary.BigEndian.PutUint16(transport[4:],
+			uint16(8+len(payload)))
+	default:
+		transport = make([]byte, 8)
+		transport[0] = uint8(event.SportItype)
+		transport[1] = uint8(event.DportIcode)
+		binary.BigEndian.PutUint16(transport[4:],
+			uint16(g.rand.Intn(65536)))
+		binary.BigEndian.PutUint16(transport[6:], uint16(index+1))
+	}
+	transport = append(transport, payload...)
+
+	data := make([]byte, 14)
+	g.rand.Read(data[0:12])
+	// Clear the multicast bits of the MAC addresses.
+	data[0] &^= 1
+	data[6] &^= 1
+
+	if source := event.IpSource.To4(); source != nil {
+		binary.BigEndian.PutUint16(data[12:], ETHERTYPE_IPV4)
+		ip := make([]byte, 20)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(transport)))
+		binary.BigEndian.PutUint16(ip[4:], uint16(g.rand.Intn(65536)))
+		ip[6] = 0x40 // Don't fragment.
+		ip[8] = 64
+		ip[9] = event.Protocol
+		copy(ip[12:], source)
+		copy(ip[16:], event.IpDestination.To4())
+		data = append(data, ip...)
+	} else {
+		binary.BigEndian.PutUint16(data[12:], ETHERTYPE_IPV6)
+		ip := make([]byte, 40)
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(transport)))
+		ip[6] = event.Protocol
+		ip[7] = 64
+		copy(ip[8:], event.IpSource.To16())
+		copy(ip[24:], event.IpDestination.To16())
+		data = append(data, ip...)
+	}
+	data = append(data, transport...)
+
+	if packet, err := DecodePacket(LINKTYPE_ETHERNET, data); err == nil {
+		updateChecksums(data, packet)
+	}
+
+	return &PacketRecord{
+		SensorId:          event.SensorId,
+		EventId:           event.EventId,
+		EventSecond:       event.EventSecond,
+		PacketSecond:      event.EventSecond,
+		PacketMicrosecond: event.EventMicrosecond,
+		LinkType:          LINKTYPE_ETHERNET,
+		Length:            uint32(len(data)),
+		Data:              data,
+	}
+}
+
+// payload returns an application payload suited to the destination
+// port of the event.
+func (g *Generator) payload(event *EventRecord) []byte {
+	if event.HasPorts() {
+		switch event.DportIcode {
+		case 80, 8080:
+			return []byte(fmt.Sprintf("GET %s HTTP/1.1\r\n"+
+				"Host: %s\r\n"+
+				"User-Agent: Mozilla/5.0\r\n\r\n",
+				g.uri(), g.hostname()))
+		case 25:
+			return []byte(fmt.Sprintf("MAIL FROM:<%s>\r\n",
+				g.mailAddress()))
+		case 22:
+			return []byte("SSH-2.0-OpenSSH_6.6\r\n")
+		}
+	}
+	payload := make([]byte, g.rand.Intn(256))
+	g.rand.Read(payload)
+	return payload
+}
+
+func (g *Generator) uri() string {
+	return fmt.Sprintf("/index%d.php?id=%d", g.rand.Intn(100),
+		g.rand.Intn(100000))
+}
+
+func (g *Generator) hostname() string {
+	return fmt.Sprintf("www.example%d.com", g.rand.Intn(100))
+}
+
+func (g *Generator) mailAddress() string {
+	return fmt.Sprintf("user%d@example%d.com", g.rand.Intn(1000),
+		g.rand.Intn(100))
+}
+
+func (g *Generator) extraData(event *EventRecord,
+	extraType uint32) *ExtraDataRecord {
+	var data []byte
+	dataType := uint32(EXTRA_DATA_DATA_TYPE_BLOB)
+
+	switch extraType {
+	case EXTRA_DATA_TYPE_XFF_IPV4:
+		data = g.address(false)
+	case EXTRA_DATA_TYPE_XFF_IPV6, EXTRA_DATA_TYPE_IPV6_SRC,
+		EXTRA_DATA_TYPE_IPV6_DST:
+		data = g.address(true)
+	case EXTRA_DATA_TYPE_HTTP_URI:
+		data = []byte(g.uri())
+	case EXTRA_DATA_TYPE_HTTP_HOSTNAME:
+		data = []byte(g.hostname())
+	case EXTRA_DATA_TYPE_SMTP_FILENAME:
+		data = []byte(fmt.Sprintf("invoice%d.pdf", g.rand.Intn(1000)))
+	case EXTRA_DATA_TYPE_SMTP_MAIL_FROM, EXTRA_DATA_TYPE_SMTP_RCPT_TO:
+		data = []byte(g.mailAddress())
+	case EXTRA_DATA_TYPE_SMTP_EMAIL_HDRS:
+		data = []byte(fmt.Sprintf("From: %s\r\nSubject: Invoice %d\r\n",
+			g.mailAddress(), g.rand.Intn(1000)))
+	case EXTRA_DATA_TYPE_JS_NORMALIZED:
+		data = []byte(fmt.Sprintf("document.write(\"%s\");", g.uri()))
+	default:
+		data = make([]byte, 1+g.rand.Intn(64))
+		g.rand.Read(data)
+	}
+
+	return &ExtraDataRecord{
+		EventType:   EXTRA_DATA_EVENT_TYPE,
+		EventLength: uint32(EXTRA_DATA_RECORD_HDR_LEN + len(data)),
+		SensorId:    event.SensorId,
+		EventId:     event.EventId,
+		EventSecond: event.EventSecond,
+		Type:        extraType,
+		DataType:    dataType,
+		DataLength:  uint32(len(data) + 8),
+		Data:        data,
+	}
+}
@@ -0,0 +1,184 @@
+package unified2
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func generate(t *testing.T, generator *Generator, count int) []byte {
+	var buf bytes.Buffer
+	if err := generator.Write(&buf, count); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGeneratorSeed(t *testing.T) {
+	a := generate(t, NewGenerator(1), 100)
+	b := generate(t, NewGenerator(1), 100)
+	if !bytes.Equal(a, b) {
+		t.Fatal("same seed generated different output")
+	}
+	if bytes.Equal(a, generate(t, NewGenerator(2), 100)) {
+		t.Fatal("different seeds generated the same output")
+	}
+}
+
+func TestGeneratorValid(t *testing.T) {
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	generator := NewGenerator(1)
+	generator.EventTypes = map[uint32]int{
+		UNIFIED2_EVENT:           1,
+		UNIFIED2_EVENT_IP6:       1,
+		UNIFIED2_EVENT_V2:        1,
+		UNIFIED2_EVENT_V2_IP6:    1,
+		UNIFIED2_EVENT_APPID:     1,
+		UNIFIED2_EVENT_APPID_IP6: 1,
+		UNIFIED2_EVENT_MPLS:      1,
+		UNIFIED2_EVENT_MPLS_IP6:  1,
+		UNIFIED2_EVENT_V3:        1,
+	}
+	generator.PacketsPerEvent = 3
+	generator.ExtraDataProbability = 0.5
+	for extraType := uint32(EXTRA_DATA_TYPE_XFF_IPV4); extraType <= EXTRA_DATA_TYPE_JS_NORMALIZED; extraType++ {
+		generator.ExtraDataTypes = append(generator.ExtraDataTypes,
+			extraType)
+	}
+
+	filename := path.Join(tmpdir, "generated.log")
+	if err := ioutil.WriteFile(filename, generate(t, generator, 500),
+		0644); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := Validate(file)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid() {
+		t.Fatalf("generated file is not valid: %v", report.Issues)
+	}
+	for eventType := range generator.EventTypes {
+		if report.RecordTypes[eventType] == 0 {
+			t.Fatalf("no events of type %d generated", eventType)
+		}
+	}
+
+	var event *EventRecord
+	for _, record := range readRecords(t, filename) {
+		switch record := record.(type) {
+		case *EventRecord:
+			event = record
+		case *PacketRecord:
+			if record.EventId != event.EventId {
+				t.Fatalf("packet for event %d follows event %d",
+					record.EventId, event.EventId)
+			}
+			packet, err := record.Decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if packet.Truncated || packet.TransportOffset < 0 {
+				t.Fatalf("packet not fully decoded: %+v", packet)
+			}
+			if packet.IPv4 != nil {
+				if !packet.IPv4.Source.Equal(event.IpSource) {
+					t.Fatalf("packet source %s, event source %s",
+						packet.IPv4.Source, event.IpSource)
+				}
+			} else if !packet.IPv6.Source.Equal(event.IpSource) {
+				t.Fatalf("packet source %s, event source %s",
+					packet.IPv6.Source, event.IpSource)
+			}
+			if packet.TCP != nil &&
+				packet.TCP.DestinationPort != event.DestinationPort() {
+				t.Fatalf("packet port %d, event port %d",
+					packet.TCP.DestinationPort, event.DestinationPort())
+			}
+
+			// Recomputing the checksums should not change them.
+			data := append([]byte{}, record.Data...)
+			updateChecksums(data, packet)
+			if !reflect.DeepEqual(data, record.Data) {
+				t.Fatal("bad checksum in generated packet")
+			}
+		case *ExtraDataRecord:
+			if record.EventId != event.EventId {
+				t.Fatalf("extra data for event %d follows event %d",
+					record.EventId, event.EventId)
+			}
+		}
+	}
+}
+
+func TestEncodeRecords(t *testing.T) {
+	file, err := os.Open("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	for {
+		raw, err := ReadRawRecord(file)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		var encoded *RawRecord
+		switch {
+		case isEventType(raw.Type):
+			event, err := DecodeEventRecord(raw.Type, raw.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded, err = EncodeEventRecord(raw.Type, event)
+			if err != nil {
+				t.Fatal(err)
+			}
+		case raw.Type == UNIFIED2_PACKET:
+			packet, err := DecodePacketRecord(raw.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded = EncodePacketRecord(packet)
+		case raw.Type == UNIFIED2_EXTRA_DATA:
+			extra, err := DecodeExtraDataRecord(raw.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded = EncodeExtraDataRecord(extra)
+		default:
+			continue
+		}
+		if !reflect.DeepEqual(raw, encoded) {
+			t.Fatalf("record of type %d encoded differently", raw.Type)
+		}
+	}
+
+	event := &EventRecord{
+		IpSource:      net.ParseIP("2001:db8::1"),
+		IpDestination: net.ParseIP("2001:db8::2"),
+	}
+	if _, err := EncodeEventRecord(UNIFIED2_EVENT_V2, event); err != EncodingError {
+		t.Fatalf("expected EncodingError, got %v", err)
+	}
+}
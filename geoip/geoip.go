@@ -0,0 +1,374 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+/*
+Package geoip enriches unified2 events with the country, city and
+autonomous system of their addresses, looked up in local MaxMind
+databases.
+
+Lookups are cached, and the databases are reopened when their files
+change so updated databases are picked up without a restart.  As the
+open databases are memory mapped, update them by renaming a new file
+over the old one rather than writing to it in place.
+*/
+package geoip
+
+import (
+	"container/list"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jasonish/go-unified2"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// The name of the enrichment added to events.
+const ENRICHMENT_NAME = "geoip"
+
+// Location is what is known about an address.
+type Location struct {
+	CountryCode    string  `json:"country_code,omitempty"`
+	Country        string  `json:"country,omitempty"`
+	City           string  `json:"city,omitempty"`
+	Latitude       float64 `json:"latitude,omitempty"`
+	Longitude      float64 `json:"longitude,omitempty"`
+	ASN            uint    `json:"asn,omitempty"`
+	ASOrganization string  `json:"as_org,omitempty"`
+}
+
+// Enrichment is the enrichment added to an event.  Addresses that were
+// not found are nil.
+type Enrichment struct {
+	Source      *Location `json:"src,omitempty"`
+	Destination *Location `json:"dest,omitempty"`
+	Xff         *Location `json:"xff,omitempty"`
+}
+
+// The parts of the GeoIP2 City and Country, and GeoLite2 ASN, records
+// that are used.
+type cityRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		IsoCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+type asnRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// database is a MaxMind database file and the state of the file when it
+// was opened.
+type database struct {
+	filename string
+	reader   *maxminddb.Reader
+	modTime  time.Time
+	size     int64
+}
+
+func openDatabase(filename string) (*database, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := maxminddb.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &database{filename, reader, info.ModTime(), info.Size()}, nil
+}
+
+// changed returns true if the file has changed since it was opened.
+func (d *database) changed() bool {
+	info, err := os.Stat(d.filename)
+	if err != nil {
+		// Keep using the open database while the file is being
+		// replaced.
+		return false
+	}
+	return !info.ModTime().Equal(d.modTime) || info.Size() != d.size
+}
+
+type cacheEntry struct {
+	key      string
+	location *Location
+}
+
+// Enricher looks up the addresses of events in a City (or Country)
+// database and an ASN database.  It is safe for concurrent use.
+type Enricher struct {
+	// Language is the language of country and city names.  Defaults
+	// to "en".
+	Language string
+
+	// CacheSize is the number of lookups to cache.  Defaults to 10000,
+	// a negative value disables the cache.
+	CacheSize int
+
+	// ReloadInterval is how often to check if the database files have
+	// changed.  Defaults to 1 minute, a negative value disables
+	// reloading.
+	ReloadInterval time.Duration
+
+	// Errors, if set, is called with errors reloading the databases.
+	Errors func(err error)
+
+	lock       sync.RWMutex
+	city       *database
+	asn        *database
+	lastCheck  time.Time
+	checkLock  sync.Mutex
+	cacheLock  sync.Mutex
+	cache      map[string]*list.Element
+	cacheOrder *list.List
+}
+
+// New creates an Enricher from a City or Country database and an ASN
+// database.  Either filename may be empty.
+func New(cityFilename string, asnFilename string) (*Enricher, error) {
+	e := &Enricher{
+		cache:      map[string]*list.Element{},
+		cacheOrder: list.New(),
+		lastCheck:  time.Now(),
+	}
+	var err error
+	if cityFilename != "" {
+		if e.city, err = openDatabase(cityFilename); err != nil {
+			return nil, err
+		}
+	}
+	if asnFilename != "" {
+		if e.asn, err = openDatabase(asnFilename); err != nil {
+			e.Close()
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// Close closes the databases.
+func (e *Enricher) Close() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for _, db := range []*database{e.city, e.asn} {
+		if db != nil {
+			db.reader.Close()
+		}
+	}
+	e.city, e.asn = nil, nil
+}
+
+// Reload reopens any database whose file has changed and clears the
+// cache.  If a database can not be opened the old one stays in use and
+// the error is returned.
+func (e *Enricher) Reload() error {
+	e.lock.RLock()
+	databases := []*database{e.city, e.asn}
+	e.lock.RUnlock()
+
+	reopened := make([]*database, len(databases))
+	changed := false
+	for i, db := range databases {
+		if db == nil || !db.changed() {
+			continue
+		}
+		updated, err := openDatabase(db.filename)
+		if err != nil {
+			for _, db := range reopened {
+				if db != nil {
+					db.reader.Close()
+				}
+			}
+			return err
+		}
+		reopened[i] = updated
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	e.lock.Lock()
+	if reopened[0] != nil {
+		e.city.reader.Close()
+		e.city = reopened[0]
+	}
+	if reopened[1] != nil {
+		e.asn.reader.Close()
+		e.asn = reopened[1]
+	}
+	e.lock.Unlock()
+
+	e.cacheLock.Lock()
+	e.cache = map[string]*list.Element{}
+	e.cacheOrder.Init()
+	e.cacheLock.Unlock()
+
+	return nil
+}
+
+// checkReload reloads the databases if ReloadInterval has passed since
+// they were last checked.
+func (e *Enricher) checkReload() {
+	interval := e.ReloadInterval
+	if interval == 0 {
+		interval = time.Minute
+	} else if interval < 0 {
+		return
+	}
+
+	e.checkLock.Lock()
+	if time.Since(e.lastCheck) < interval {
+		e.checkLock.Unlock()
+		return
+	}
+	e.lastCheck = time.Now()
+	e.checkLock.Unlock()
+
+	if err := e.Reload(); err != nil && e.Errors != nil {
+		e.Errors(err)
+	}
+}
+
+// Lookup returns the location of an address, or nil if it is not in
+// any of the databases.  Locations are shared through the cache so
+// must not be modified.
+func (e *Enricher) Lookup(ip net.IP) *Location {
+	if ip == nil {
+		return nil
+	}
+	e.checkReload()
+
+	key := string(ip.To16())
+	if location, ok := e.cached(key); ok {
+		return location
+	}
+
+	location := e.lookup(ip)
+	e.store(key, location)
+	return location
+}
+
+func (e *Enricher) lookup(ip net.IP) *Location {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	language := e.Language
+	if language == "" {
+		language = "en"
+	}
+
+	location := &Location{}
+	found := false
+
+	if e.city != nil {
+		var record cityRecord
+		_, ok, err := e.city.reader.LookupNetwork(ip, &record)
+		if err == nil && ok {
+			found = true
+			location.CountryCode = record.Country.IsoCode
+			location.Country = record.Country.Names[language]
+			location.City = record.City.Names[language]
+			location.Latitude = record.Location.Latitude
+			location.Longitude = record.Location.Longitude
+		}
+	}
+
+	if e.asn != nil {
+		var record asnRecord
+		_, ok, err := e.asn.reader.LookupNetwork(ip, &record)
+		if err == nil && ok {
+			found = true
+			location.ASN = record.Number
+			location.ASOrganization = record.Organization
+		}
+	}
+
+	if !found {
+		return nil
+	}
+	return location
+}
+
+func (e *Enricher) cached(key string) (*Location, bool) {
+	if e.CacheSize < 0 {
+		return nil, false
+	}
+	e.cacheLock.Lock()
+	defer e.cacheLock.Unlock()
+	element, ok := e.cache[key]
+	if !ok {
+		return nil, false
+	}
+	e.cacheOrder.MoveToFront(element)
+	return element.Value.(*cacheEntry).location, true
+}
+
+func (e *Enricher) store(key string, location *Location) {
+	size := e.CacheSize
+	if size == 0 {
+		size = 10000
+	} else if size < 0 {
+		return
+	}
+	e.cacheLock.Lock()
+	defer e.cacheLock.Unlock()
+	if _, ok := e.cache[key]; ok {
+		return
+	}
+	e.cache[key] = e.cacheOrder.PushFront(&cacheEntry{key, location})
+	for e.cacheOrder.Len() > size {
+		oldest := e.cacheOrder.Back()
+		e.cacheOrder.Remove(oldest)
+		delete(e.cache, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Enrich adds an Enrichment for the source, destination and
+// X-Forwarded-For addresses of an event.  Nothing is added if none of
+// the addresses were found.
+func (e *Enricher) Enrich(event *unified2.AggregatedEvent) {
+	enrichment := &Enrichment{
+		Source:      e.Lookup(event.Event.IpSource),
+		Destination: e.Lookup(event.Event.IpDestination),
+		Xff:         e.Lookup(unified2.XffAddress(event.ExtraData)),
+	}
+	if enrichment.Source == nil && enrichment.Destination == nil &&
+		enrichment.Xff == nil {
+		return
+	}
+	event.SetEnrichment(ENRICHMENT_NAME, enrichment)
+}
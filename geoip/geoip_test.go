@@ -0,0 +1,194 @@
+package geoip
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/jasonish/go-unified2"
+)
+
+func TestLookup(t *testing.T) {
+	enricher, err := New("../test/geoip-city.mmdb", "../test/geoip-asn.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enricher.Close()
+
+	location := enricher.Lookup(net.ParseIP("81.2.69.160"))
+	if location == nil {
+		t.Fatal("81.2.69.160 not found")
+	}
+	expected := Location{
+		CountryCode:    "GB",
+		Country:        "United Kingdom",
+		City:           "London",
+		Latitude:       51.5142,
+		Longitude:      -0.0931,
+		ASN:            20712,
+		ASOrganization: "Andrews & Arnold Ltd",
+	}
+	if *location != expected {
+		t.Fatalf("expected %+v, got %+v", expected, *location)
+	}
+
+	// IPv6, with the city and ASN from different sized networks.
+	location = enricher.Lookup(net.ParseIP("2001:db8:1::1"))
+	if location == nil || location.CountryCode != "SE" ||
+		location.ASN != 64496 {
+		t.Fatalf("unexpected location for 2001:db8:1::1: %+v", location)
+	}
+	location = enricher.Lookup(net.ParseIP("2001:db8:2::1"))
+	if location == nil || location.CountryCode != "" ||
+		location.ASN != 64496 {
+		t.Fatalf("unexpected location for 2001:db8:2::1: %+v", location)
+	}
+
+	if location := enricher.Lookup(net.ParseIP("10.0.0.1")); location != nil {
+		t.Fatalf("expected nil for 10.0.0.1, got %+v", location)
+	}
+	if location := enricher.Lookup(nil); location != nil {
+		t.Fatalf("expected nil for nil address, got %+v", location)
+	}
+}
+
+func TestCache(t *testing.T) {
+	enricher, err := New("../test/geoip-city.mmdb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enricher.Close()
+	enricher.CacheSize = 2
+
+	a := enricher.Lookup(net.ParseIP("81.2.69.1"))
+	if enricher.Lookup(net.ParseIP("81.2.69.1")) != a {
+		t.Fatal("lookup not cached")
+	}
+	enricher.Lookup(net.ParseIP("81.2.69.2"))
+	enricher.Lookup(net.ParseIP("81.2.69.3"))
+	if enricher.cacheOrder.Len() != 2 {
+		t.Fatalf("expected 2 cached entries, got %d",
+			enricher.cacheOrder.Len())
+	}
+	if _, ok := enricher.cache[string(net.ParseIP("81.2.69.1"))]; ok {
+		t.Fatal("least recently used entry not evicted")
+	}
+}
+
+func TestReload(t *testing.T) {
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	copyFile := func(from string, to string) {
+		buf, err := ioutil.ReadFile(from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(to+".tmp", buf, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(to+".tmp", to); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Start with a city database holding no cities.
+	filename := path.Join(tmpdir, "city.mmdb")
+	copyFile("../test/geoip-asn.mmdb", filename)
+
+	enricher, err := New(filename, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enricher.Close()
+	enricher.ReloadInterval = time.Nanosecond
+
+	ip := net.ParseIP("81.2.69.160")
+	if location := enricher.Lookup(ip); location == nil ||
+		location.City != "" {
+		t.Fatalf("unexpected location %+v", location)
+	}
+
+	copyFile("../test/geoip-city.mmdb", filename)
+	if location := enricher.Lookup(ip); location == nil ||
+		location.City != "London" {
+		t.Fatalf("database not reloaded: %+v", location)
+	}
+
+	// A broken update is reported and the old database kept.
+	var reloadErr error
+	enricher.Errors = func(err error) {
+		reloadErr = err
+	}
+	if err := ioutil.WriteFile(filename+".tmp", []byte("garbage"),
+		0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filename+".tmp", filename); err != nil {
+		t.Fatal(err)
+	}
+	if location := enricher.Lookup(ip); location == nil ||
+		location.City != "London" {
+		t.Fatalf("unexpected location %+v", location)
+	}
+	if reloadErr == nil {
+		t.Fatal("expected reload error")
+	}
+}
+
+func TestEnrich(t *testing.T) {
+	enricher, err := New("../test/geoip-city.mmdb", "../test/geoip-asn.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enricher.Close()
+
+	event := &unified2.AggregatedEvent{
+		Event: &unified2.EventRecord{
+			IpSource:      net.ParseIP("10.1.1.1").To4(),
+			IpDestination: net.ParseIP("216.160.83.56").To4(),
+		},
+		ExtraData: []*unified2.ExtraDataRecord{
+			{
+				Type: unified2.EXTRA_DATA_TYPE_XFF_IPV4,
+				Data: net.ParseIP("81.2.69.160").To4(),
+			},
+		},
+	}
+	enricher.Enrich(event)
+
+	enrichment, ok := event.Enrichment[ENRICHMENT_NAME].(*Enrichment)
+	if !ok {
+		t.Fatalf("no enrichment added: %v", event.Enrichment)
+	}
+	if enrichment.Source != nil {
+		t.Fatalf("unexpected source location %+v", enrichment.Source)
+	}
+	if enrichment.Destination == nil ||
+		enrichment.Destination.City != "Milton" {
+		t.Fatalf("unexpected destination location %+v",
+			enrichment.Destination)
+	}
+	if enrichment.Xff == nil || enrichment.Xff.CountryCode != "GB" {
+		t.Fatalf("unexpected xff location %+v", enrichment.Xff)
+	}
+
+	// Nothing is added for events with no known addresses.
+	event = &unified2.AggregatedEvent{
+		Event: &unified2.EventRecord{
+			IpSource:      net.ParseIP("10.1.1.1").To4(),
+			IpDestination: net.ParseIP("10.1.1.2").To4(),
+		},
+	}
+	enricher.Enrich(event)
+	if event.Enrichment != nil {
+		t.Fatalf("unexpected enrichment %v", event.Enrichment)
+	}
+}
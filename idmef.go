@@ -0,0 +1,358 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The IDMEF XML namespace.
+const IDMEF_NAMESPACE = "http://iana.org/idmef"
+
+type idmefMessage struct {
+	XMLName xml.Name   `xml:"IDMEF-Message"`
+	Xmlns   string     `xml:"xmlns,attr"`
+	Version string     `xml:"version,attr"`
+	Alert   idmefAlert `xml:"Alert"`
+}
+
+type idmefAlert struct {
+	MessageId      string                `xml:"messageid,attr"`
+	Analyzer       idmefAnalyzer         `xml:"Analyzer"`
+	CreateTime     idmefTime             `xml:"CreateTime"`
+	DetectTime     idmefTime             `xml:"DetectTime"`
+	Source         idmefEndpoint         `xml:"Source"`
+	Target         idmefEndpoint         `xml:"Target"`
+	Classification idmefClassification   `xml:"Classification"`
+	Assessment     idmefAssessment       `xml:"Assessment"`
+	AdditionalData []idmefAdditionalData `xml:"AdditionalData"`
+}
+
+type idmefAnalyzer struct {
+	AnalyzerId   string     `xml:"analyzerid,attr"`
+	Name         string     `xml:"name,attr,omitempty"`
+	Manufacturer string     `xml:"manufacturer,attr,omitempty"`
+	Model        string     `xml:"model,attr,omitempty"`
+	Class        string     `xml:"class,attr,omitempty"`
+	Node         *idmefNode `xml:"Node,omitempty"`
+}
+
+type idmefTime struct {
+	NtpStamp string `xml:"ntpstamp,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type idmefEndpoint struct {
+	Node    idmefNode     `xml:"Node"`
+	Service *idmefService `xml:"Service,omitempty"`
+}
+
+type idmefNode struct {
+	Name    string        `xml:"name,omitempty"`
+	Address *idmefAddress `xml:"Address,omitempty"`
+}
+
+type idmefAddress struct {
+	Category string `xml:"category,attr"`
+	Address  string `xml:"address"`
+}
+
+type idmefService struct {
+	IanaProtocolNumber uint8  `xml:"iana_protocol_number,attr"`
+	IanaProtocolName   string `xml:"iana_protocol_name,attr"`
+	Port               uint16 `xml:"port"`
+}
+
+type idmefClassification struct {
+	Text      string           `xml:"text,attr"`
+	Reference []idmefReference `xml:"Reference"`
+}
+
+type idmefReference struct {
+	Origin  string `xml:"origin,attr"`
+	Meaning string `xml:"meaning,attr,omitempty"`
+	Name    string `xml:"name"`
+	Url     string `xml:"url"`
+}
+
+type idmefAssessment struct {
+	Impact idmefImpact  `xml:"Impact"`
+	Action *idmefAction `xml:"Action,omitempty"`
+}
+
+type idmefImpact struct {
+	Severity   string `xml:"severity,attr"`
+	Completion string `xml:"completion,attr,omitempty"`
+	Type       string `xml:"type,attr"`
+}
+
+type idmefAction struct {
+	Category string `xml:"category,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type idmefAdditionalData struct {
+	Type       string `xml:"type,attr"`
+	Meaning    string `xml:"meaning,attr"`
+	String     string `xml:"string,omitempty"`
+	Integer    string `xml:"integer,omitempty"`
+	ByteString string `xml:"byte-string,omitempty"`
+}
+
+// IDMEFEncoder writes aggregated events as IDMEF (RFC 4765) Alert
+// messages.
+//
+// IDMEFEncoders should be created with NewIDMEFEncoder().
+type IDMEFEncoder struct {
+	// AnalyzerName is the name of the analyzer.  Defaults to "Snort".
+	AnalyzerName string
+
+	// Hostname, if set, is used as the analyzer node name.
+	Hostname string
+
+	// Signatures, if set, are used for the classification text and
+	// references.
+	Signatures *SignatureMap
+
+	writer io.Writer
+}
+
+// NewIDMEFEncoder creates a new IDMEFEncoder writing to writer.
+func NewIDMEFEncoder(writer io.Writer) *IDMEFEncoder {
+	return &IDMEFEncoder{
+		AnalyzerName: "Snort",
+		writer:       writer,
+	}
+}
+
+// idmefTimestamp formats a time as an IDMEF DateTime and NTP
+// timestamp.
+func idmefTimestamp(timestamp time.Time) idmefTime {
+	// Seconds between the NTP epoch of 1900 and the Unix epoch.
+	const ntpOffset = 2208988800
+	seconds := uint32(timestamp.Unix() + ntpOffset)
+	fraction := uint32((uint64(timestamp.Nanosecond()) << 32) / 1000000000)
+	return idmefTime{
+		NtpStamp: fmt.Sprintf("0x%08x.0x%08x", seconds, fraction),
+		Value:    timestamp.UTC().Format("2006-01-02T15:04:05.000000Z"),
+	}
+}
+
+func idmefEndpointFor(event *EventRecord, source bool) idmefEndpoint {
+	address := event.IpDestination
+	port := event.DportIcode
+	if source {
+		address = event.IpSource
+		port = event.SportItype
+	}
+
+	endpoint := idmefEndpoint{}
+	category := "ipv6-addr"
+	if address.To4() != nil {
+		category = "ipv4-addr"
+	}
+	endpoint.Node.Address = &idmefAddress{category, address.String()}
+	if event.HasPorts() {
+		endpoint.Service = &idmefService{
+			IanaProtocolNumber: event.Protocol,
+			IanaProtocolName:   strings.ToLower(event.ProtocolName()),
+			Port:               port,
+		}
+	}
+	return endpoint
+}
+
+// idmefReferenceFor converts a rule reference to an IDMEF reference.
+func idmefReferenceFor(reference string) idmefReference {
+	system, id := ParseReference(reference)
+	ref := idmefReference{Name: id, Url: ReferenceURL(system, id)}
+	switch strings.ToLower(system) {
+	case "cve":
+		ref.Origin = "cve"
+	case "bugtraq":
+		ref.Origin = "bugtraqid"
+	case "osvdb":
+		ref.Origin = "osvdb"
+	case "url":
+		ref.Origin = "unknown"
+	default:
+		ref.Origin = "vendor-specific"
+		ref.Meaning = system
+	}
+	if ref.Url == "" {
+		ref.Url = id
+	}
+	return ref
+}
+
+// idmefSeverity maps an event priority to an IDMEF impact severity.
+func idmefSeverity(priority uint32) string {
+	switch priority {
+	case 1:
+		return "high"
+	case 2:
+		return "medium"
+	case 3:
+		return "low"
+	}
+	return "info"
+}
+
+// Names of extra data types for IDMEF AdditionalData meanings.
+var extraDataMeanings = map[uint32]string{
+	EXTRA_DATA_TYPE_XFF_IPV4:        "x-forwarded-for",
+	EXTRA_DATA_TYPE_XFF_IPV6:        "x-forwarded-for",
+	EXTRA_DATA_TYPE_REVIEWED_BY:     "reviewed-by",
+	EXTRA_DATA_TYPE_GZIP_DATA:       "gzip-data",
+	EXTRA_DATA_TYPE_SMTP_FILENAME:   "smtp-filename",
+	EXTRA_DATA_TYPE_SMTP_MAIL_FROM:  "smtp-mail-from",
+	EXTRA_DATA_TYPE_SMTP_RCPT_TO:    "smtp-rcpt-to",
+	EXTRA_DATA_TYPE_SMTP_EMAIL_HDRS: "smtp-email-headers",
+	EXTRA_DATA_TYPE_HTTP_URI:        "http-uri",
+	EXTRA_DATA_TYPE_HTTP_HOSTNAME:   "http-hostname",
+	EXTRA_DATA_TYPE_IPV6_SRC:        "ipv6-source",
+	EXTRA_DATA_TYPE_IPV6_DST:        "ipv6-destination",
+	EXTRA_DATA_TYPE_JS_NORMALIZED:   "normalized-javascript",
+}
+
+// idmefExtraData converts an extra data record to IDMEF AdditionalData.
+func idmefExtraData(extra *ExtraDataRecord) idmefAdditionalData {
+	meaning, ok := extraDataMeanings[extra.Type]
+	if !ok {
+		meaning = fmt.Sprintf("extra-data-%d", extra.Type)
+	}
+	if ip := extra.IP(); ip != nil {
+		return idmefAdditionalData{Type: "string", Meaning: meaning,
+			String: ip.String()}
+	}
+	switch extra.Type {
+	case EXTRA_DATA_TYPE_SMTP_FILENAME,
+		EXTRA_DATA_TYPE_SMTP_MAIL_FROM,
+		EXTRA_DATA_TYPE_SMTP_RCPT_TO,
+		EXTRA_DATA_TYPE_HTTP_URI,
+		EXTRA_DATA_TYPE_HTTP_HOSTNAME,
+		EXTRA_DATA_TYPE_REVIEWED_BY:
+		return idmefAdditionalData{Type: "string", Meaning: meaning,
+			String: string(extra.Data)}
+	}
+	return idmefAdditionalData{Type: "byte-string", Meaning: meaning,
+		ByteString: base64.StdEncoding.EncodeToString(extra.Data)}
+}
+
+// Encode writes an aggregated event as an IDMEF-Message document,
+// followed by a newline.
+func (e *IDMEFEncoder) Encode(event *AggregatedEvent) error {
+	record := event.Event
+	timestamp := time.Unix(int64(record.EventSecond),
+		int64(record.EventMicrosecond)*1000)
+
+	alert := idmefAlert{
+		MessageId: fmt.Sprintf("%d-%d-%d", record.SensorId,
+			record.EventSecond, record.EventId),
+		Analyzer: idmefAnalyzer{
+			AnalyzerId: strconv.Itoa(int(record.SensorId)),
+			Name:       e.AnalyzerName,
+			Model:      e.AnalyzerName,
+			Class:      "NIDS",
+		},
+		CreateTime: idmefTimestamp(time.Now()),
+		DetectTime: idmefTimestamp(timestamp),
+		Source:     idmefEndpointFor(record, true),
+		Target:     idmefEndpointFor(record, false),
+		Classification: idmefClassification{
+			Text:      eventName(record, e.Signatures),
+			Reference: []idmefReference{},
+		},
+		Assessment: idmefAssessment{
+			Impact: idmefImpact{
+				Severity: idmefSeverity(record.Priority),
+				Type:     "other",
+			},
+		},
+	}
+	if e.Hostname != "" {
+		alert.Analyzer.Node = &idmefNode{Name: e.Hostname}
+	}
+
+	if e.Signatures != nil {
+		if signature := e.Signatures.Lookup(record.GeneratorId,
+			record.SignatureId); signature != nil {
+			for _, reference := range signature.References {
+				alert.Classification.Reference = append(
+					alert.Classification.Reference,
+					idmefReferenceFor(reference))
+			}
+		}
+	}
+
+	switch record.BlockedStatus() {
+	case BLOCKED_STATUS_BLOCKED:
+		alert.Assessment.Impact.Completion = "failed"
+		alert.Assessment.Action = &idmefAction{"block-installed",
+			"blocked"}
+	case BLOCKED_STATUS_WOULD_BLOCK:
+		alert.Assessment.Action = &idmefAction{"other", "would have blocked"}
+	}
+
+	alert.AdditionalData = append(alert.AdditionalData,
+		idmefAdditionalData{Type: "string", Meaning: "signature",
+			String: fmt.Sprintf("%d:%d:%d", record.GeneratorId,
+				record.SignatureId, record.SignatureRevision)},
+		idmefAdditionalData{Type: "integer", Meaning: "priority",
+			Integer: strconv.Itoa(int(record.Priority))})
+	for _, packet := range event.Packets {
+		alert.AdditionalData = append(alert.AdditionalData,
+			idmefAdditionalData{Type: "byte-string", Meaning: "packet",
+				ByteString: base64.StdEncoding.EncodeToString(packet.Data)})
+	}
+	for _, extra := range event.ExtraData {
+		alert.AdditionalData = append(alert.AdditionalData,
+			idmefExtraData(extra))
+	}
+
+	message := idmefMessage{
+		Xmlns:   IDMEF_NAMESPACE,
+		Version: "1.0",
+		Alert:   alert,
+	}
+
+	if _, err := io.WriteString(e.writer, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(e.writer)
+	if err := encoder.Encode(&message); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.writer, "\n")
+	return err
+}
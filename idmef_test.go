@@ -0,0 +1,95 @@
+package unified2
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestIDMEFEncoder(t *testing.T) {
+	events := readAggregatedEvents(t, "test/multi-record-event.log")
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+
+	var buf bytes.Buffer
+	encoder := NewIDMEFEncoder(&buf)
+	encoder.Signatures = NewSignatureMap()
+	encoder.Signatures.Add(&Signature{GeneratorId: 120, SignatureId: 3,
+		Msg: "Test <Message>",
+		References: []string{"cve,2003-0001", "url,www.example.com",
+			"nessus,1234"}})
+	if err := encoder.Encode(events[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(buf.String(), xml.Header+
+		`<IDMEF-Message xmlns="http://iana.org/idmef" version="1.0">`) {
+		t.Fatalf("unexpected output: %s", buf.String()[0:100])
+	}
+
+	var message idmefMessage
+	if err := xml.Unmarshal(buf.Bytes(), &message); err != nil {
+		t.Fatal(err)
+	}
+	alert := message.Alert
+
+	if alert.MessageId != "0-964798804-89" {
+		t.Fatalf("unexpected message id: %s", alert.MessageId)
+	}
+	if alert.DetectTime.Value != "2000-07-28T15:40:04.267362Z" {
+		t.Fatalf("unexpected detect time: %s", alert.DetectTime.Value)
+	}
+	if alert.Source.Node.Address.Address != "207.25.71.28" ||
+		alert.Source.Node.Address.Category != "ipv4-addr" ||
+		alert.Source.Service.Port != 80 ||
+		alert.Target.Node.Address.Address != "10.20.11.123" ||
+		alert.Target.Service.Port != 2651 ||
+		alert.Target.Service.IanaProtocolName != "tcp" {
+		t.Fatalf("unexpected source or target: %+v %+v", alert.Source,
+			alert.Target)
+	}
+	if alert.Classification.Text != "Test <Message>" ||
+		len(alert.Classification.Reference) != 3 {
+		t.Fatalf("unexpected classification: %+v", alert.Classification)
+	}
+	expected := []idmefReference{
+		{"cve", "", "2003-0001",
+			"http://cve.mitre.org/cgi-bin/cvename.cgi?name=2003-0001"},
+		{"unknown", "", "www.example.com", "http://www.example.com"},
+		{"vendor-specific", "nessus", "1234",
+			"http://cgi.nessus.org/plugins/dump.php3?id=1234"},
+	}
+	for i, reference := range alert.Classification.Reference {
+		if reference != expected[i] {
+			t.Fatalf("unexpected reference: %+v", reference)
+		}
+	}
+	if alert.Assessment.Impact.Severity != "low" {
+		t.Fatalf("unexpected severity: %s", alert.Assessment.Impact.Severity)
+	}
+
+	packets := 0
+	for _, data := range alert.AdditionalData {
+		switch data.Meaning {
+		case "packet":
+			payload, err := base64.StdEncoding.DecodeString(data.ByteString)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(payload, events[0].Packets[packets].Data) {
+				t.Fatal("packet data does not match")
+			}
+			packets++
+		case "normalized-javascript":
+			if data.Type != "byte-string" {
+				t.Fatalf("unexpected type %s", data.Type)
+			}
+		}
+	}
+	if packets != 15 {
+		t.Fatalf("expected 15 packets, got %d", packets)
+	}
+}
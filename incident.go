@@ -0,0 +1,279 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// The event fields incidents can be keyed by.
+const (
+	// The generator and signature id.
+	INCIDENT_KEY_SIGNATURE = 1 << iota
+
+	INCIDENT_KEY_SOURCE
+	INCIDENT_KEY_DESTINATION
+	INCIDENT_KEY_DESTINATION_PORT
+
+	INCIDENT_KEY_ALL = INCIDENT_KEY_SIGNATURE | INCIDENT_KEY_SOURCE |
+		INCIDENT_KEY_DESTINATION | INCIDENT_KEY_DESTINATION_PORT
+)
+
+var incidentKeyNames = map[string]int{
+	"sid":   INCIDENT_KEY_SIGNATURE,
+	"src":   INCIDENT_KEY_SOURCE,
+	"dst":   INCIDENT_KEY_DESTINATION,
+	"dport": INCIDENT_KEY_DESTINATION_PORT,
+}
+
+// ParseIncidentKey parses a comma separated list of the key fields
+// sid, src, dst and dport.
+func ParseIncidentKey(value string) (int, error) {
+	key := 0
+	for _, name := range strings.Split(value, ",") {
+		field, ok := incidentKeyNames[strings.TrimSpace(name)]
+		if !ok {
+			return 0, fmt.Errorf("unknown incident key field: %s", name)
+		}
+		key |= field
+	}
+	return key, nil
+}
+
+// Incident summarizes the events sharing a key that were seen within a
+// window of each other.  Fields that are not part of the key are left
+// unset.
+type Incident struct {
+	GeneratorId     uint32    `json:"gid,omitempty"`
+	SignatureId     uint32    `json:"sid,omitempty"`
+	Source          net.IP    `json:"src_ip,omitempty"`
+	Destination     net.IP    `json:"dest_ip,omitempty"`
+	DestinationPort *uint16   `json:"dest_port,omitempty"`
+	Count           int       `json:"count"`
+	FirstSeen       time.Time `json:"first_seen"`
+	LastSeen        time.Time `json:"last_seen"`
+
+	// Event is the first event of the incident.
+	Event *AggregatedEvent `json:"-"`
+
+	// Packet is the first packet of the incident's events, or nil if
+	// none had a packet.  It is encoded in JSON as a PacketDocument.
+	Packet *PacketRecord `json:"-"`
+}
+
+// MarshalJSON encodes the incident along with its packet.
+func (i Incident) MarshalJSON() ([]byte, error) {
+	type incident Incident
+	document := struct {
+		incident
+		Packet *PacketDocument `json:"packet,omitempty"`
+	}{incident: incident(i)}
+	if i.Packet != nil {
+		document.Packet = &PacketDocument{
+			Timestamp: time.Unix(int64(i.Packet.PacketSecond),
+				int64(i.Packet.PacketMicrosecond)*1000).UTC(),
+			LinkType: i.Packet.LinkType,
+			Length:   i.Packet.Length,
+			Data:     i.Packet.Data,
+		}
+	}
+	return json.Marshal(document)
+}
+
+type incidentKey struct {
+	generatorId     uint32
+	signatureId     uint32
+	source          string
+	destination     string
+	destinationPort uint16
+}
+
+// IncidentAggregator collapses events into Incidents.  An incident is
+// open while events with its key keep arriving within Window of the
+// last one, and is returned once a later event, or a call to Expire,
+// shows the window has passed.  Times are those of the events, so the
+// events must be added in time order.
+//
+// IncidentAggregators should be created with NewIncidentAggregator().
+type IncidentAggregator struct {
+	// Key is the set of INCIDENT_KEY_ fields events are grouped by.
+	// Defaults to INCIDENT_KEY_ALL.
+	Key int
+
+	// Window is how long an incident stays open after its last
+	// event.  Defaults to 1 minute.
+	Window time.Duration
+
+	// MaxDuration, if set, closes an incident once it has been open
+	// this long, even if its events continue.  A new incident is
+	// started by the next event.
+	MaxDuration time.Duration
+
+	incidents map[incidentKey]*list.Element
+
+	// Open incidents ordered by the time of their last event.
+	order *list.List
+}
+
+type openIncident struct {
+	key      incidentKey
+	incident *Incident
+}
+
+// NewIncidentAggregator creates an IncidentAggregator with the default
+// key and window.
+func NewIncidentAggregator() *IncidentAggregator {
+	return &IncidentAggregator{
+		Key:       INCIDENT_KEY_ALL,
+		Window:    time.Minute,
+		incidents: map[incidentKey]*list.Element{},
+		order:     list.New(),
+	}
+}
+
+func (a *IncidentAggregator) key(event *EventRecord) incidentKey {
+	var key incidentKey
+	if a.Key&INCIDENT_KEY_SIGNATURE != 0 {
+		key.generatorId = event.GeneratorId
+		key.signatureId = event.SignatureId
+	}
+	if a.Key&INCIDENT_KEY_SOURCE != 0 {
+		key.source = string(event.IpSource.To16())
+	}
+	if a.Key&INCIDENT_KEY_DESTINATION != 0 {
+		key.destination = string(event.IpDestination.To16())
+	}
+	if a.Key&INCIDENT_KEY_DESTINATION_PORT != 0 {
+		key.destinationPort = event.DestinationPort()
+	}
+	return key
+}
+
+func (a *IncidentAggregator) newIncident(event *AggregatedEvent,
+	timestamp time.Time) *Incident {
+	record := event.Event
+	incident := &Incident{
+		FirstSeen: timestamp,
+		Event:     event,
+	}
+	if a.Key&INCIDENT_KEY_SIGNATURE != 0 {
+		incident.GeneratorId = record.GeneratorId
+		incident.SignatureId = record.SignatureId
+	}
+	if a.Key&INCIDENT_KEY_SOURCE != 0 {
+		incident.Source = record.IpSource
+	}
+	if a.Key&INCIDENT_KEY_DESTINATION != 0 {
+		incident.Destination = record.IpDestination
+	}
+	if a.Key&INCIDENT_KEY_DESTINATION_PORT != 0 {
+		port := record.DestinationPort()
+		incident.DestinationPort = &port
+	}
+	return incident
+}
+
+// Add adds an event, returning the incidents closed by the passing of
+// time up to it, oldest first.
+func (a *IncidentAggregator) Add(event *AggregatedEvent) []*Incident {
+	record := event.Event
+	timestamp := time.Unix(int64(record.EventSecond),
+		int64(record.EventMicrosecond)*1000).UTC()
+
+	closed := a.Expire(timestamp)
+
+	key := a.key(record)
+	element, ok := a.incidents[key]
+	if ok && a.MaxDuration > 0 {
+		incident := element.Value.(*openIncident).incident
+		if timestamp.Sub(incident.FirstSeen) >= a.MaxDuration {
+			closed = append(closed, a.close(element))
+			ok = false
+		}
+	}
+	if !ok {
+		element = a.order.PushBack(&openIncident{key,
+			a.newIncident(event, timestamp)})
+		a.incidents[key] = element
+	} else {
+		a.order.MoveToBack(element)
+	}
+
+	incident := element.Value.(*openIncident).incident
+	incident.Count++
+	incident.LastSeen = timestamp
+	if incident.Packet == nil && len(event.Packets) > 0 {
+		incident.Packet = event.Packets[0]
+	}
+
+	return closed
+}
+
+// Expire returns the incidents whose window has passed by now, oldest
+// first.  It can be called when no events have been seen for a while,
+// with a time comparable to the event times.
+func (a *IncidentAggregator) Expire(now time.Time) []*Incident {
+	var closed []*Incident
+	for {
+		element := a.order.Front()
+		if element == nil {
+			break
+		}
+		incident := element.Value.(*openIncident).incident
+		if now.Sub(incident.LastSeen) < a.Window {
+			break
+		}
+		closed = append(closed, a.close(element))
+	}
+	return closed
+}
+
+// Flush closes and returns all open incidents, oldest first.
+func (a *IncidentAggregator) Flush() []*Incident {
+	var closed []*Incident
+	for a.order.Len() > 0 {
+		closed = append(closed, a.close(a.order.Front()))
+	}
+	return closed
+}
+
+// Len returns the number of open incidents.
+func (a *IncidentAggregator) Len() int {
+	return a.order.Len()
+}
+
+func (a *IncidentAggregator) close(element *list.Element) *Incident {
+	open := element.Value.(*openIncident)
+	a.order.Remove(element)
+	delete(a.incidents, open.key)
+	return open.incident
+}
@@ -0,0 +1,166 @@
+package unified2
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func incidentEvent(sid uint32, second uint32, src string, dst string,
+	dport uint16) *AggregatedEvent {
+	return &AggregatedEvent{
+		Event: &EventRecord{
+			GeneratorId:   1,
+			SignatureId:   sid,
+			EventSecond:   second,
+			IpSource:      net.ParseIP(src).To4(),
+			IpDestination: net.ParseIP(dst).To4(),
+			Protocol:      IPPROTO_TCP,
+			SportItype:    1024,
+			DportIcode:    dport,
+		},
+	}
+}
+
+func TestIncidentAggregator(t *testing.T) {
+	aggregator := NewIncidentAggregator()
+	aggregator.Window = 10 * time.Second
+
+	var closed []*Incident
+	add := func(event *AggregatedEvent) {
+		closed = append(closed, aggregator.Add(event)...)
+	}
+
+	// A flood of one signature between two hosts, with a gap of less
+	// than the window.
+	first := incidentEvent(1, 100, "10.0.0.1", "10.0.0.2", 80)
+	add(first)
+	packet := &PacketRecord{EventId: 2, PacketSecond: 101,
+		LinkType: LINKTYPE_ETHERNET, Length: 4, Data: []byte{1, 2, 3, 4}}
+	second := incidentEvent(1, 101, "10.0.0.1", "10.0.0.2", 80)
+	second.Packets = []*PacketRecord{packet}
+	add(second)
+	add(incidentEvent(1, 109, "10.0.0.1", "10.0.0.2", 80))
+
+	// Differing in each key field.
+	add(incidentEvent(2, 110, "10.0.0.1", "10.0.0.2", 80))
+	add(incidentEvent(1, 110, "10.0.0.3", "10.0.0.2", 80))
+	add(incidentEvent(1, 110, "10.0.0.1", "10.0.0.4", 80))
+	add(incidentEvent(1, 110, "10.0.0.1", "10.0.0.2", 443))
+
+	if len(closed) != 0 {
+		t.Fatalf("expected no closed incidents, got %d", len(closed))
+	}
+	if aggregator.Len() != 5 {
+		t.Fatalf("expected 5 open incidents, got %d", aggregator.Len())
+	}
+
+	// The first incident closes once its window has passed.
+	add(incidentEvent(3, 119, "10.0.0.1", "10.0.0.2", 80))
+	if len(closed) != 1 {
+		t.Fatalf("expected 1 closed incident, got %d", len(closed))
+	}
+	incident := closed[0]
+	if incident.Count != 3 {
+		t.Fatalf("expected count 3, got %d", incident.Count)
+	}
+	if incident.FirstSeen.Unix() != 100 || incident.LastSeen.Unix() != 109 {
+		t.Fatalf("unexpected first and last seen: %s, %s",
+			incident.FirstSeen, incident.LastSeen)
+	}
+	if incident.Event != first || incident.Packet != packet {
+		t.Fatal("unexpected representative event or packet")
+	}
+	if incident.SignatureId != 1 || !incident.Source.Equal(net.ParseIP("10.0.0.1")) ||
+		*incident.DestinationPort != 80 {
+		t.Fatalf("unexpected incident key: %+v", incident)
+	}
+
+	// The summary carries the packet.
+	encoded, err := json.Marshal(incident)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summary struct {
+		Count  int             `json:"count"`
+		Packet *PacketDocument `json:"packet"`
+	}
+	if err := json.Unmarshal(encoded, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Count != 3 || summary.Packet == nil ||
+		summary.Packet.LinkType != LINKTYPE_ETHERNET ||
+		summary.Packet.Timestamp.Unix() != 101 ||
+		string(summary.Packet.Data) != string(packet.Data) {
+		t.Fatalf("unexpected summary: %s", encoded)
+	}
+
+	// The rest close in order of their last event.
+	closed = aggregator.Expire(time.Unix(125, 0))
+	if len(closed) != 4 {
+		t.Fatalf("expected 4 closed incidents, got %d", len(closed))
+	}
+	if closed[0].SignatureId != 2 {
+		t.Fatalf("expected signature 2 first, got %d", closed[0].SignatureId)
+	}
+
+	closed = aggregator.Flush()
+	if len(closed) != 1 || closed[0].SignatureId != 3 {
+		t.Fatalf("unexpected flushed incidents: %v", closed)
+	}
+	if aggregator.Len() != 0 {
+		t.Fatalf("expected no open incidents, got %d", aggregator.Len())
+	}
+}
+
+func TestIncidentKey(t *testing.T) {
+	key, err := ParseIncidentKey("sid, dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != INCIDENT_KEY_SIGNATURE|INCIDENT_KEY_DESTINATION {
+		t.Fatalf("unexpected key %d", key)
+	}
+	if _, err := ParseIncidentKey("sid,bad"); err == nil {
+		t.Fatal("expected error for unknown field")
+	}
+
+	aggregator := NewIncidentAggregator()
+	aggregator.Key = key
+	aggregator.Add(incidentEvent(1, 100, "10.0.0.1", "10.0.0.2", 80))
+	aggregator.Add(incidentEvent(1, 101, "10.0.0.3", "10.0.0.2", 443))
+	aggregator.Add(incidentEvent(1, 102, "10.0.0.3", "10.0.0.9", 443))
+
+	closed := aggregator.Flush()
+	if len(closed) != 2 || closed[0].Count != 2 {
+		t.Fatalf("unexpected incidents: %+v", closed)
+	}
+	if closed[0].Source != nil || closed[0].DestinationPort != nil {
+		t.Fatalf("fields outside the key set: %+v", closed[0])
+	}
+}
+
+func TestIncidentMaxDuration(t *testing.T) {
+	aggregator := NewIncidentAggregator()
+	aggregator.MaxDuration = 30 * time.Second
+
+	var closed []*Incident
+	for second := uint32(0); second < 100; second += 5 {
+		closed = append(closed, aggregator.Add(
+			incidentEvent(1, second, "10.0.0.1", "10.0.0.2", 80))...)
+	}
+	closed = append(closed, aggregator.Flush()...)
+
+	// Events every 5 seconds for 100 seconds, split into 30 second
+	// incidents.
+	if len(closed) != 4 {
+		t.Fatalf("expected 4 incidents, got %d", len(closed))
+	}
+	for i, count := range []int{6, 6, 6, 2} {
+		if closed[i].Count != count {
+			t.Fatalf("incident %d: expected count %d, got %d", i, count,
+				closed[i].Count)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// LEEFFormatter formats events in IBM QRadar Log Event Extended
+// Format version 2.0.
+//
+// LEEFFormatters should be created with NewLEEFFormatter().
+type LEEFFormatter struct {
+	Vendor  string
+	Product string
+	Version string
+
+	// Delimiter separates attributes.  Defaults to a tab.
+	Delimiter byte
+
+	// Signatures, if set, are used to name events and categorize
+	// them by classtype.
+	Signatures *SignatureMap
+}
+
+// NewLEEFFormatter creates a new LEEFFormatter for Snort events.
+func NewLEEFFormatter() *LEEFFormatter {
+	return &LEEFFormatter{
+		Vendor:    "Snort",
+		Product:   "Snort",
+		Version:   "2",
+		Delimiter: '\t',
+	}
+}
+
+var leefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`,
+	"\r", " ", "\n", " ")
+
+// Format formats an event and its extra data records, which may be
+// nil, as a LEEF line without a trailing newline.
+func (f *LEEFFormatter) Format(event *EventRecord, extra []*ExtraDataRecord) string {
+	delimiter := f.Delimiter
+	if delimiter == 0 {
+		delimiter = '\t'
+	}
+
+	fields := []eventField{
+		{"devTime", strconv.FormatInt(int64(event.EventSecond)*1000+
+			int64(event.EventMicrosecond)/1000, 10)},
+		{"devTimeFormat", "epoch"},
+		{"src", event.IpSource.String()},
+		{"dst", event.IpDestination.String()},
+	}
+	if event.HasPorts() {
+		fields = append(fields,
+			eventField{"srcPort", strconv.Itoa(int(event.SportItype))},
+			eventField{"dstPort", strconv.Itoa(int(event.DportIcode))})
+	} else if event.IsICMP() {
+		fields = append(fields,
+			eventField{"icmpType", strconv.Itoa(int(event.SportItype))},
+			eventField{"icmpCode", strconv.Itoa(int(event.DportIcode))})
+	}
+	fields = append(fields,
+		eventField{"proto", event.ProtocolName()},
+		eventField{"sev", strconv.Itoa(CEFSeverity(event.Priority))},
+		eventField{"name", eventName(event, f.Signatures)})
+	if classtype := eventClasstype(event, f.Signatures); classtype != "" {
+		fields = append(fields, eventField{"cat", classtype})
+	}
+	fields = append(fields,
+		eventField{"action", eventAction(event)},
+		eventField{"sensorId", strconv.Itoa(int(event.SensorId))},
+		eventField{"vlan", strconv.Itoa(int(event.VlanId))},
+		eventField{"priority", strconv.Itoa(int(event.Priority))})
+	if event.AppId != "" {
+		fields = append(fields, eventField{"appId", event.AppId})
+	}
+	if xff := XffAddress(extra); xff != nil {
+		fields = append(fields, eventField{"xForwardedFor", xff.String()})
+	}
+
+	escaper := strings.NewReplacer(`\`, `\\`,
+		string(delimiter), `\`+string(delimiter),
+		"\r", " ", "\n", " ")
+	attributes := make([]string, len(fields))
+	for i, field := range fields {
+		attributes[i] = field.key + "=" + escaper.Replace(field.value)
+	}
+
+	return fmt.Sprintf("LEEF:2.0|%s|%s|%s|%s|%s|%s",
+		leefHeaderEscaper.Replace(f.Vendor),
+		leefHeaderEscaper.Replace(f.Product),
+		leefHeaderEscaper.Replace(f.Version),
+		fmt.Sprintf("%d:%d:%d", event.GeneratorId, event.SignatureId,
+			event.SignatureRevision),
+		fmt.Sprintf("x%02X", delimiter),
+		strings.Join(attributes, string(delimiter)))
+}
+
+// Write writes a formatted event to writer followed by a newline.
+// A SyslogWriter can be used to send the event over syslog.
+func (f *LEEFFormatter) Write(writer io.Writer, event *EventRecord,
+	extra []*ExtraDataRecord) error {
+	_, err := io.WriteString(writer, f.Format(event, extra)+"\n")
+	return err
+}
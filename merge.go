@@ -0,0 +1,214 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"container/heap"
+	"io"
+)
+
+// isEventType returns true if the record type is one of the event
+// record types.
+func isEventType(recordType uint32) bool {
+	switch recordType {
+	case UNIFIED2_EVENT,
+		UNIFIED2_EVENT_IP6,
+		UNIFIED2_EVENT_V2,
+		UNIFIED2_EVENT_V2_IP6,
+		UNIFIED2_EVENT_APPID,
+		UNIFIED2_EVENT_APPID_IP6,
+		UNIFIED2_EVENT_MPLS,
+		UNIFIED2_EVENT_MPLS_IP6,
+		UNIFIED2_EVENT_V3:
+		return true
+	}
+	return false
+}
+
+// isPacketType returns true if records of the type have the layout of
+// a packet record.
+func isPacketType(recordType uint32) bool {
+	return recordType == UNIFIED2_PACKET || recordType == UNIFIED2_BUFFER
+}
+
+// recordTime returns the event time of a raw record in microseconds.
+// Packet, buffer and extra data records use the second of their
+// event.
+func recordTime(record *RawRecord) (uint64, error) {
+	switch {
+	case isEventType(record.Type):
+		event, err := DecodeEventRecord(record.Type, record.Data)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(event.EventSecond)*1000000 +
+			uint64(event.EventMicrosecond), nil
+	case isPacketType(record.Type):
+		packet, err := DecodePacketRecord(record.Data)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(packet.EventSecond) * 1000000, nil
+	case record.Type == UNIFIED2_EXTRA_DATA:
+		extra, err := DecodeExtraDataRecord(record.Data)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(extra.EventSecond) * 1000000, nil
+	}
+	return 0, nil
+}
+
+// EventGroup is an event record followed by the packet, extra data
+// and other records that came after it and before the next event.
+type EventGroup struct {
+	// Time is the time of the event in microseconds since the epoch.
+	Time uint64
+
+	Records []*RawRecord
+}
+
+// EventGroupReader reads raw records from a unified2 file, grouping
+// each event with the records that follow it.
+type EventGroupReader struct {
+	file io.ReadWriteSeeker
+	next *RawRecord
+}
+
+// NewEventGroupReader creates a new EventGroupReader reading from file.
+func NewEventGroupReader(file io.ReadWriteSeeker) *EventGroupReader {
+	return &EventGroupReader{file: file}
+}
+
+// Next returns the next EventGroup.  Records found before the first
+// event of the file are returned as a group of their own.
+//
+// io.EOF is returned at the end of the file.  If the file ends with
+// an incomplete record io.ErrUnexpectedEOF is returned once the
+// complete records before it have been returned.
+func (r *EventGroupReader) Next() (*EventGroup, error) {
+	group := &EventGroup{}
+
+	if r.next == nil {
+		record, err := ReadRawRecord(r.file)
+		if err != nil {
+			return nil, err
+		}
+		r.next = record
+	}
+
+	timestamp, err := recordTime(r.next)
+	if err != nil {
+		return nil, err
+	}
+	group.Time = timestamp
+	group.Records = append(group.Records, r.next)
+	r.next = nil
+
+	for {
+		record, err := ReadRawRecord(r.file)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return group, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if isEventType(record.Type) {
+			r.next = record
+			return group, nil
+		}
+		group.Records = append(group.Records, record)
+	}
+}
+
+type mergeInput struct {
+	index  int
+	reader *EventGroupReader
+	group  *EventGroup
+}
+
+type mergeHeap []*mergeInput
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	if h[i].group.Time != h[j].group.Time {
+		return h[i].group.Time < h[j].group.Time
+	}
+	return h[i].index < h[j].index
+}
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(*mergeInput)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[0 : len(old)-1]
+	return x
+}
+
+// Merge reads unified2 records from each of the inputs and writes them
+// to output ordered by event time.  Each event is written together
+// with the packet and extra data records that followed it in its
+// input.  Events with the same time are written in input order.
+//
+// Each input is expected to be in time order already, as files written
+// by a single sensor are.  If an input ends with an incomplete record
+// io.ErrUnexpectedEOF is returned.
+func Merge(output io.Writer, inputs ...io.ReadWriteSeeker) error {
+	h := &mergeHeap{}
+
+	next := func(input *mergeInput) error {
+		group, err := input.reader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		input.group = group
+		heap.Push(h, input)
+		return nil
+	}
+
+	for i, file := range inputs {
+		input := &mergeInput{index: i, reader: NewEventGroupReader(file)}
+		if err := next(input); err != nil {
+			return err
+		}
+	}
+
+	for h.Len() > 0 {
+		input := heap.Pop(h).(*mergeInput)
+		for _, record := range input.group.Records {
+			if err := WriteRawRecord(output, record); err != nil {
+				return err
+			}
+		}
+		if err := next(input); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
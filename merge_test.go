@@ -0,0 +1,125 @@
+package unified2
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Load the event and first packet record from the multi record event
+// test file to use as templates.
+func loadTemplateRecords(t *testing.T) (*RawRecord, *RawRecord) {
+	file, err := os.Open("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var event, packet *RawRecord
+	for event == nil || packet == nil {
+		record, err := ReadRawRecord(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isEventType(record.Type) && event == nil {
+			event = record
+		} else if record.Type == UNIFIED2_PACKET && packet == nil {
+			packet = record
+		}
+	}
+	return event, packet
+}
+
+// Write a unified2 file containing an event for each of the given
+// seconds, each followed by a packet.
+func writeTestFile(t *testing.T, filename string, seconds []uint32) {
+	event, packet := loadTemplateRecords(t)
+
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	for i, second := range seconds {
+		binary.BigEndian.PutUint32(event.Data[4:], uint32(i+1))
+		binary.BigEndian.PutUint32(event.Data[8:], second)
+		binary.BigEndian.PutUint32(packet.Data[4:], uint32(i+1))
+		binary.BigEndian.PutUint32(packet.Data[8:], second)
+		if err := WriteRawRecord(file, event); err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteRawRecord(file, packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	writeTestFile(t, tmpdir+"/a.log", []uint32{100, 300, 500})
+	writeTestFile(t, tmpdir+"/b.log", []uint32{200, 400})
+
+	a, err := os.Open(tmpdir + "/a.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := os.Open(tmpdir + "/b.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	output, err := os.Create(tmpdir + "/merged.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Merge(output, a, b); err != nil {
+		t.Fatal(err)
+	}
+	output.Close()
+
+	merged, err := os.Open(tmpdir + "/merged.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer merged.Close()
+
+	expected := []uint32{100, 200, 300, 400, 500}
+	var current *EventRecord
+	events := 0
+	for {
+		record, err := ReadRecord(merged)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		switch record := record.(type) {
+		case *EventRecord:
+			if record.EventSecond != expected[events] {
+				t.Fatalf("expected event second %d, got %d",
+					expected[events], record.EventSecond)
+			}
+			current = record
+			events++
+		case *PacketRecord:
+			if current == nil || record.EventSecond != current.EventSecond ||
+				record.EventId != current.EventId {
+				t.Fatal("packet does not follow its event")
+			}
+		}
+	}
+	if events != len(expected) {
+		t.Fatalf("expected %d events, got %d", len(expected), events)
+	}
+}
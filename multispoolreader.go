@@ -0,0 +1,269 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// SourceRecord is a record read by a MultiSpoolReader, tagged with
+// the source it was read from.
+type SourceRecord struct {
+	// Source is the name the spool was added with.
+	Source string
+
+	// Record is the decoded record, one of *EventRecord, *PacketRecord
+	// or *ExtraDataRecord.
+	Record interface{}
+
+	// Filename and Offset are the position in the source spool
+	// directly after this record.
+	Filename string
+	Offset   int64
+}
+
+// MultiSpoolReader reads from many unified2 spools at once, such as
+// the spool directories of several sensors, merging their records in
+// approximate timestamp order.
+//
+// Each spool is read in the background.  Records from a single spool
+// are always returned in the order they were read, and a spool's
+// packet and extra data records are returned ahead of events from other
+// spools so they stay with their event where possible.
+//
+// A separate position is kept for each spool, see Offset and Commit.
+type MultiSpoolReader struct {
+
+	// PollInterval is how long a spool is left before being checked
+	// for new data after it has been read to the end.  Defaults to 100
+	// milliseconds.
+	PollInterval time.Duration
+
+	sources []*spoolSource
+	started bool
+	stop    chan bool
+	wait    sync.WaitGroup
+}
+
+type spoolSource struct {
+	name   string
+	reader *SpoolRecordReader
+
+	// Protects reader, which is read from in the background.
+	lock sync.Mutex
+
+	records chan spoolItem
+
+	// The next record to be returned from this source.
+	head *spoolItem
+
+	// Position after the last record returned from this source.
+	filename string
+	offset   int64
+}
+
+type spoolItem struct {
+	record *SourceRecord
+	err    error
+}
+
+// NewMultiSpoolReader creates a new MultiSpoolReader with no spools.
+func NewMultiSpoolReader() *MultiSpoolReader {
+	return &MultiSpoolReader{
+		stop: make(chan bool),
+	}
+}
+
+// AddSpool adds a spool of files in directory with the given prefix,
+// tagging its records with source.  The returned SpoolRecordReader may
+// be used to configure the spool, for example to set its Policies or
+// restore its position with SetOffset, until the first call to Next.
+func (m *MultiSpoolReader) AddSpool(source string, directory string,
+	prefix string) *SpoolRecordReader {
+	spool := &spoolSource{
+		name:    source,
+		reader:  NewSpoolRecordReader(directory, prefix),
+		records: make(chan spoolItem, 64),
+	}
+	m.sources = append(m.sources, spool)
+	if m.started {
+		m.start(spool)
+	}
+	return spool.reader
+}
+
+func (m *MultiSpoolReader) start(spool *spoolSource) {
+	interval := m.PollInterval
+	if interval == 0 {
+		interval = 100 * time.Millisecond
+	}
+	m.wait.Add(1)
+	go func() {
+		defer m.wait.Done()
+		for {
+			spool.lock.Lock()
+			record, err := spool.reader.Next()
+			filename, offset := spool.reader.Offset()
+			spool.lock.Unlock()
+
+			var item spoolItem
+			if err == io.EOF || (err == nil && record == nil) {
+				select {
+				case <-m.stop:
+					return
+				case <-time.After(interval):
+				}
+				continue
+			} else if err != nil {
+				item.err = err
+			} else {
+				item.record = &SourceRecord{spool.name, record,
+					filename, offset}
+			}
+
+			select {
+			case <-m.stop:
+				return
+			case spool.records <- item:
+			}
+
+			// Back off after an error so it isn't repeated at
+			// full speed.
+			if err != nil {
+				select {
+				case <-m.stop:
+					return
+				case <-time.After(interval):
+				}
+			}
+		}
+	}()
+}
+
+// Next returns the next record from any of the spools.  io.EOF is
+// returned when no spool currently has a record available.
+//
+// Errors from an individual spool are returned as they occur; reading
+// of that spool will be retried.
+func (m *MultiSpoolReader) Next() (*SourceRecord, error) {
+	if !m.started {
+		m.started = true
+		for _, spool := range m.sources {
+			m.start(spool)
+		}
+	}
+
+	var next *spoolSource
+	var nextTime uint64
+
+	for _, spool := range m.sources {
+		if spool.head == nil {
+			select {
+			case item := <-spool.records:
+				spool.head = &item
+			default:
+				continue
+			}
+		}
+
+		if spool.head.err != nil {
+			err := spool.head.err
+			spool.head = nil
+			return nil, &SourceError{spool.name, err}
+		}
+
+		// Packet and extra data records are returned first.
+		event, ok := spool.head.record.Record.(*EventRecord)
+		if !ok {
+			next = spool
+			break
+		}
+
+		timestamp := uint64(event.EventSecond)*1000000 +
+			uint64(event.EventMicrosecond)
+		if next == nil || timestamp < nextTime {
+			next = spool
+			nextTime = timestamp
+		}
+	}
+
+	if next == nil {
+		return nil, io.EOF
+	}
+
+	record := next.head.record
+	next.head = nil
+	next.filename = record.Filename
+	next.offset = record.Offset
+	return record, nil
+}
+
+// Offset returns the position in the named source's spool following
+// the last record returned from it by Next.
+func (m *MultiSpoolReader) Offset(source string) (string, int64) {
+	for _, spool := range m.sources {
+		if spool.name == source {
+			return spool.filename, spool.offset
+		}
+	}
+	return "", 0
+}
+
+// Commit records the position the consumer has durably processed up
+// to for the named source.  See SpoolRecordReader.Commit.
+func (m *MultiSpoolReader) Commit(source string, filename string,
+	offset int64) {
+	for _, spool := range m.sources {
+		if spool.name == source {
+			spool.lock.Lock()
+			spool.reader.Commit(filename, offset)
+			spool.lock.Unlock()
+		}
+	}
+}
+
+// Close stops reading from all spools and closes their files.
+func (m *MultiSpoolReader) Close() {
+	close(m.stop)
+	m.wait.Wait()
+	for _, spool := range m.sources {
+		spool.reader.Close()
+	}
+}
+
+// SourceError is the error returned by MultiSpoolReader.Next() when
+// reading from one of its spools fails.
+type SourceError struct {
+	Source string
+	Err    error
+}
+
+func (e *SourceError) Error() string {
+	return e.Source + ": " + e.Err.Error()
+}
@@ -0,0 +1,106 @@
+package unified2
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMultiSpoolReader(t *testing.T) {
+
+	test_filename := "test/multi-record-event.log"
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, sensor := range []string{"sensor1", "sensor2"} {
+		os.Mkdir(fmt.Sprintf("%s/%s", tmpdir, sensor), 0755)
+		copyFile(test_filename,
+			fmt.Sprintf("%s/%s/%s.log.1382627900", tmpdir, sensor, sensor))
+	}
+
+	reader := NewMultiSpoolReader()
+	reader.PollInterval = time.Millisecond
+	reader.AddSpool("sensor1", tmpdir+"/sensor1", "sensor1.log")
+	reader.AddSpool("sensor2", tmpdir+"/sensor2", "sensor2.log")
+	defer reader.Close()
+
+	counts := map[string]int{}
+	last := map[string]interface{}{}
+	deadline := time.Now().Add(5 * time.Second)
+	for counts["sensor1"]+counts["sensor2"] < 34 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out: %v", counts)
+		}
+		record, err := reader.Next()
+		if err == io.EOF {
+			time.Sleep(time.Millisecond)
+			continue
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		// A packet must directly follow its event from the same
+		// source.
+		if _, ok := record.Record.(*PacketRecord); ok {
+			if last[record.Source] == nil {
+				t.Fatalf("packet without event from %s", record.Source)
+			}
+		}
+		last[record.Source] = record.Record
+		counts[record.Source]++
+	}
+
+	if counts["sensor1"] != 17 || counts["sensor2"] != 17 {
+		t.Fatalf("unexpected counts: %v", counts)
+	}
+
+	info, err := os.Stat(test_filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sensor := range []string{"sensor1", "sensor2"} {
+		filename, offset := reader.Offset(sensor)
+		if filename != sensor+".log.1382627900" {
+			t.Fatalf("unexpected filename for %s: %s", sensor, filename)
+		}
+		if offset != info.Size() {
+			t.Fatalf("unexpected offset for %s: %d", sensor, offset)
+		}
+	}
+}
+
+func TestSpoolRecordReaderSetOffset(t *testing.T) {
+
+	test_filename := "test/multi-record-event.log"
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	copyFile(test_filename, fmt.Sprintf("%s/merged.log.1382627900", tmpdir))
+
+	reader := NewSpoolRecordReader(tmpdir, "merged.log")
+	if err := reader.SetOffset("merged.log.1382627900", 68); err != nil {
+		t.Fatal(err)
+	}
+	record, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := record.(*EventRecord); ok {
+		t.Fatal("did not expect Next() to return *EventRecord")
+	}
+	filename, _ := reader.Offset()
+	if filename != "merged.log.1382627900" {
+		t.Fatalf("unexpected filename %s", filename)
+	}
+}
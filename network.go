@@ -0,0 +1,167 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// Unified2 network protocol frame types.
+//
+// Each frame is a 4 byte type and a 4 byte length, in network byte
+// order, followed by length bytes of payload.  A connection starts
+// with a hello frame from the sender holding the protocol version and
+// the name of the sensor.  Record frames hold a sequence number and a
+// unified2 record.  The receiver acknowledges, with an ack frame, the
+// sequence number of the last record it has durably handled.
+const (
+	NET_FRAME_HELLO  = 1
+	NET_FRAME_RECORD = 2
+	NET_FRAME_ACK    = 3
+)
+
+// NET_PROTOCOL_VERSION is the version of the network protocol sent in
+// the hello frame.
+const NET_PROTOCOL_VERSION = 1
+
+// NET_MAX_FRAME_LEN is the largest frame payload accepted.
+const NET_MAX_FRAME_LEN = 16 * 1024 * 1024
+
+// ProtocolError is returned when a peer sends a frame that does not
+// follow the network protocol.
+var ProtocolError = errors.New("unified2 network protocol error")
+
+// Sensor names are used as directory names by SpoolWriter so are
+// restricted to a safe set of characters.
+var sensorNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// ValidSensorName returns true if name can be used as a sensor name.
+func ValidSensorName(name string) bool {
+	return len(name) <= 255 && sensorNamePattern.MatchString(name)
+}
+
+type frame struct {
+	Type    uint32
+	Payload []byte
+}
+
+func writeFrame(writer io.Writer, frameType uint32, payload ...[]byte) error {
+	length := 0
+	for _, p := range payload {
+		length += len(p)
+	}
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, frameType)
+	binary.BigEndian.PutUint32(header[4:], uint32(length))
+	if _, err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, p := range payload {
+		if _, err := writer.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFrame(reader *bufio.Reader) (*frame, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[4:])
+	if length > NET_MAX_FRAME_LEN {
+		return nil, ProtocolError
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return &frame{binary.BigEndian.Uint32(header), payload}, nil
+}
+
+func writeHello(writer io.Writer, sensor string) error {
+	version := make([]byte, 4)
+	binary.BigEndian.PutUint32(version, NET_PROTOCOL_VERSION)
+	return writeFrame(writer, NET_FRAME_HELLO, version, []byte(sensor))
+}
+
+// parseHello returns the sensor name from a hello frame.
+func parseHello(f *frame) (string, error) {
+	if f.Type != NET_FRAME_HELLO || len(f.Payload) < 4 {
+		return "", ProtocolError
+	}
+	version := binary.BigEndian.Uint32(f.Payload)
+	if version != NET_PROTOCOL_VERSION {
+		return "", fmt.Errorf("unsupported protocol version %d", version)
+	}
+	sensor := string(f.Payload[4:])
+	if !ValidSensorName(sensor) {
+		return "", fmt.Errorf("invalid sensor name %q", sensor)
+	}
+	return sensor, nil
+}
+
+func writeRecord(writer io.Writer, seq uint64, record *RawRecord) error {
+	header := make([]byte, 12)
+	binary.BigEndian.PutUint64(header, seq)
+	binary.BigEndian.PutUint32(header[8:], record.Type)
+	return writeFrame(writer, NET_FRAME_RECORD, header, record.Data)
+}
+
+// parseRecord returns the sequence number and record of a record
+// frame.
+func parseRecord(f *frame) (uint64, *RawRecord, error) {
+	if f.Type != NET_FRAME_RECORD || len(f.Payload) < 12 {
+		return 0, nil, ProtocolError
+	}
+	return binary.BigEndian.Uint64(f.Payload),
+		&RawRecord{binary.BigEndian.Uint32(f.Payload[8:]), f.Payload[12:]},
+		nil
+}
+
+func writeAck(writer io.Writer, seq uint64) error {
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint64(payload, seq)
+	return writeFrame(writer, NET_FRAME_ACK, payload)
+}
+
+// parseAck returns the sequence number of an ack frame.
+func parseAck(f *frame) (uint64, error) {
+	if f.Type != NET_FRAME_ACK || len(f.Payload) != 8 {
+		return 0, ProtocolError
+	}
+	return binary.BigEndian.Uint64(f.Payload), nil
+}
@@ -0,0 +1,299 @@
+package unified2
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Runs a sender over a spool of two copies of the test file until the
+// last record has been acknowledged.
+func runSender(t *testing.T, receiver *Receiver, tlsConfig *tls.Config) (string, []byte) {
+	test_filename := "test/multi-record-event.log"
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spool := path.Join(tmpdir, "spool")
+	if err := os.Mkdir(spool, 0755); err != nil {
+		t.Fatal(err)
+	}
+	copyFile(test_filename, path.Join(spool, "unified2.log.1382627900"))
+	copyFile(test_filename, path.Join(spool, "unified2.log.1382627901"))
+	original, err := ioutil.ReadFile(test_filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go receiver.Serve(listener)
+	defer receiver.Close()
+
+	done := make(chan bool)
+	sender := &Sender{
+		Reader:            NewSpoolRecordReader(spool, "unified2.log"),
+		Address:           listener.Addr().String(),
+		Sensor:            "sensor1",
+		TLSConfig:         tlsConfig,
+		BookmarkFilename:  path.Join(tmpdir, "bookmark"),
+		Window:            3,
+		PollInterval:      10 * time.Millisecond,
+		ReconnectInterval: 10 * time.Millisecond,
+		Checkpoint: func(bookmark *Bookmark) {
+			if bookmark.Filename == "unified2.log.1382627901" &&
+				bookmark.Offset == int64(len(original)) {
+				close(done)
+			}
+		},
+	}
+
+	stop := make(chan bool)
+	result := make(chan error)
+	go func() {
+		result <- sender.Run(stop)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for records to be acknowledged")
+	}
+	close(stop)
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+
+	bookmark, err := ReadBookmark(sender.BookmarkFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bookmark.Offset != int64(len(original)) {
+		t.Fatalf("unexpected bookmark offset %d", bookmark.Offset)
+	}
+
+	return tmpdir, append(original, original...)
+}
+
+func TestSenderReceiver(t *testing.T) {
+	output, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(output)
+
+	writer := NewSpoolWriter(output, "unified2.log")
+	receiver := &Receiver{
+		Handler: writer.Write,
+		Sync:    writer.Sync,
+	}
+
+	tmpdir, expected := runSender(t, receiver, nil)
+	defer os.RemoveAll(tmpdir)
+	writer.Close()
+
+	files, err := ioutil.ReadDir(writer.Directory("sensor1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := []byte{}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(path.Join(writer.Directory("sensor1"),
+			file.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		received = append(received, data...)
+	}
+	if !bytes.Equal(received, expected) {
+		t.Fatalf("received %d bytes, expected %d", len(received),
+			len(expected))
+	}
+}
+
+func TestSenderReconnect(t *testing.T) {
+	var mutex sync.Mutex
+	received := []*RawRecord{}
+	failed := false
+
+	receiver := &Receiver{
+		AckInterval: 1,
+		Handler: func(sensor string, record *RawRecord) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			if len(received) == 5 && !failed {
+				failed = true
+				return errors.New("failed")
+			}
+			received = append(received, record)
+			return nil
+		},
+	}
+
+	tmpdir, expected := runSender(t, receiver, nil)
+	defer os.RemoveAll(tmpdir)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !failed {
+		t.Fatal("handler did not fail")
+	}
+
+	// Records may be received more than once, but the records
+	// received since the failure must be the remainder of the spool.
+	var buf bytes.Buffer
+	for _, record := range received[5:] {
+		WriteRawRecord(&buf, record)
+	}
+	if !bytes.HasSuffix(expected, buf.Bytes()) {
+		t.Fatal("records after reconnect do not match the spool")
+	}
+	before := 0
+	for _, record := range received[:5] {
+		before += len(record.Data) + 8
+	}
+	if buf.Len() < len(expected)-before {
+		t.Fatalf("only %d bytes received after reconnect", buf.Len())
+	}
+}
+
+func testCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template,
+		&key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestSenderReceiverTLS(t *testing.T) {
+	certificate := testCertificate(t)
+	roots := x509.NewCertPool()
+	parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots.AddCert(parsed)
+
+	var mutex sync.Mutex
+	sensors := map[string]int{}
+	receiver := &Receiver{
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{certificate}},
+		Handler: func(sensor string, record *RawRecord) error {
+			mutex.Lock()
+			sensors[sensor]++
+			mutex.Unlock()
+			return nil
+		},
+	}
+
+	tmpdir, _ := runSender(t, receiver, &tls.Config{RootCAs: roots})
+	defer os.RemoveAll(tmpdir)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(sensors) != 1 || sensors["sensor1"] == 0 {
+		t.Fatalf("unexpected sensors: %v", sensors)
+	}
+}
+
+func TestReceiverProtocolErrors(t *testing.T) {
+	errs := make(chan error, 1)
+	receiver := &Receiver{
+		Handler: func(sensor string, record *RawRecord) error {
+			return nil
+		},
+		Errors: func(err error) {
+			errs <- err
+		},
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go receiver.Serve(listener)
+	defer receiver.Close()
+
+	for i, hello := range []func(*bufio.Writer) error{
+		func(w *bufio.Writer) error {
+			return writeHello(w, "../etc")
+		},
+		func(w *bufio.Writer) error {
+			return writeFrame(w, NET_FRAME_ACK, make([]byte, 8))
+		},
+		func(w *bufio.Writer) error {
+			return writeFrame(w, NET_FRAME_HELLO,
+				make([]byte, NET_MAX_FRAME_LEN+1))
+		},
+	} {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer := bufio.NewWriter(conn)
+		hello(writer)
+		writer.Flush()
+
+		select {
+		case err := <-errs:
+			if _, ok := err.(*SensorError); !ok {
+				t.Fatalf("%d: expected *SensorError, got %v", i, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d: expected an error", i)
+		}
+		conn.Close()
+	}
+}
+
+func TestSenderInvalidSensor(t *testing.T) {
+	sender := &Sender{
+		Reader: NewSpoolRecordReader("test", "unified2.log"),
+		Sensor: "a/b",
+	}
+	if err := sender.Run(make(chan bool)); err == nil {
+		t.Fatal("expected error for invalid sensor name")
+	}
+	for _, name := range []string{"", ".", "..", ".hidden", "a b"} {
+		if ValidSensorName(name) {
+			t.Fatalf("%q should not be valid", name)
+		}
+	}
+	for _, name := range []string{"sensor1", "dmz-01.example.com"} {
+		if !ValidSensorName(name) {
+			t.Fatalf("%q should be valid", name)
+		}
+	}
+}
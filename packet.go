@@ -0,0 +1,507 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+// Link types of packet records, as used by libpcap.
+const (
+	LINKTYPE_ETHERNET  = 1
+	LINKTYPE_RAW       = 101
+	LINKTYPE_LINUX_SLL = 113
+	LINKTYPE_IPV4      = 228
+	LINKTYPE_IPV6      = 229
+)
+
+// IP protocol numbers of the protocols DecodePacket understands.
+const (
+	IPPROTO_ICMP   = 1
+	IPPROTO_TCP    = 6
+	IPPROTO_UDP    = 17
+	IPPROTO_ICMPV6 = 58
+)
+
+// Ethernet types of the protocols DecodePacket understands.
+const (
+	ETHERTYPE_IPV4 = 0x0800
+	ETHERTYPE_VLAN = 0x8100
+	ETHERTYPE_IPV6 = 0x86dd
+	ETHERTYPE_QINQ = 0x88a8
+)
+
+// UnsupportedLinkType is the error returned by DecodePacket for a link
+// type it does not know how to decode.
+var UnsupportedLinkType = errors.New("unsupported link type")
+
+// IPv4Header is a decoded IPv4 header.
+type IPv4Header struct {
+	Version        uint8
+	HeaderLength   uint8 // In bytes.
+	Tos            uint8
+	Length         uint16
+	Id             uint16
+	Flags          uint8
+	FragmentOffset uint16
+	Ttl            uint8
+	Protocol       uint8
+	Checksum       uint16
+	Source         net.IP
+	Destination    net.IP
+}
+
+// IPv6Header is a decoded IPv6 header.  NextHeader is the protocol
+// following any extension headers.
+type IPv6Header struct {
+	TrafficClass  uint8
+	FlowLabel     uint32
+	PayloadLength uint16
+	NextHeader    uint8
+	HopLimit      uint8
+	Source        net.IP
+	Destination   net.IP
+}
+
+// TCPHeader is a decoded TCP header.
+type TCPHeader struct {
+	SourcePort      uint16
+	DestinationPort uint16
+	Seq             uint32
+	Ack             uint32
+	DataOffset      uint8 // In 32 bit words.
+	Reserved        uint8
+	Flags           uint8
+	Window          uint16
+	Checksum        uint16
+	Urgent          uint16
+}
+
+// UDPHeader is a decoded UDP header.
+type UDPHeader struct {
+	SourcePort      uint16
+	DestinationPort uint16
+	Length          uint16
+	Checksum        uint16
+}
+
+// ICMPHeader is a decoded ICMP or ICMPv6 header.  Id and Seq are only
+// meaningful for echo messages.
+type ICMPHeader struct {
+	Type     uint8
+	Code     uint8
+	Checksum uint16
+	Id       uint16
+	Seq      uint16
+}
+
+// Packet is a packet dissected by DecodePacket.  Layers that are not
+// present, or could not be decoded, are nil.
+type Packet struct {
+	LinkType uint32
+
+	IPv4 *IPv4Header
+	IPv6 *IPv6Header
+	TCP  *TCPHeader
+	UDP  *UDPHeader
+	ICMP *ICMPHeader
+
+	// Offsets into the packet data of the network header, the
+	// transport header and the payload, or -1 if not found.
+	NetworkOffset   int
+	TransportOffset int
+	PayloadOffset   int
+
+	// Payload is the data following the last decoded header.
+	Payload []byte
+
+	// Truncated is set if the packet ended before a header did.
+	Truncated bool
+}
+
+// DecodePacket dissects the headers of a packet captured with the
+// given link type.  Decoding stops at the first layer that is not
+// understood, leaving the rest of the packet as the payload.
+func DecodePacket(linkType uint32, data []byte) (*Packet, error) {
+	packet := &Packet{
+		LinkType:        linkType,
+		NetworkOffset:   -1,
+		TransportOffset: -1,
+		PayloadOffset:   -1,
+	}
+
+	var offset int
+	var etherType uint16
+
+	switch linkType {
+	case LINKTYPE_ETHERNET:
+		if len(data) < 14 {
+			packet.Truncated = true
+			return packet, nil
+		}
+		etherType = binary.BigEndian.Uint16(data[12:])
+		offset = 14
+		for etherType == ETHERTYPE_VLAN || etherType == ETHERTYPE_QINQ {
+			if len(data) < offset+4 {
+				packet.Truncated = true
+				return packet, nil
+			}
+			etherType = binary.BigEndian.Uint16(data[offset+2:])
+			offset += 4
+		}
+	case LINKTYPE_LINUX_SLL:
+		if len(data) < 16 {
+			packet.Truncated = true
+			return packet, nil
+		}
+		etherType = binary.BigEndian.Uint16(data[14:])
+		offset = 16
+	case LINKTYPE_RAW:
+		if len(data) == 0 {
+			packet.Truncated = true
+			return packet, nil
+		}
+		switch data[0] >> 4 {
+		case 4:
+			etherType = ETHERTYPE_IPV4
+		case 6:
+			etherType = ETHERTYPE_IPV6
+		}
+	case LINKTYPE_IPV4:
+		etherType = ETHERTYPE_IPV4
+	case LINKTYPE_IPV6:
+		etherType = ETHERTYPE_IPV6
+	default:
+		return nil, UnsupportedLinkType
+	}
+
+	packet.PayloadOffset = offset
+
+	var protocol uint8
+	var fragment bool
+
+	switch etherType {
+	case ETHERTYPE_IPV4:
+		ip, length, ok := decodeIPv4(data[offset:])
+		if !ok {
+			packet.Truncated = true
+			packet.Payload = data[offset:]
+			return packet, nil
+		}
+		packet.IPv4 = ip
+		packet.NetworkOffset = offset
+		offset += length
+		protocol = ip.Protocol
+		fragment = ip.FragmentOffset != 0
+	case ETHERTYPE_IPV6:
+		ip, length, first, ok := decodeIPv6(data[offset:])
+		if !ok {
+			packet.Truncated = true
+			packet.Payload = data[offset:]
+			return packet, nil
+		}
+		packet.IPv6 = ip
+		packet.NetworkOffset = offset
+		offset += length
+		protocol = ip.NextHeader
+		fragment = !first
+	default:
+		packet.Payload = data[offset:]
+		return packet, nil
+	}
+
+	packet.PayloadOffset = offset
+
+	// Only the first fragment has a transport header.
+	if fragment {
+		packet.Payload = data[offset:]
+		return packet, nil
+	}
+
+	var length int
+	switch protocol {
+	case IPPROTO_TCP:
+		if len(data) < offset+20 {
+			break
+		}
+		tcp := data[offset:]
+		packet.TCP = &TCPHeader{
+			SourcePort:      binary.BigEndian.Uint16(tcp[0:]),
+			DestinationPort: binary.BigEndian.Uint16(tcp[2:]),
+			Seq:             binary.BigEndian.Uint32(tcp[4:]),
+			Ack:             binary.BigEndian.Uint32(tcp[8:]),
+			DataOffset:      tcp[12] >> 4,
+			Reserved:        tcp[12] & 0x0f,
+			Flags:           tcp[13],
+			Window:          binary.BigEndian.Uint16(tcp[14:]),
+			Checksum:        binary.BigEndian.Uint16(tcp[16:]),
+			Urgent:          binary.BigEndian.Uint16(tcp[18:]),
+		}
+		length = int(packet.TCP.DataOffset) * 4
+		if length < 20 {
+			length = 20
+		}
+	case IPPROTO_UDP:
+		if len(data) < offset+8 {
+			break
+		}
+		udp := data[offset:]
+		packet.UDP = &UDPHeader{
+			SourcePort:      binary.BigEndian.Uint16(udp[0:]),
+			DestinationPort: binary.BigEndian.Uint16(udp[2:]),
+			Length:          binary.BigEndian.Uint16(udp[4:]),
+			Checksum:        binary.BigEndian.Uint16(udp[6:]),
+		}
+		length = 8
+	case IPPROTO_ICMP, IPPROTO_ICMPV6:
+		if len(data) < offset+8 {
+			break
+		}
+		icmp := data[offset:]
+		packet.ICMP = &ICMPHeader{
+			Type:     icmp[0],
+			Code:     icmp[1],
+			Checksum: binary.BigEndian.Uint16(icmp[2:]),
+			Id:       binary.BigEndian.Uint16(icmp[4:]),
+			Seq:      binary.BigEndian.Uint16(icmp[6:]),
+		}
+		length = 8
+	default:
+		packet.Payload = data[offset:]
+		return packet, nil
+	}
+
+	if length == 0 {
+		packet.Truncated = true
+		packet.Payload = data[offset:]
+		return packet, nil
+	}
+
+	packet.TransportOffset = offset
+	offset += length
+	if offset > len(data) {
+		packet.Truncated = true
+		offset = len(data)
+	}
+	packet.PayloadOffset = offset
+	packet.Payload = data[offset:]
+
+	return packet, nil
+}
+
+// Decode dissects the packet data of a packet record.
+func (r *PacketRecord) Decode() (*Packet, error) {
+	return DecodePacket(r.LinkType, r.Data)
+}
+
+// decodeIPv4 decodes an IPv4 header, returning the header and its
+// length.
+func decodeIPv4(data []byte) (*IPv4Header, int, bool) {
+	if len(data) < 20 || data[0]>>4 != 4 {
+		return nil, 0, false
+	}
+	length := int(data[0]&0x0f) * 4
+	if length < 20 || len(data) < length {
+		return nil, 0, false
+	}
+	flags := binary.BigEndian.Uint16(data[6:])
+	return &IPv4Header{
+		Version:        4,
+		HeaderLength:   uint8(length),
+		Tos:            data[1],
+		Length:         binary.BigEndian.Uint16(data[2:]),
+		Id:             binary.BigEndian.Uint16(data[4:]),
+		Flags:          uint8(flags >> 13),
+		FragmentOffset: flags & 0x1fff,
+		Ttl:            data[8],
+		Protocol:       data[9],
+		Checksum:       binary.BigEndian.Uint16(data[10:]),
+		Source:         net.IP(append([]byte{}, data[12:16]...)),
+		Destination:    net.IP(append([]byte{}, data[16:20]...)),
+	}, length, true
+}
+
+// decodeIPv6 decodes an IPv6 header and any extension headers,
+// returning the header, the combined length and false if the packet
+// is a fragment other than the first.
+func decodeIPv6(data []byte) (*IPv6Header, int, bool, bool) {
+	if len(data) < 40 || data[0]>>4 != 6 {
+		return nil, 0, false, false
+	}
+	ip := &IPv6Header{
+		TrafficClass:  uint8(binary.BigEndian.Uint16(data[0:]) >> 4),
+		FlowLabel:     binary.BigEndian.Uint32(data[0:]) & 0xfffff,
+		PayloadLength: binary.BigEndian.Uint16(data[4:]),
+		NextHeader:    data[6],
+		HopLimit:      data[7],
+		Source:        net.IP(append([]byte{}, data[8:24]...)),
+		Destination:   net.IP(append([]byte{}, data[24:40]...)),
+	}
+
+	length := 40
+	for {
+		switch ip.NextHeader {
+		case 0, 43, 60:
+			// Hop-by-hop, routing and destination options.
+			if len(data) < length+8 {
+				return ip, length, true, true
+			}
+			ip.NextHeader = data[length]
+			length += (int(data[length+1]) + 1) * 8
+		case 44:
+			// Fragment.
+			if len(data) < length+8 {
+				return ip, length, true, true
+			}
+			ip.NextHeader = data[length]
+			offset := binary.BigEndian.Uint16(data[length+2:]) >> 3
+			length += 8
+			if offset != 0 {
+				return ip, length, false, true
+			}
+		default:
+			return ip, length, true, true
+		}
+		if length > len(data) {
+			return ip, len(data), true, true
+		}
+	}
+}
+
+// checksum computes the Internet checksum of data, starting from the
+// partial sum initial.
+func checksum(data []byte, initial uint32) uint16 {
+	sum := initial
+	for len(data) > 1 {
+		sum += uint32(data[0])<<8 | uint32(data[1])
+		data = data[2:]
+	}
+	if len(data) > 0 {
+		sum += uint32(data[0]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// pseudoHeaderSum returns the partial checksum of the pseudo header
+// covered by the TCP, UDP and ICMPv6 checksums.
+func pseudoHeaderSum(source, destination net.IP, protocol uint8,
+	length int) uint32 {
+	var sum uint32
+	for _, address := range []net.IP{source, destination} {
+		for i := 0; i+1 < len(address); i += 2 {
+			sum += uint32(address[i])<<8 | uint32(address[i+1])
+		}
+	}
+	return sum + uint32(protocol) + uint32(length>>16) + uint32(length&0xffff)
+}
+
+// updateChecksums recomputes the IPv4 header checksum and the
+// transport checksum of a packet decoded from data, in place.  The
+// transport checksum is left alone if the packet was not captured in
+// full, as it can not be computed.
+func updateChecksums(data []byte, packet *Packet) {
+	var end int
+	var sum uint32
+	var protocol uint8
+
+	switch {
+	case packet.IPv4 != nil:
+		header := data[packet.NetworkOffset:]
+		header[10], header[11] = 0, 0
+		binary.BigEndian.PutUint16(header[10:],
+			checksum(header[:packet.IPv4.HeaderLength], 0))
+		end = packet.NetworkOffset + int(packet.IPv4.Length)
+		protocol = packet.IPv4.Protocol
+		if packet.TransportOffset >= 0 && end <= len(data) &&
+			end >= packet.TransportOffset {
+			sum = pseudoHeaderSum(header[12:16], header[16:20],
+				protocol, end-packet.TransportOffset)
+		}
+	case packet.IPv6 != nil:
+		header := data[packet.NetworkOffset:]
+		end = packet.NetworkOffset + 40 + int(packet.IPv6.PayloadLength)
+		protocol = packet.IPv6.NextHeader
+		if packet.TransportOffset >= 0 && end <= len(data) &&
+			end >= packet.TransportOffset {
+			sum = pseudoHeaderSum(header[8:24], header[24:40],
+				protocol, end-packet.TransportOffset)
+		}
+	default:
+		return
+	}
+
+	if packet.TransportOffset < 0 || end > len(data) ||
+		end < packet.TransportOffset {
+		return
+	}
+	segment := data[packet.TransportOffset:end]
+
+	var offset int
+	switch {
+	case packet.TCP != nil:
+		offset = 16
+	case packet.UDP != nil:
+		offset = 6
+	case packet.ICMP != nil:
+		offset = 2
+		if protocol == IPPROTO_ICMP {
+			sum = 0
+		}
+	default:
+		return
+	}
+	if len(segment) < offset+2 {
+		return
+	}
+
+	segment[offset], segment[offset+1] = 0, 0
+	value := checksum(segment, sum)
+	if packet.UDP != nil && value == 0 {
+		value = 0xffff
+	}
+	binary.BigEndian.PutUint16(segment[offset:], value)
+}
+
+// adjustChecksum returns a checksum updated for the replacement of
+// before with after in the data it covers, as described in RFC 1624.
+// This works for packets that were not captured in full.
+func adjustChecksum(sum uint16, before, after []byte) uint16 {
+	value := uint32(^sum)
+	for i := 0; i+1 < len(before); i += 2 {
+		value += uint32(^(uint16(before[i])<<8 | uint16(before[i+1])))
+		value += uint32(after[i])<<8 | uint32(after[i+1])
+	}
+	for value > 0xffff {
+		value = value>>16 + value&0xffff
+	}
+	return ^uint16(value)
+}
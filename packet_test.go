@@ -0,0 +1,122 @@
+package unified2
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestDecodePacket(t *testing.T) {
+	events := readAggregatedEvents(t, "test/multi-record-event-x2.log")
+	event := events[0].Event
+
+	packet, err := events[0].Packets[0].Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet.IPv4 == nil || packet.TCP == nil {
+		t.Fatal("expected IPv4 and TCP headers")
+	}
+	if packet.Truncated {
+		t.Fatal("packet should not be truncated")
+	}
+
+	// The first packet is the server response that triggered the
+	// event.
+	if !packet.IPv4.Source.Equal(event.IpSource) ||
+		!packet.IPv4.Destination.Equal(event.IpDestination) {
+		t.Fatalf("unexpected addresses %s -> %s", packet.IPv4.Source,
+			packet.IPv4.Destination)
+	}
+	if packet.TCP.SourcePort != event.SportItype ||
+		packet.TCP.DestinationPort != event.DportIcode {
+		t.Fatalf("unexpected ports %d -> %d", packet.TCP.SourcePort,
+			packet.TCP.DestinationPort)
+	}
+	if packet.PayloadOffset != packet.TransportOffset+
+		int(packet.TCP.DataOffset)*4 {
+		t.Fatal("unexpected payload offset")
+	}
+	if !bytes.Equal(packet.Payload,
+		events[0].Packets[0].Data[packet.PayloadOffset:]) {
+		t.Fatal("unexpected payload")
+	}
+}
+
+func TestDecodePacketIPv6(t *testing.T) {
+	data := []byte{
+		// Ethernet with a VLAN tag.
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 0x81, 0x00,
+		0x00, 0x64, 0x86, 0xdd,
+		// IPv6, next header is hop-by-hop options.
+		0x60, 0, 0, 0, 0, 20, 0, 64,
+		0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+		0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2,
+		// Hop-by-hop options, next header is UDP.
+		17, 0, 0, 0, 0, 0, 0, 0,
+		// UDP.
+		0x00, 0x35, 0x04, 0xd2, 0, 12, 0xab, 0xcd,
+		'a', 'b', 'c', 'd',
+	}
+
+	packet, err := DecodePacket(LINKTYPE_ETHERNET, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet.IPv6 == nil || packet.UDP == nil {
+		t.Fatal("expected IPv6 and UDP headers")
+	}
+	if packet.NetworkOffset != 18 || packet.TransportOffset != 66 {
+		t.Fatalf("unexpected offsets %d, %d", packet.NetworkOffset,
+			packet.TransportOffset)
+	}
+	if !packet.IPv6.Destination.Equal(net.ParseIP("2001:db8::2")) {
+		t.Fatalf("unexpected destination %s", packet.IPv6.Destination)
+	}
+	if packet.IPv6.NextHeader != IPPROTO_UDP {
+		t.Fatalf("unexpected next header %d", packet.IPv6.NextHeader)
+	}
+	if packet.UDP.SourcePort != 53 || packet.UDP.DestinationPort != 1234 {
+		t.Fatal("unexpected ports")
+	}
+	if string(packet.Payload) != "abcd" {
+		t.Fatalf("unexpected payload %q", packet.Payload)
+	}
+}
+
+func TestDecodePacketTruncated(t *testing.T) {
+	// An IPv4 header claiming TCP with only 4 bytes of TCP header.
+	data := []byte{
+		0x45, 0, 0, 44, 0, 1, 0, 0, 64, 6, 0, 0,
+		10, 0, 0, 1, 10, 0, 0, 2,
+		0, 80, 0x04, 0xd2,
+	}
+	packet, err := DecodePacket(LINKTYPE_RAW, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet.IPv4 == nil {
+		t.Fatal("expected IPv4 header")
+	}
+	if packet.TCP != nil {
+		t.Fatal("expected no TCP header")
+	}
+	if !packet.Truncated {
+		t.Fatal("expected packet to be truncated")
+	}
+	if len(packet.Payload) != 4 {
+		t.Fatalf("unexpected payload length %d", len(packet.Payload))
+	}
+
+	packet, err = DecodePacket(LINKTYPE_ETHERNET, data[:10])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !packet.Truncated || packet.IPv4 != nil {
+		t.Fatal("expected truncated packet")
+	}
+
+	if _, err := DecodePacket(9999, data); err != UnsupportedLinkType {
+		t.Fatalf("expected UnsupportedLinkType, got %v", err)
+	}
+}
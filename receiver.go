@@ -0,0 +1,226 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// SensorError is passed to Receiver.Errors when the connection from a
+// sensor fails.
+type SensorError struct {
+	Sensor  string
+	Address string
+	Err     error
+}
+
+func (e *SensorError) Error() string {
+	if e.Sensor == "" {
+		return fmt.Sprintf("%s: %s", e.Address, e.Err)
+	}
+	return fmt.Sprintf("%s (%s): %s", e.Sensor, e.Address, e.Err)
+}
+
+// Receiver accepts connections from Senders and passes the records
+// received to Handler.
+type Receiver struct {
+	// Handler is called with each record received.  If an error is
+	// returned the connection is closed, and the sender will send the
+	// record again when it reconnects.
+	Handler func(sensor string, record *RawRecord) error
+
+	// Sync, if set, is called before records from a sensor are
+	// acknowledged and must make them durable.  If an error is
+	// returned the connection is closed.
+	Sync func(sensor string) error
+
+	// TLSConfig, if set, enables TLS.
+	TLSConfig *tls.Config
+
+	// AckInterval is the maximum number of records to handle before
+	// acknowledging them, when more are immediately available.
+	// Defaults to 100.
+	AckInterval int
+
+	// Errors, if set, is called with a *SensorError when a connection
+	// fails.
+	Errors func(err error)
+
+	mutex     sync.Mutex
+	closed    bool
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool
+	wg        sync.WaitGroup
+}
+
+// ListenAndServe listens on the TCP address and serves connections
+// until Close is called.
+func (r *Receiver) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return r.Serve(listener)
+}
+
+// Serve accepts connections on listener until Close is called, after
+// which nil is returned.
+func (r *Receiver) Serve(listener net.Listener) error {
+	if r.TLSConfig != nil {
+		listener = tls.NewListener(listener, r.TLSConfig)
+	}
+
+	r.mutex.Lock()
+	if r.closed {
+		r.mutex.Unlock()
+		listener.Close()
+		return nil
+	}
+	if r.listeners == nil {
+		r.listeners = map[net.Listener]bool{}
+		r.conns = map[net.Conn]bool{}
+	}
+	r.listeners[listener] = true
+	r.mutex.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			r.mutex.Lock()
+			closed := r.closed
+			delete(r.listeners, listener)
+			r.mutex.Unlock()
+			listener.Close()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		r.mutex.Lock()
+		if r.closed {
+			r.mutex.Unlock()
+			conn.Close()
+			continue
+		}
+		r.conns[conn] = true
+		r.wg.Add(1)
+		r.mutex.Unlock()
+
+		go func() {
+			defer r.wg.Done()
+			r.serveConn(conn)
+			r.mutex.Lock()
+			delete(r.conns, conn)
+			r.mutex.Unlock()
+		}()
+	}
+}
+
+// Close stops all listeners, closes all connections and waits for
+// their handlers to return.
+func (r *Receiver) Close() error {
+	r.mutex.Lock()
+	r.closed = true
+	for listener := range r.listeners {
+		listener.Close()
+	}
+	for conn := range r.conns {
+		conn.Close()
+	}
+	r.mutex.Unlock()
+	r.wg.Wait()
+	return nil
+}
+
+func (r *Receiver) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	sensor, err := r.receive(conn)
+	if err != nil && err != io.EOF && r.Errors != nil {
+		r.mutex.Lock()
+		closed := r.closed
+		r.mutex.Unlock()
+		if !closed {
+			r.Errors(&SensorError{sensor, conn.RemoteAddr().String(),
+				err})
+		}
+	}
+}
+
+// receive handles records from a connection until it is closed,
+// returning the sensor name and the reason.
+func (r *Receiver) receive(conn net.Conn) (string, error) {
+	ackInterval := r.AckInterval
+	if ackInterval == 0 {
+		ackInterval = 100
+	}
+
+	reader := bufio.NewReader(conn)
+
+	f, err := readFrame(reader)
+	if err != nil {
+		return "", err
+	}
+	sensor, err := parseHello(f)
+	if err != nil {
+		return "", err
+	}
+
+	unacknowledged := 0
+	for {
+		f, err := readFrame(reader)
+		if err != nil {
+			return sensor, err
+		}
+		seq, record, err := parseRecord(f)
+		if err != nil {
+			return sensor, err
+		}
+		if err := r.Handler(sensor, record); err != nil {
+			return sensor, err
+		}
+		unacknowledged++
+
+		if reader.Buffered() == 0 || unacknowledged >= ackInterval {
+			if r.Sync != nil {
+				if err := r.Sync(sensor); err != nil {
+					return sensor, err
+				}
+			}
+			if err := writeAck(conn, seq); err != nil {
+				return sensor, err
+			}
+			unacknowledged = 0
+		}
+	}
+}
@@ -54,7 +54,7 @@ func NewRecordReader(filename string, offset int64) (*RecordReader, error) {
 			file.Close()
 			return nil, err
 		} else if ret != offset {
-			log.Printf("Failed to seek to offset %d: current offset: %s",
+			log.Printf("Failed to seek to offset %d: current offset: %d",
 				offset, ret)
 			file.Close()
 			return nil, err
@@ -71,6 +71,11 @@ func (r *RecordReader) Next() (interface{}, error) {
 	return ReadRecord(r.File)
 }
 
+// NextRaw reads and returns the next record without decoding it.
+func (r *RecordReader) NextRaw() (*RawRecord, error) {
+	return ReadRawRecord(r.File)
+}
+
 // Close closes this reader and the underlying file.
 func (r *RecordReader) Close() {
 	r.File.Close()
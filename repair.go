@@ -0,0 +1,221 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Reasons data is removed by Repair.
+const (
+	REPAIR_CORRUPT   = "corrupt"
+	REPAIR_TRUNCATED = "truncated"
+)
+
+// RepairRegion is a region of the input dropped by Repair.
+type RepairRegion struct {
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+	Reason string `json:"reason"`
+}
+
+// RepairReport describes what Repair copied and what it removed.
+type RepairReport struct {
+	// Records is the number of records copied to the output.
+	Records int `json:"records"`
+
+	// Removed are the regions of the input not copied to the output.
+	Removed []RepairRegion `json:"removed"`
+}
+
+// BytesRemoved returns the total number of bytes removed.
+func (r *RepairReport) BytesRemoved() int64 {
+	var removed int64
+	for _, region := range r.Removed {
+		removed += region.Length
+	}
+	return removed
+}
+
+// plausibleHeader returns true if a record header could be the start
+// of a valid record of a known type.
+func plausibleHeader(header *RawHeader) bool {
+	switch {
+	case isEventType(header.Type):
+		return int(header.Len) == eventRecordLengths[header.Type]
+	case isPacketType(header.Type):
+		return header.Len >= PACKET_RECORD_HDR_LEN
+	case header.Type == UNIFIED2_EXTRA_DATA:
+		return header.Len >= EXTRA_DATA_RECORD_HDR_LEN
+	}
+	return false
+}
+
+// plausibleRecord returns true if the lengths recorded within a
+// record agree with the length of the record.
+func plausibleRecord(record *RawRecord) bool {
+	length := uint32(len(record.Data))
+	switch record.Type {
+	case UNIFIED2_PACKET, UNIFIED2_BUFFER:
+		return binary.BigEndian.Uint32(record.Data[24:]) ==
+			length-PACKET_RECORD_HDR_LEN
+	case UNIFIED2_EXTRA_DATA:
+		return binary.BigEndian.Uint32(record.Data[4:]) == length &&
+			binary.BigEndian.Uint32(record.Data[28:]) == length-24
+	}
+	return true
+}
+
+// readRecordAt reads the record at offset if it appears to be a valid
+// record that ends before size.  Returns nil if no valid record was
+// found at offset.
+func readRecordAt(file io.ReadSeeker, offset int64, size int64) (*RawRecord, error) {
+	var header RawHeader
+
+	if _, err := file.Seek(offset, 0); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(file, binary.BigEndian, &header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !plausibleHeader(&header) || offset+8+int64(header.Len) > size {
+		return nil, nil
+	}
+
+	data := make([]byte, header.Len)
+	if _, err := io.ReadFull(file, data); err != nil {
+		return nil, err
+	}
+	record := &RawRecord{header.Type, data}
+	if !plausibleRecord(record) {
+		return nil, nil
+	}
+	return record, nil
+}
+
+// findRecord returns the offset of the first valid record at or after
+// offset, or size if there are none.
+func findRecord(file io.ReadSeeker, offset int64, size int64) (int64, error) {
+	buf := make([]byte, 65536)
+
+	for offset+8 <= size {
+		if _, err := file.Seek(offset, 0); err != nil {
+			return 0, err
+		}
+		n, err := io.ReadFull(file, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+
+		// Only check headers that are complete in the buffer, the
+		// rest are checked in the next pass.
+		for i := 0; i+8 <= n; i++ {
+			header := RawHeader{
+				binary.BigEndian.Uint32(buf[i:]),
+				binary.BigEndian.Uint32(buf[i+4:]),
+			}
+			if !plausibleHeader(&header) {
+				continue
+			}
+			record, err := readRecordAt(file, offset+int64(i), size)
+			if err != nil {
+				return 0, err
+			}
+			if record != nil {
+				return offset + int64(i), nil
+			}
+		}
+
+		if n < 8 {
+			break
+		}
+		offset += int64(n - 7)
+	}
+
+	return size, nil
+}
+
+// Repair copies all complete and valid records from input to output,
+// dropping corrupt regions and any incomplete record at the end of the
+// input.  If quarantine is not nil the dropped bytes are written to it.
+//
+// A record is considered corrupt if its type is unknown or its length
+// does not agree with its type.  Copying resumes at the next offset
+// that holds a valid record.
+func Repair(input io.ReadWriteSeeker, output io.Writer,
+	quarantine io.Writer) (*RepairReport, error) {
+	report := &RepairReport{Removed: []RepairRegion{}}
+
+	size, err := input.Seek(0, 2)
+	if err != nil {
+		return report, err
+	}
+
+	var offset int64
+	for offset < size {
+		record, err := readRecordAt(input, offset, size)
+		if err != nil {
+			return report, err
+		}
+
+		if record != nil {
+			if err := WriteRawRecord(output, record); err != nil {
+				return report, err
+			}
+			report.Records++
+			offset += 8 + int64(len(record.Data))
+			continue
+		}
+
+		next, err := findRecord(input, offset+1, size)
+		if err != nil {
+			return report, err
+		}
+		region := RepairRegion{offset, next - offset, REPAIR_CORRUPT}
+		if next == size {
+			region.Reason = REPAIR_TRUNCATED
+		}
+		report.Removed = append(report.Removed, region)
+
+		if quarantine != nil {
+			if _, err := input.Seek(offset, 0); err != nil {
+				return report, err
+			}
+			if _, err := io.CopyN(quarantine, input, region.Length); err != nil {
+				return report, err
+			}
+		}
+
+		offset = next
+	}
+
+	return report, nil
+}
@@ -0,0 +1,106 @@
+package unified2
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRepair(t *testing.T) {
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	original, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Garbage after the first event, and the first 20 bytes of the
+	// file again as a partial record at the end.
+	garbage := []byte("this is not a unified2 record")
+	corrupt := []byte{}
+	corrupt = append(corrupt, original[0:68]...)
+	corrupt = append(corrupt, garbage...)
+	corrupt = append(corrupt, original[68:]...)
+	corrupt = append(corrupt, original[0:20]...)
+	if err := ioutil.WriteFile(tmpdir+"/corrupt.log", corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	input, err := os.Open(tmpdir + "/corrupt.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	var output, quarantine bytes.Buffer
+	report, err := Repair(input, &output, &quarantine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Records != 17 {
+		t.Fatalf("expected 17 records, got %d", report.Records)
+	}
+	if !bytes.Equal(output.Bytes(), original) {
+		t.Fatal("repaired output does not match original")
+	}
+	expected := []RepairRegion{
+		{68, int64(len(garbage)), REPAIR_CORRUPT},
+		{int64(len(corrupt) - 20), 20, REPAIR_TRUNCATED},
+	}
+	if fmt.Sprint(report.Removed) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, report.Removed)
+	}
+	if report.BytesRemoved() != int64(quarantine.Len()) {
+		t.Fatalf("quarantined %d bytes, removed %d", quarantine.Len(),
+			report.BytesRemoved())
+	}
+}
+
+func TestSpoolRecordReaderSkipPartial(t *testing.T) {
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	copyFile("test/short-read-on-body.log",
+		fmt.Sprintf("%s/merged.log.1382627900", tmpdir))
+
+	reader := NewSpoolRecordReader(tmpdir, "merged.log")
+	reader.PartialRecordTimeout = time.Millisecond
+
+	// The only file in the spool is the latest so should not be
+	// skipped.
+	for i := 0; i < 2; i++ {
+		_, err = reader.Next()
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	copyFile("test/multi-record-event.log",
+		fmt.Sprintf("%s/merged.log.1382627901", tmpdir))
+
+	record, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record == nil {
+		t.Fatal("expected a record")
+	}
+	filename, _ := reader.Offset()
+	if filename != "merged.log.1382627901" {
+		t.Fatalf("unexpected filename %s", filename)
+	}
+}
@@ -0,0 +1,162 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// Replayer writes the records of existing unified2 files into a spool
+// directory, paced by their event times, to simulate a live sensor.
+//
+// Replayers should be created with NewReplayer().
+type Replayer struct {
+	// Speed is the rate of replay relative to the original pace, for
+	// example 2 replays twice as fast.  Zero replays as fast as
+	// possible.  Defaults to 1.
+	Speed float64
+
+	// RewriteTimestamps shifts the event and packet times of each
+	// event to the time it is replayed.
+	RewriteTimestamps bool
+
+	writer *SpoolWriter
+
+	// The event time, in microseconds, and wall clock time of the
+	// first event replayed.
+	baseTime  uint64
+	startTime time.Time
+}
+
+// NewReplayer creates a Replayer writing files prefixed with prefix
+// into directory.  Files are rotated at 128MB, the Snort default.
+func NewReplayer(directory string, prefix string) *Replayer {
+	writer := NewSpoolWriter(directory, prefix)
+	writer.MaxSize = 128 * 1024 * 1024
+	return &Replayer{
+		Speed:  1,
+		writer: writer,
+	}
+}
+
+// SetMaxSize sets the size spool files are rotated at.  Zero disables
+// rotation.
+func (r *Replayer) SetMaxSize(size int64) {
+	r.writer.MaxSize = size
+}
+
+// Replay writes the records of input into the spool.  Successive calls
+// continue the same timeline, so replaying several files in turn keeps
+// the spacing between their events.  Returns nil when input has been
+// replayed or stop is closed.
+func (r *Replayer) Replay(input io.ReadWriteSeeker, stop <-chan bool) error {
+	reader := NewEventGroupReader(input)
+
+	for {
+		group, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if r.startTime.IsZero() {
+			r.baseTime = group.Time
+			r.startTime = time.Now()
+		}
+
+		if r.Speed > 0 && group.Time > r.baseTime {
+			elapsed := time.Duration(float64(group.Time-r.baseTime) /
+				r.Speed * float64(time.Microsecond))
+			if wait := r.startTime.Add(elapsed).Sub(time.Now()); wait > 0 {
+				select {
+				case <-stop:
+					return nil
+				case <-time.After(wait):
+				}
+			}
+		} else {
+			select {
+			case <-stop:
+				return nil
+			default:
+			}
+		}
+
+		var delta uint32
+		if r.RewriteTimestamps {
+			delta = uint32(time.Now().Unix()) - uint32(group.Time/1000000)
+		}
+
+		for _, record := range group.Records {
+			if delta != 0 {
+				record = shiftRecordTime(record, delta)
+			}
+			if err := r.writer.Write("", record); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Restart starts a new timeline with the next event replayed, for
+// example to replay the same files again.
+func (r *Replayer) Restart() {
+	r.startTime = time.Time{}
+}
+
+// Close closes the current spool file.
+func (r *Replayer) Close() error {
+	return r.writer.Close()
+}
+
+// shiftRecordTime returns a copy of record with its event and packet
+// seconds advanced by delta.
+func shiftRecordTime(record *RawRecord, delta uint32) *RawRecord {
+	shifted := &RawRecord{record.Type, append([]byte{}, record.Data...)}
+
+	shift := func(offset int) {
+		if len(shifted.Data) >= offset+4 {
+			binary.BigEndian.PutUint32(shifted.Data[offset:],
+				binary.BigEndian.Uint32(shifted.Data[offset:])+delta)
+		}
+	}
+
+	switch {
+	case isEventType(record.Type):
+		shift(8)
+	case isPacketType(record.Type):
+		shift(8)
+		shift(12)
+	case record.Type == UNIFIED2_EXTRA_DATA:
+		shift(16)
+	}
+
+	return shifted
+}
@@ -0,0 +1,141 @@
+package unified2
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestReplay(t *testing.T) {
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	writeTestFile(t, path.Join(tmpdir, "input.log"), []uint32{100, 101, 102})
+	input, err := os.Open(path.Join(tmpdir, "input.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	spool := path.Join(tmpdir, "spool")
+	replayer := NewReplayer(spool, "unified2.log")
+	replayer.Speed = 20
+
+	// Rotate before every event.
+	replayer.SetMaxSize(1)
+
+	start := time.Now()
+	if err := replayer.Replay(input, nil); err != nil {
+		t.Fatal(err)
+	}
+	replayer.Close()
+
+	// Two seconds of events at 20 times speed.
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("replay was too fast: %s", elapsed)
+	}
+
+	files, err := ioutil.ReadDir(spool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("expected 3 files, got %d", len(files))
+	}
+	if n := countSpoolEvents(t, spool, "unified2.log"); n != 3 {
+		t.Fatalf("expected 3 events, got %d", n)
+	}
+}
+
+func TestReplayRewriteTimestamps(t *testing.T) {
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	input, err := os.Open("test/multi-record-event-x2.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	replayer := NewReplayer(tmpdir, "unified2.log")
+	replayer.Speed = 0
+	replayer.RewriteTimestamps = true
+	now := time.Now().Unix()
+	if err := replayer.Replay(input, nil); err != nil {
+		t.Fatal(err)
+	}
+	replayer.Close()
+
+	files, err := ioutil.ReadDir(tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(files))
+	}
+
+	original := readAggregatedEvents(t, "test/multi-record-event-x2.log")
+	events := readAggregatedEvents(t, path.Join(tmpdir, files[0].Name()))
+	if len(events) != len(original) {
+		t.Fatalf("expected %d events, got %d", len(original), len(events))
+	}
+	for i, event := range events {
+		if second := int64(event.Event.EventSecond); second < now ||
+			second > now+5 {
+			t.Fatalf("event second %d was not rewritten", second)
+		}
+		if event.Event.EventMicrosecond != original[i].Event.EventMicrosecond {
+			t.Fatal("event microseconds should not change")
+		}
+		if len(event.Packets) != len(original[i].Packets) ||
+			len(event.ExtraData) != len(original[i].ExtraData) {
+			t.Fatal("packets or extra data no longer match their event")
+		}
+		shift := event.Event.EventSecond - original[i].Event.EventSecond
+		for j, packet := range event.Packets {
+			if packet.PacketSecond !=
+				original[i].Packets[j].PacketSecond+shift {
+				t.Fatal("packet second not shifted with its event")
+			}
+		}
+	}
+}
+
+func TestReplayStop(t *testing.T) {
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	writeTestFile(t, path.Join(tmpdir, "input.log"), []uint32{100, 1000})
+	input, err := os.Open(path.Join(tmpdir, "input.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	spool := path.Join(tmpdir, "spool")
+	replayer := NewReplayer(spool, "unified2.log")
+	defer replayer.Close()
+
+	stop := make(chan bool)
+	time.AfterFunc(50*time.Millisecond, func() { close(stop) })
+	if err := replayer.Replay(input, stop); err != nil {
+		t.Fatal(err)
+	}
+	if n := countSpoolEvents(t, spool, "unified2.log"); n != 1 {
+		t.Fatalf("expected 1 event before stopping, got %d", n)
+	}
+}
@@ -0,0 +1,370 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+/*
+Package reputation tags unified2 events whose addresses are on local
+lists of IP addresses and networks, such as blocklists and watchlists.
+
+Lists are plain text files with one address or CIDR network per line,
+where # starts a comment, or CSV files with the address or network in
+the first column.  Lists are reloaded when their files change, without
+interrupting lookups.
+*/
+package reputation
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jasonish/go-unified2"
+)
+
+// The name of the enrichment added to events.
+const ENRICHMENT_NAME = "reputation"
+
+// Enrichment is the enrichment added to an event: the names of the
+// lists each of its addresses are on.
+type Enrichment struct {
+	Source      []string `json:"src,omitempty"`
+	Destination []string `json:"dest,omitempty"`
+	Xff         []string `json:"xff,omitempty"`
+}
+
+type node struct {
+	children [2]*node
+	names    []string
+}
+
+// Tree is a binary prefix tree mapping networks to the names of the
+// lists they are on.  IPv4 networks are stored as IPv4-mapped IPv6
+// networks so one tree holds both.
+type Tree struct {
+	root node
+}
+
+// NewTree creates an empty Tree.
+func NewTree() *Tree {
+	return &Tree{}
+}
+
+// Insert adds a network to the tree under name.
+func (t *Tree) Insert(network *net.IPNet, name string) {
+	ip := network.IP.To16()
+	ones, bits := network.Mask.Size()
+	if ip == nil || bits == 0 {
+		return
+	}
+	if bits == 8*net.IPv4len {
+		ones += 96
+	}
+
+	current := &t.root
+	for i := 0; i < ones; i++ {
+		bit := ip[i/8] >> (7 - uint(i%8)) & 1
+		if current.children[bit] == nil {
+			current.children[bit] = &node{}
+		}
+		current = current.children[bit]
+	}
+	for _, existing := range current.names {
+		if existing == name {
+			return
+		}
+	}
+	current.names = append(current.names, name)
+}
+
+// Lookup returns the sorted names of the lists holding a network that
+// contains ip, or nil.
+func (t *Tree) Lookup(ip net.IP) []string {
+	ip = ip.To16()
+	if ip == nil {
+		return nil
+	}
+
+	var names []string
+	current := &t.root
+	for i := 0; current != nil; i++ {
+		for _, name := range current.names {
+			names = appendName(names, name)
+		}
+		if i == 8*net.IPv6len {
+			break
+		}
+		current = current.children[ip[i/8]>>(7-uint(i%8))&1]
+	}
+	sort.Strings(names)
+	return names
+}
+
+func appendName(names []string, name string) []string {
+	for _, existing := range names {
+		if existing == name {
+			return names
+		}
+	}
+	return append(names, name)
+}
+
+// parseNetwork parses an address or CIDR network.
+func parseNetwork(value string) (*net.IPNet, error) {
+	if strings.Contains(value, "/") {
+		_, network, err := net.ParseCIDR(value)
+		return network, err
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address: %s", value)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// LoadText adds the networks of a plain text list to the tree under
+// name.
+func (t *Tree) LoadText(reader io.Reader, name string) error {
+	scanner := bufio.NewScanner(reader)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		network, err := parseNetwork(fields[0])
+		if err != nil {
+			return fmt.Errorf("line %d: %s", line, err)
+		}
+		t.Insert(network, name)
+	}
+	return scanner.Err()
+}
+
+// LoadCSV adds the networks in the first column of a CSV list to the
+// tree under name.  A first row that is not an address is taken to be
+// a header.
+func (t *Tree) LoadCSV(reader io.Reader, name string) error {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.Comment = '#'
+	for row := 1; ; row++ {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		value := strings.TrimSpace(record[0])
+		if value == "" {
+			continue
+		}
+		network, err := parseNetwork(value)
+		if err != nil {
+			if row == 1 {
+				continue
+			}
+			return fmt.Errorf("row %d: %s", row, err)
+		}
+		t.Insert(network, name)
+	}
+}
+
+// List is a list file.  Files ending in .csv are read as CSV, all
+// others as plain text.
+type List struct {
+	Name     string
+	Filename string
+}
+
+// load adds the list to tree, returning the state of the file it was
+// loaded from.
+func (l List) load(tree *Tree) (os.FileInfo, error) {
+	file, err := os.Open(l.Filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(strings.ToLower(l.Filename), ".csv") {
+		err = tree.LoadCSV(file, l.Name)
+	} else {
+		err = tree.LoadText(file, l.Name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", l.Filename, err)
+	}
+	return info, nil
+}
+
+// Watchlist tags events whose addresses are on any of a set of lists.
+// It is safe for concurrent use.
+type Watchlist struct {
+	// ReloadInterval is how often to check if the list files have
+	// changed.  Defaults to 1 minute, a negative value disables
+	// reloading.
+	ReloadInterval time.Duration
+
+	// Errors, if set, is called with errors reloading the lists.
+	Errors func(err error)
+
+	lists []List
+
+	lock      sync.RWMutex
+	tree      *Tree
+	files     []os.FileInfo
+	checkLock sync.Mutex
+	lastCheck time.Time
+}
+
+// New creates a Watchlist from lists, returning an error if any of
+// them can not be loaded.
+func New(lists ...List) (*Watchlist, error) {
+	w := &Watchlist{lists: lists}
+	tree, files, err := w.load()
+	if err != nil {
+		return nil, err
+	}
+	w.tree, w.files, w.lastCheck = tree, files, time.Now()
+	return w, nil
+}
+
+func (w *Watchlist) load() (*Tree, []os.FileInfo, error) {
+	tree := NewTree()
+	files := make([]os.FileInfo, len(w.lists))
+	for i, list := range w.lists {
+		info, err := list.load(tree)
+		if err != nil {
+			return nil, nil, err
+		}
+		files[i] = info
+	}
+	return tree, files, nil
+}
+
+// changed returns true if any of the list files have changed since
+// they were loaded.
+func (w *Watchlist) changed() bool {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	for i, list := range w.lists {
+		info, err := os.Stat(list.Filename)
+		if err != nil {
+			// Keep the loaded list while the file is being replaced.
+			continue
+		}
+		if !info.ModTime().Equal(w.files[i].ModTime()) ||
+			info.Size() != w.files[i].Size() {
+			return true
+		}
+	}
+	return false
+}
+
+// Reload reloads the lists if any of their files have changed.  The
+// lists are loaded into a new tree which replaces the old one once
+// complete, so lookups continue during the reload.  If a list can not
+// be loaded the old lists stay in use and the error is returned.
+func (w *Watchlist) Reload() error {
+	if !w.changed() {
+		return nil
+	}
+	tree, files, err := w.load()
+	if err != nil {
+		return err
+	}
+	w.lock.Lock()
+	w.tree, w.files = tree, files
+	w.lock.Unlock()
+	return nil
+}
+
+// checkReload reloads the lists if ReloadInterval has passed since
+// they were last checked.
+func (w *Watchlist) checkReload() {
+	interval := w.ReloadInterval
+	if interval == 0 {
+		interval = time.Minute
+	} else if interval < 0 {
+		return
+	}
+
+	w.checkLock.Lock()
+	if time.Since(w.lastCheck) < interval {
+		w.checkLock.Unlock()
+		return
+	}
+	w.lastCheck = time.Now()
+	w.checkLock.Unlock()
+
+	if err := w.Reload(); err != nil && w.Errors != nil {
+		w.Errors(err)
+	}
+}
+
+// Lookup returns the sorted names of the lists ip is on, or nil.
+func (w *Watchlist) Lookup(ip net.IP) []string {
+	if ip == nil {
+		return nil
+	}
+	w.checkReload()
+	w.lock.RLock()
+	tree := w.tree
+	w.lock.RUnlock()
+	return tree.Lookup(ip)
+}
+
+// Enrich adds an Enrichment naming the lists the source, destination
+// and X-Forwarded-For addresses of an event are on.  Nothing is added
+// if none of the addresses are on a list.
+func (w *Watchlist) Enrich(event *unified2.AggregatedEvent) {
+	enrichment := &Enrichment{
+		Source:      w.Lookup(event.Event.IpSource),
+		Destination: w.Lookup(event.Event.IpDestination),
+		Xff:         w.Lookup(unified2.XffAddress(event.ExtraData)),
+	}
+	if enrichment.Source == nil && enrichment.Destination == nil &&
+		enrichment.Xff == nil {
+		return
+	}
+	event.SetEnrichment(ENRICHMENT_NAME, enrichment)
+}
@@ -0,0 +1,160 @@
+package reputation
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jasonish/go-unified2"
+)
+
+func TestTree(t *testing.T) {
+	tree := NewTree()
+	if err := tree.LoadText(strings.NewReader(`
+# Comment.
+10.0.0.0/8
+10.1.0.0/16 # Trailing comment.
+192.168.1.1
+2001:db8::/32
+`), "internal"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.LoadText(strings.NewReader("10.1.2.3\n2001:db8::1\n"),
+		"bad"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip       string
+		expected []string
+	}{
+		{"10.9.9.9", []string{"internal"}},
+		{"10.1.2.3", []string{"bad", "internal"}},
+		{"10.1.2.4", []string{"internal"}},
+		{"192.168.1.1", []string{"internal"}},
+		{"192.168.1.2", nil},
+		{"11.0.0.1", nil},
+		{"2001:db8::1", []string{"bad", "internal"}},
+		{"2001:db9::1", nil},
+	}
+	for _, test := range tests {
+		names := tree.Lookup(net.ParseIP(test.ip))
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Fatalf("%s: expected %v, got %v", test.ip, test.expected,
+				names)
+		}
+	}
+
+	// 4 byte addresses match too.
+	if names := tree.Lookup(net.ParseIP("10.9.9.9").To4()); len(names) != 1 {
+		t.Fatalf("4 byte address not found: %v", names)
+	}
+
+	err := tree.LoadText(strings.NewReader("10.0.0.1\nnot-an-ip\n"), "x")
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected error on line 2, got %v", err)
+	}
+}
+
+func TestLoadCSV(t *testing.T) {
+	tree := NewTree()
+	if err := tree.LoadCSV(strings.NewReader(
+		"ip,description\n"+
+			"198.51.100.0/24,scanner\n"+
+			"203.0.113.7,\"botnet, c2\"\n"), "blocklist"); err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range []string{"198.51.100.99", "203.0.113.7"} {
+		if names := tree.Lookup(net.ParseIP(ip)); len(names) != 1 {
+			t.Fatalf("%s not found", ip)
+		}
+	}
+
+	err := tree.LoadCSV(strings.NewReader("ip\n10.0.0.1\nbad\n"), "x")
+	if err == nil || !strings.Contains(err.Error(), "row 3") {
+		t.Fatalf("expected error on row 3, got %v", err)
+	}
+}
+
+func TestWatchlist(t *testing.T) {
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	writeFile := func(filename string, content string) {
+		if err := ioutil.WriteFile(filename+".tmp", []byte(content),
+			0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(filename+".tmp", filename); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	blocklist := path.Join(tmpdir, "blocklist.txt")
+	watchlist := path.Join(tmpdir, "watchlist.csv")
+	writeFile(blocklist, "198.51.100.0/24\n")
+	writeFile(watchlist, "address\n203.0.113.7\n")
+
+	lists, err := New(List{"blocklist", blocklist},
+		List{"watchlist", watchlist})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lists.ReloadInterval = time.Nanosecond
+	var reloadErr error
+	lists.Errors = func(err error) {
+		reloadErr = err
+	}
+
+	event := &unified2.AggregatedEvent{
+		Event: &unified2.EventRecord{
+			IpSource:      net.ParseIP("198.51.100.1").To4(),
+			IpDestination: net.ParseIP("10.0.0.1").To4(),
+		},
+		ExtraData: []*unified2.ExtraDataRecord{
+			{
+				Type: unified2.EXTRA_DATA_TYPE_XFF_IPV4,
+				Data: net.ParseIP("203.0.113.7").To4(),
+			},
+		},
+	}
+	lists.Enrich(event)
+	expected := &Enrichment{
+		Source: []string{"blocklist"},
+		Xff:    []string{"watchlist"},
+	}
+	if !reflect.DeepEqual(event.Enrichment[ENRICHMENT_NAME], expected) {
+		t.Fatalf("expected %+v, got %+v", expected,
+			event.Enrichment[ENRICHMENT_NAME])
+	}
+
+	// Reloaded when the file changes.
+	writeFile(blocklist, "198.51.100.0/24\n10.0.0.0/8\n")
+	if names := lists.Lookup(net.ParseIP("10.0.0.1")); !reflect.DeepEqual(
+		names, []string{"blocklist"}) {
+		t.Fatalf("list not reloaded: %v", names)
+	}
+
+	// A broken list is reported and the old lists kept.
+	writeFile(watchlist, "address\nbad\n")
+	if names := lists.Lookup(net.ParseIP("203.0.113.7")); !reflect.DeepEqual(
+		names, []string{"watchlist"}) {
+		t.Fatalf("old list not kept: %v", names)
+	}
+	if reloadErr == nil {
+		t.Fatal("expected reload error")
+	}
+
+	if _, err := New(List{"missing", path.Join(tmpdir, "missing")}); err == nil {
+		t.Fatal("expected error for missing list")
+	}
+}
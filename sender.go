@@ -0,0 +1,292 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// Sender streams the records of a spool to a Receiver over TCP,
+// optionally with TLS.  The spool position is only checkpointed once
+// the receiver has acknowledged records, and after a reconnect sending
+// resumes from the first unacknowledged record, so records may be
+// delivered more than once but are never lost.
+type Sender struct {
+	Reader *SpoolRecordReader
+
+	// Network and Address of the receiver.  Network defaults to
+	// "tcp".
+	Network string
+	Address string
+
+	// Sensor is the name identifying this sensor to the receiver.
+	// See ValidSensorName.
+	Sensor string
+
+	// TLSConfig, if set, enables TLS.
+	TLSConfig *tls.Config
+
+	// BookmarkFilename, if set, is where the position after the last
+	// acknowledged record is saved.  If the file exists when Run is
+	// called reading resumes from the saved position.
+	BookmarkFilename string
+
+	// Window is the maximum number of records sent but not yet
+	// acknowledged.  Defaults to 1000.
+	Window int
+
+	// PollInterval is how long to wait for new records when the end of
+	// the spool has been reached.  Defaults to 100 milliseconds.
+	PollInterval time.Duration
+
+	// ReconnectInterval is how long to wait before reconnecting.
+	// Defaults to 1 second.
+	ReconnectInterval time.Duration
+
+	// Checkpoint, if set, is called with the position after each
+	// acknowledgement.
+	Checkpoint func(bookmark *Bookmark)
+
+	// Errors, if set, is called with connection errors and errors
+	// reading the spool.
+	Errors func(err error)
+
+	seq     uint64
+	pending []*sentRecord
+}
+
+// sentRecord tracks a sent record until it is acknowledged.
+type sentRecord struct {
+	seq uint64
+
+	// The spool positions of the start and end of the record.
+	start Bookmark
+	end   Bookmark
+}
+
+func (s *Sender) error(err error) {
+	if s.Errors != nil {
+		s.Errors(err)
+	}
+}
+
+// Run sends records until stop is closed.  An error is returned if the
+// sensor name is not valid or the bookmark could not be read or
+// written.
+func (s *Sender) Run(stop <-chan bool) error {
+	if s.Network == "" {
+		s.Network = "tcp"
+	}
+	if s.Window == 0 {
+		s.Window = 1000
+	}
+	if s.PollInterval == 0 {
+		s.PollInterval = 100 * time.Millisecond
+	}
+	if s.ReconnectInterval == 0 {
+		s.ReconnectInterval = time.Second
+	}
+
+	if !ValidSensorName(s.Sensor) {
+		return fmt.Errorf("invalid sensor name %q", s.Sensor)
+	}
+
+	if s.BookmarkFilename != "" {
+		bookmark, err := ReadBookmark(s.BookmarkFilename)
+		if err == nil {
+			if err := s.Reader.SetOffset(bookmark.Filename,
+				bookmark.Offset); err != nil {
+				return err
+			}
+			s.Reader.Commit(bookmark.Filename, bookmark.Offset)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	for {
+		conn, err := s.dial()
+		if err == nil {
+			var fatal error
+			err, fatal = s.session(conn, stop)
+			conn.Close()
+			if fatal != nil {
+				return fatal
+			}
+
+			// Resend whatever was not acknowledged.
+			if len(s.pending) > 0 {
+				start := s.pending[0].start
+				s.pending = nil
+				if err := s.Reader.SetOffset(start.Filename,
+					start.Offset); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err == nil {
+			// Stopped.
+			return nil
+		}
+		s.error(err)
+
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(s.ReconnectInterval):
+		}
+	}
+}
+
+func (s *Sender) dial() (net.Conn, error) {
+	if s.TLSConfig != nil {
+		return tls.Dial(s.Network, s.Address, s.TLSConfig)
+	}
+	return net.Dial(s.Network, s.Address)
+}
+
+// session sends records over a connection until stop is closed or an
+// error occurs.  Returns the connection error, or a fatal error if the
+// bookmark could not be written.  Both are nil if stopped.
+func (s *Sender) session(conn net.Conn, stop <-chan bool) (err error, fatal error) {
+	writer := bufio.NewWriter(conn)
+	if err := writeHello(writer, s.Sensor); err != nil {
+		return err, nil
+	}
+	if err := writer.Flush(); err != nil {
+		return err, nil
+	}
+
+	acks := make(chan uint64, 16)
+	ackErrors := make(chan error, 1)
+	done := make(chan bool)
+	defer close(done)
+
+	go func() {
+		reader := bufio.NewReader(conn)
+		for {
+			f, err := readFrame(reader)
+			var seq uint64
+			if err == nil {
+				seq, err = parseAck(f)
+			}
+			if err != nil {
+				ackErrors <- err
+				return
+			}
+			select {
+			case acks <- seq:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// A closed channel, to check for acknowledgements without
+	// blocking.
+	ready := make(chan time.Time)
+	close(ready)
+
+	for {
+		// Wait on nothing but acknowledgements when the window is
+		// full.
+		var wait <-chan time.Time
+
+		if len(s.pending) < s.Window {
+			record, err := s.Reader.NextRaw()
+			if err != nil && err != io.EOF {
+				s.error(err)
+			}
+			if record != nil {
+				filename, offset := s.Reader.Offset()
+				s.seq++
+				s.pending = append(s.pending, &sentRecord{
+					seq: s.seq,
+					start: Bookmark{filename,
+						offset - int64(len(record.Data)) - 8},
+					end: Bookmark{filename, offset},
+				})
+				if err := writeRecord(writer, s.seq, record); err != nil {
+					return err, nil
+				}
+				wait = ready
+			} else {
+				wait = time.After(s.PollInterval)
+			}
+		}
+
+		if wait != ready {
+			if err := writer.Flush(); err != nil {
+				return err, nil
+			}
+		}
+
+		select {
+		case <-stop:
+			return nil, nil
+		case seq := <-acks:
+			if err := s.acknowledge(seq); err != nil {
+				return nil, err
+			}
+		case err := <-ackErrors:
+			return err, nil
+		case <-wait:
+		}
+	}
+}
+
+// acknowledge releases the records up to and including seq and
+// checkpoints the position following them.
+func (s *Sender) acknowledge(seq uint64) error {
+	var last *sentRecord
+	for len(s.pending) > 0 && s.pending[0].seq <= seq {
+		last = s.pending[0]
+		s.pending = s.pending[1:]
+	}
+	if last == nil {
+		return nil
+	}
+
+	bookmark := last.end
+	s.Reader.Commit(bookmark.Filename, bookmark.Offset)
+	if s.BookmarkFilename != "" {
+		if err := WriteBookmark(s.BookmarkFilename, &bookmark); err != nil {
+			return err
+		}
+	}
+	if s.Checkpoint != nil {
+		s.Checkpoint(&bookmark)
+	}
+	return nil
+}
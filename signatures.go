@@ -0,0 +1,230 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Signature holds the details of a rule, as loaded from a Snort
+// sid-msg.map or gen-msg.map file.
+type Signature struct {
+	GeneratorId uint32
+	SignatureId uint32
+	Revision    uint32
+	Msg         string
+	Classtype   string
+	Priority    uint32
+
+	// References in rule format, such as "url,www.example.com".
+	References []string
+}
+
+// SignatureMap maps generator and signature ids to signatures.
+//
+// SignatureMaps should be created with NewSignatureMap().
+type SignatureMap struct {
+	signatures map[[2]uint32]*Signature
+}
+
+// NewSignatureMap creates a new empty SignatureMap.
+func NewSignatureMap() *SignatureMap {
+	return &SignatureMap{map[[2]uint32]*Signature{}}
+}
+
+// Lookup returns the signature for the generator and signature id, or
+// nil if not known.  A generator id of 0 is treated as 1, the
+// generator id of text rules.
+func (m *SignatureMap) Lookup(generatorId uint32, signatureId uint32) *Signature {
+	if generatorId == 0 {
+		generatorId = 1
+	}
+	return m.signatures[[2]uint32{generatorId, signatureId}]
+}
+
+// Add adds a signature, replacing any with the same ids.
+func (m *SignatureMap) Add(signature *Signature) {
+	m.signatures[[2]uint32{signature.GeneratorId,
+		signature.SignatureId}] = signature
+}
+
+// splitMapLine splits a line of a map file into its "||" separated
+// fields, returning nil for blank lines and comments.
+func splitMapLine(line string) []string {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	fields := strings.Split(line, "||")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	return fields
+}
+
+func parseMapUint(field string, lineno int) (uint32, error) {
+	value, err := strconv.ParseUint(field, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("line %d: bad number %q", lineno, field)
+	}
+	return uint32(value), nil
+}
+
+// LoadSidMsgMap loads signatures from a Snort sid-msg.map.  Both the
+// original "sid || msg || references" format and the "#v2" format of
+// "gid || sid || rev || classtype || priority || msg || references"
+// are supported.
+func (m *SignatureMap) LoadSidMsgMap(reader io.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	v2 := false
+	lineno := 0
+
+	for scanner.Scan() {
+		lineno++
+		if strings.TrimSpace(scanner.Text()) == "#v2" {
+			v2 = true
+			continue
+		}
+		fields := splitMapLine(scanner.Text())
+		if fields == nil {
+			continue
+		}
+
+		signature := &Signature{GeneratorId: 1}
+		var err error
+
+		if v2 {
+			if len(fields) < 6 {
+				return fmt.Errorf("line %d: expected at least 6 fields",
+					lineno)
+			}
+			if signature.GeneratorId, err = parseMapUint(fields[0], lineno); err != nil {
+				return err
+			}
+			if signature.SignatureId, err = parseMapUint(fields[1], lineno); err != nil {
+				return err
+			}
+			if signature.Revision, err = parseMapUint(fields[2], lineno); err != nil {
+				return err
+			}
+			signature.Classtype = fields[3]
+			if signature.Priority, err = parseMapUint(fields[4], lineno); err != nil {
+				return err
+			}
+			signature.Msg = fields[5]
+			signature.References = fields[6:]
+		} else {
+			if len(fields) < 2 {
+				return fmt.Errorf("line %d: expected at least 2 fields",
+					lineno)
+			}
+			if signature.SignatureId, err = parseMapUint(fields[0], lineno); err != nil {
+				return err
+			}
+			signature.Msg = fields[1]
+			signature.References = fields[2:]
+		}
+
+		m.Add(signature)
+	}
+
+	return scanner.Err()
+}
+
+// LoadGenMsgMap loads signatures from a Snort gen-msg.map of the
+// format "gid || sid || msg".  Signatures already loaded from a
+// sid-msg.map are not replaced.
+func (m *SignatureMap) LoadGenMsgMap(reader io.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	lineno := 0
+
+	for scanner.Scan() {
+		lineno++
+		fields := splitMapLine(scanner.Text())
+		if fields == nil {
+			continue
+		}
+		if len(fields) < 3 {
+			return fmt.Errorf("line %d: expected 3 fields", lineno)
+		}
+
+		signature := &Signature{Msg: fields[2]}
+		var err error
+		if signature.GeneratorId, err = parseMapUint(fields[0], lineno); err != nil {
+			return err
+		}
+		if signature.SignatureId, err = parseMapUint(fields[1], lineno); err != nil {
+			return err
+		}
+
+		if m.Lookup(signature.GeneratorId, signature.SignatureId) == nil {
+			m.Add(signature)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Base URLs of the reference systems in Snort's reference.config.
+var referenceURLs = map[string]string{
+	"bugtraq":   "http://www.securityfocus.com/bid/",
+	"cve":       "http://cve.mitre.org/cgi-bin/cvename.cgi?name=",
+	"arachnids": "http://www.whitehats.com/info/IDS",
+	"osvdb":     "http://osvdb.org/show/osvdb/",
+	"mcafee":    "http://vil.nai.com/vil/content/v_",
+	"nessus":    "http://cgi.nessus.org/plugins/dump.php3?id=",
+	"url":       "http://",
+	"msb":       "http://technet.microsoft.com/en-us/security/bulletin/",
+}
+
+// ParseReference splits a reference in rule format, such as
+// "cve,2003-0001", into its system and id.
+func ParseReference(reference string) (system string, id string) {
+	parts := strings.SplitN(reference, ",", 2)
+	if len(parts) < 2 {
+		return "", strings.TrimSpace(reference)
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+}
+
+// ReferenceURL returns a URL for a reference of a system known to
+// Snort, or an empty string if the system is not known.
+func ReferenceURL(system string, id string) string {
+	if base, ok := referenceURLs[strings.ToLower(system)]; ok {
+		if strings.ToLower(system) == "url" &&
+			(strings.HasPrefix(id, "http://") ||
+				strings.HasPrefix(id, "https://")) {
+			return id
+		}
+		return base + id
+	}
+	return ""
+}
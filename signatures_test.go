@@ -0,0 +1,80 @@
+package unified2
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSignatureMap(t *testing.T) {
+
+	signatures := NewSignatureMap()
+
+	err := signatures.LoadSidMsgMap(strings.NewReader(`
+# A comment.
+2000001 || ET TEST Message || url,www.example.com || cve,2003-0001
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = signatures.LoadSidMsgMap(strings.NewReader(`#v2
+1 || 2000002 || 3 || trojan-activity || 1 || ET TEST Message 2 || url,www.example.org
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = signatures.LoadGenMsgMap(strings.NewReader(`
+120 || 3 || (http_inspect) NO CONTENT-LENGTH OR TRANSFER-ENCODING IN HTTP RESPONSE
+1 || 2000001 || should not replace sid-msg.map entry
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature := signatures.Lookup(0, 2000001)
+	if signature == nil || signature.Msg != "ET TEST Message" ||
+		len(signature.References) != 2 ||
+		signature.References[1] != "cve,2003-0001" {
+		t.Fatalf("unexpected signature: %+v", signature)
+	}
+
+	signature = signatures.Lookup(1, 2000002)
+	if signature == nil || signature.Revision != 3 ||
+		signature.Classtype != "trojan-activity" ||
+		signature.Priority != 1 || signature.Msg != "ET TEST Message 2" {
+		t.Fatalf("unexpected signature: %+v", signature)
+	}
+
+	signature = signatures.Lookup(120, 3)
+	if signature == nil || !strings.HasPrefix(signature.Msg, "(http_inspect)") {
+		t.Fatalf("unexpected signature: %+v", signature)
+	}
+
+	if signatures.Lookup(1, 1) != nil {
+		t.Fatal("expected nil signature")
+	}
+
+	err = signatures.LoadSidMsgMap(strings.NewReader("bad || msg\n"))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestReferences(t *testing.T) {
+	system, id := ParseReference("cve,2003-0001")
+	if system != "cve" || id != "2003-0001" {
+		t.Fatalf("unexpected reference %s %s", system, id)
+	}
+	if url := ReferenceURL(system, id); url !=
+		"http://cve.mitre.org/cgi-bin/cvename.cgi?name=2003-0001" {
+		t.Fatalf("unexpected url %s", url)
+	}
+	if url := ReferenceURL(ParseReference("url,www.example.com/a,b")); url !=
+		"http://www.example.com/a,b" {
+		t.Fatalf("unexpected url %s", url)
+	}
+	if url := ReferenceURL(ParseReference("unknown,1")); url != "" {
+		t.Fatalf("unexpected url %s", url)
+	}
+}
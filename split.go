@@ -0,0 +1,221 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// SplitKey selects how Split partitions records between outputs.
+type SplitKey int
+
+const (
+	// Do not partition records, all records go to the same spool.
+	SplitByNone SplitKey = iota
+
+	// Partition records by the sensor id of the event.
+	SplitBySensor
+
+	// Partition records by the signature id of the event.
+	SplitBySignature
+)
+
+// SplitOptions control how Split divides its input.
+type SplitOptions struct {
+	// Directory is where output files are written.
+	Directory string
+
+	// Prefix is the filename prefix of output files.  Files are
+	// named Prefix followed by a "." and a timestamp, like unified2
+	// spool files written by Snort.
+	Prefix string
+
+	// By partitions records by sensor id or signature id.  Each
+	// partition is written to its own spool in a sub-directory of
+	// Directory named "sensor-<id>" or "sid-<id>".
+	By SplitKey
+
+	// Interval starts a new file for each interval of event time,
+	// such as time.Hour or 24 * time.Hour, named with the time the
+	// interval starts.  Zero disables time based splitting.
+	Interval time.Duration
+
+	// MaxSize starts a new file when writing the next event would
+	// make the current file larger than MaxSize bytes.  Zero disables
+	// size based splitting.
+	MaxSize int64
+}
+
+type splitOutput struct {
+	file   *os.File
+	size   int64
+	bucket int64
+}
+
+// Split reads unified2 records from input and writes them into
+// multiple files as described by options.  Each event is kept in the
+// same file as the packet and extra data records that follow it.  The
+// resulting files can be read back with a SpoolRecordReader.
+//
+// The names of the files written are returned.
+func Split(input io.ReadWriteSeeker, options SplitOptions) ([]string, error) {
+	outputs := map[string]*splitOutput{}
+	created := map[string]bool{}
+	filenames := []string{}
+
+	defer func() {
+		for _, output := range outputs {
+			output.file.Close()
+		}
+	}()
+
+	reader := NewEventGroupReader(input)
+
+	for {
+		group, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return filenames, err
+		}
+
+		directory, err := splitDirectory(group, options)
+		if err != nil {
+			return filenames, err
+		}
+
+		var size int64
+		for _, record := range group.Records {
+			size += int64(len(record.Data)) + 8
+		}
+
+		second := int64(group.Time / 1000000)
+		bucket := second
+		if interval := int64(options.Interval / time.Second); interval > 0 {
+			bucket = second - second%interval
+		}
+
+		output := outputs[directory]
+		if output != nil {
+			if (options.Interval > 0 && bucket != output.bucket) ||
+				(options.MaxSize > 0 && output.size > 0 &&
+					output.size+size > options.MaxSize) {
+				if err := output.file.Close(); err != nil {
+					return filenames, err
+				}
+				delete(outputs, directory)
+				output = nil
+			}
+		}
+
+		if output == nil {
+			if err := os.MkdirAll(directory, 0755); err != nil {
+				return filenames, err
+			}
+
+			// Find an unused name, as size based splitting may
+			// start several files in the same second.
+			var filename string
+			for timestamp := bucket; ; timestamp++ {
+				filename = path.Join(directory,
+					fmt.Sprintf("%s.%d", options.Prefix, timestamp))
+				if _, err := os.Stat(filename); created[filename] ||
+					!os.IsNotExist(err) {
+					continue
+				}
+				break
+			}
+
+			file, err := os.Create(filename)
+			if err != nil {
+				return filenames, err
+			}
+			created[filename] = true
+			filenames = append(filenames, filename)
+			output = &splitOutput{file: file, bucket: bucket}
+			outputs[directory] = output
+		}
+
+		for _, record := range group.Records {
+			if err := WriteRawRecord(output.file, record); err != nil {
+				return filenames, err
+			}
+		}
+		output.size += size
+	}
+
+	for directory, output := range outputs {
+		delete(outputs, directory)
+		if err := output.file.Close(); err != nil {
+			return filenames, err
+		}
+	}
+
+	return filenames, nil
+}
+
+// splitDirectory returns the directory the group is to be written to.
+func splitDirectory(group *EventGroup, options SplitOptions) (string, error) {
+	if options.By == SplitByNone {
+		return options.Directory, nil
+	}
+
+	var sensorId, signatureId uint32
+
+	record := group.Records[0]
+	switch {
+	case isEventType(record.Type):
+		event, err := DecodeEventRecord(record.Type, record.Data)
+		if err != nil {
+			return "", err
+		}
+		sensorId, signatureId = event.SensorId, event.SignatureId
+	case isPacketType(record.Type):
+		packet, err := DecodePacketRecord(record.Data)
+		if err != nil {
+			return "", err
+		}
+		sensorId = packet.SensorId
+	case record.Type == UNIFIED2_EXTRA_DATA:
+		extra, err := DecodeExtraDataRecord(record.Data)
+		if err != nil {
+			return "", err
+		}
+		sensorId = extra.SensorId
+	}
+
+	if options.By == SplitBySensor {
+		return path.Join(options.Directory,
+			fmt.Sprintf("sensor-%d", sensorId)), nil
+	}
+	return path.Join(options.Directory,
+		fmt.Sprintf("sid-%d", signatureId)), nil
+}
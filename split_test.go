@@ -0,0 +1,108 @@
+package unified2
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// Count the events in a spool.
+func countSpoolEvents(t *testing.T, directory string, prefix string) int {
+	reader := NewSpoolRecordReader(directory, prefix)
+	defer reader.Close()
+	events := 0
+	for {
+		record, err := reader.Next()
+		if err == io.EOF || (err == nil && record == nil) {
+			return events
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := record.(*EventRecord); ok {
+			events++
+		}
+	}
+}
+
+func TestSplitByInterval(t *testing.T) {
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	writeTestFile(t, tmpdir+"/input.log", []uint32{3600, 3700, 7300, 7400, 7500})
+	input, err := os.Open(tmpdir + "/input.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	filenames, err := Split(input, SplitOptions{
+		Directory: tmpdir + "/split",
+		Prefix:    "unified2.log",
+		Interval:  time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filenames) != 2 {
+		t.Fatalf("expected 2 files, got %v", filenames)
+	}
+	if path.Base(filenames[0]) != "unified2.log.3600" ||
+		path.Base(filenames[1]) != "unified2.log.7200" {
+		t.Fatalf("unexpected filenames %v", filenames)
+	}
+
+	if events := countSpoolEvents(t, tmpdir+"/split", "unified2.log"); events != 5 {
+		t.Fatalf("expected 5 events, got %d", events)
+	}
+}
+
+func TestSplitByMaxSize(t *testing.T) {
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	writeTestFile(t, tmpdir+"/input.log", []uint32{100, 100, 100})
+	input, err := os.Open(tmpdir + "/input.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	// Small enough that each event gets its own file.
+	filenames, err := Split(input, SplitOptions{
+		Directory: tmpdir + "/split",
+		Prefix:    "unified2.log",
+		By:        SplitBySignature,
+		MaxSize:   1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filenames) != 3 {
+		t.Fatalf("expected 3 files, got %v", filenames)
+	}
+	for i, filename := range filenames {
+		expected := []string{"unified2.log.100", "unified2.log.101",
+			"unified2.log.102"}[i]
+		if path.Base(filename) != expected {
+			t.Fatalf("expected %s, got %s", expected, filename)
+		}
+		if path.Base(path.Dir(filename)) != "sid-3" {
+			t.Fatalf("unexpected directory for %s", filename)
+		}
+	}
+
+	if events := countSpoolEvents(t, tmpdir+"/split/sid-3", "unified2.log"); events != 3 {
+		t.Fatalf("expected 3 events, got %d", events)
+	}
+}
@@ -0,0 +1,261 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// SpoolBatcher reads events from a SpoolRecordReader, aggregates them
+// with their packets and extra data, and passes them in batches to
+// Send.  The spool position is only checkpointed once Send has
+// returned successfully for a batch, so an event is never lost: after
+// a restart any unacknowledged events are read again.
+type SpoolBatcher struct {
+	Reader *SpoolRecordReader
+
+	// Send delivers a batch of events.  A batch is retried until Send
+	// succeeds or the batcher is stopped, unless Send returns a
+	// *PermanentError, in which case the batch is dropped.
+	Send func(events []*AggregatedEvent) error
+
+	// Filter, if set, is called with each event and events it returns
+	// false for are dropped, such as by a Thresholder.  Dropped events
+	// are checkpointed like sent ones.
+	Filter func(event *AggregatedEvent) bool
+
+	// Enrichers, if set, are applied in order to each event before it
+	// is added to a batch.
+	Enrichers []Enricher
+
+	// BatchSize is the maximum number of events in a batch.  Defaults
+	// to 100.
+	BatchSize int
+
+	// FlushInterval is the maximum time an event waits in a partial
+	// batch.  Defaults to 1 second.
+	FlushInterval time.Duration
+
+	// PollInterval is how long to wait for new records when the end of
+	// the spool has been reached.  Defaults to 100 milliseconds.
+	PollInterval time.Duration
+
+	// RetryInterval is how long to wait before retrying a failed
+	// batch.  Defaults to 1 second.
+	RetryInterval time.Duration
+
+	// BookmarkFilename, if set, is where the position after the last
+	// acknowledged batch is saved.  If the file exists when Run is
+	// called reading resumes from the saved position.
+	BookmarkFilename string
+
+	// Checkpoint, if set, is called with the position after each
+	// acknowledged batch.
+	Checkpoint func(bookmark *Bookmark)
+
+	// Errors, if set, is called with errors from Send and from
+	// reading the spool.
+	Errors func(err error)
+
+	// IdleHook, if set, is called when there is nothing new to read
+	// from the spool.
+	IdleHook func()
+
+	aggregator EventAggregator
+	batch      []*AggregatedEvent
+	batchStart time.Time
+
+	// Set if events have been filtered out since the last checkpoint.
+	filtered bool
+
+	// The position following the last event added to the batch.
+	position Bookmark
+}
+
+// PermanentError is returned by the Send function of a SpoolBatcher for
+// a batch that retrying will not deliver, such as one the server
+// refuses as unauthorized.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (b *SpoolBatcher) error(err error) {
+	if b.Errors != nil {
+		b.Errors(err)
+	}
+}
+
+// Run reads from the spool until stop is closed, at which point any
+// complete events are sent before returning.  An error is returned if
+// the bookmark could not be read or written.
+func (b *SpoolBatcher) Run(stop <-chan bool) error {
+	if b.BatchSize == 0 {
+		b.BatchSize = 100
+	}
+	if b.FlushInterval == 0 {
+		b.FlushInterval = time.Second
+	}
+	if b.PollInterval == 0 {
+		b.PollInterval = 100 * time.Millisecond
+	}
+	if b.RetryInterval == 0 {
+		b.RetryInterval = time.Second
+	}
+
+	if b.BookmarkFilename != "" {
+		bookmark, err := ReadBookmark(b.BookmarkFilename)
+		if err == nil {
+			if err := b.Reader.SetOffset(bookmark.Filename,
+				bookmark.Offset); err != nil {
+				return err
+			}
+			b.Reader.Commit(bookmark.Filename, bookmark.Offset)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-stop:
+			b.add(b.aggregator.Flush())
+			_, err := b.flush(nil)
+			return err
+		default:
+		}
+
+		filename, offset := b.Reader.Offset()
+		record, err := b.Reader.Next()
+		if err != nil && err != io.EOF {
+			b.error(err)
+		}
+
+		if record != nil {
+			if event := b.aggregator.Add(record); event != nil {
+				b.position = Bookmark{filename, offset}
+				b.add(event)
+			}
+		} else {
+			// Nothing to read right now, so the current event is
+			// as complete as it is going to get.
+			filename, offset := b.Reader.Offset()
+			if event := b.aggregator.Flush(); event != nil {
+				b.position = Bookmark{filename, offset}
+				b.add(event)
+			}
+		}
+
+		if len(b.batch) >= b.BatchSize ||
+			((len(b.batch) > 0 || b.filtered) &&
+				time.Since(b.batchStart) >= b.FlushInterval) {
+			stopped, err := b.flush(stop)
+			if err != nil {
+				return err
+			} else if stopped {
+				return nil
+			}
+		}
+
+		if record == nil {
+			if b.IdleHook != nil {
+				b.IdleHook()
+			}
+			select {
+			case <-stop:
+			case <-time.After(b.PollInterval):
+			}
+		}
+	}
+}
+
+func (b *SpoolBatcher) add(event *AggregatedEvent) {
+	if event == nil {
+		return
+	}
+	if len(b.batch) == 0 && !b.filtered {
+		b.batchStart = time.Now()
+	}
+	if b.Filter != nil && !b.Filter(event) {
+		// Still checkpointed, so the spool files can be released.
+		b.filtered = true
+		return
+	}
+	for _, enricher := range b.Enrichers {
+		enricher.Enrich(event)
+	}
+	b.batch = append(b.batch, event)
+}
+
+// flush sends the current batch, retrying until it is sent, fails
+// permanently or stop is closed, then checkpoints.  Returns true if
+// stopped before the batch was sent.  If stop is nil only one attempt
+// is made.  If only filtered events are pending they are checkpointed
+// without calling Send.
+func (b *SpoolBatcher) flush(stop <-chan bool) (bool, error) {
+	if len(b.batch) == 0 && !b.filtered {
+		return false, nil
+	}
+
+	for len(b.batch) > 0 {
+		err := b.Send(b.batch)
+		if err == nil {
+			break
+		}
+		b.error(err)
+		if _, ok := err.(*PermanentError); ok {
+			break
+		}
+		if stop == nil {
+			return true, nil
+		}
+		select {
+		case <-stop:
+			return true, nil
+		case <-time.After(b.RetryInterval):
+		}
+	}
+
+	b.batch = nil
+	b.filtered = false
+	bookmark := b.position
+	b.Reader.Commit(bookmark.Filename, bookmark.Offset)
+	if b.BookmarkFilename != "" {
+		if err := WriteBookmark(b.BookmarkFilename, &bookmark); err != nil {
+			return false, err
+		}
+	}
+	if b.Checkpoint != nil {
+		b.Checkpoint(&bookmark)
+	}
+	return false, nil
+}
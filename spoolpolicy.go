@@ -0,0 +1,239 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SpoolPolicy is an action applied to a spool file once it has been
+// read to completion, such as deleting, archiving or compressing it.
+//
+// Apply is called with the path of the completed file and returns the
+// path the file can now be found at so the next policy can act on it.
+// An empty path means the file no longer exists.  If an error is
+// returned Apply will be called again later with the same path.
+type SpoolPolicy interface {
+	Apply(filename string) (string, error)
+}
+
+// SpoolPolicyError is the error returned by SpoolRecordReader.Next()
+// when a SpoolPolicy fails.  The policy will be retried.
+type SpoolPolicyError struct {
+	Filename string
+	Err      error
+}
+
+func (e *SpoolPolicyError) Error() string {
+	return fmt.Sprintf("spool policy failed for %s: %s", e.Filename, e.Err)
+}
+
+// DeletePolicy deletes completed spool files.
+type DeletePolicy struct{}
+
+// Apply deletes the file.
+func (p DeletePolicy) Apply(filename string) (string, error) {
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return filename, err
+	}
+	return "", nil
+}
+
+// ArchivePolicy moves completed spool files into Directory.
+type ArchivePolicy struct {
+	Directory string
+}
+
+// Apply moves the file into the archive directory.  If the file can
+// not be renamed, for example when the archive directory is on another
+// file system, it is copied then removed.
+func (p ArchivePolicy) Apply(filename string) (string, error) {
+	dest := path.Join(p.Directory, path.Base(filename))
+	if err := os.Rename(filename, dest); err == nil {
+		return dest, nil
+	}
+	if err := copyFileAtomic(filename, dest, nil); err != nil {
+		return filename, err
+	}
+	if err := os.Remove(filename); err != nil {
+		return filename, err
+	}
+	return dest, nil
+}
+
+// CompressPolicy gzip compresses completed spool files in place,
+// replacing the file with one suffixed with ".gz".
+type CompressPolicy struct {
+	// Level is the gzip compression level.  The zero value uses
+	// gzip.DefaultCompression.
+	Level int
+}
+
+// Apply compresses the file.
+func (p CompressPolicy) Apply(filename string) (string, error) {
+	level := p.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	dest := filename + ".gz"
+	err := copyFileAtomic(filename, dest, func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, level)
+	})
+	if err != nil {
+		return filename, err
+	}
+	if err := os.Remove(filename); err != nil {
+		return filename, err
+	}
+	return dest, nil
+}
+
+// RetentionPolicy removes old files from the directory a completed
+// spool file is in, oldest first, until none are older than MaxAge and
+// their total size is no more than MaxSize.  A zero MaxAge or MaxSize
+// disables that limit.
+//
+// Only files whose name begins with Prefix and sorts at or before the
+// completed file are considered, so files still to be read from a
+// spool directory are never removed.  Prefix is required, and should
+// be the prefix of the spool, so the files of other spools or
+// bookmarks in the same directory are left alone.
+type RetentionPolicy struct {
+	Prefix  string
+	MaxAge  time.Duration
+	MaxSize int64
+}
+
+// MissingRetentionPrefix is the error returned by RetentionPolicy.Apply
+// when Prefix is empty.
+var MissingRetentionPrefix = errors.New("retention policy has no prefix")
+
+// Apply enforces the retention limits.  The returned filename is empty
+// if the completed file itself was removed.
+func (p RetentionPolicy) Apply(filename string) (string, error) {
+	if p.Prefix == "" {
+		return filename, MissingRetentionPrefix
+	}
+
+	directory := path.Dir(filename)
+	base := path.Base(filename)
+
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return filename, err
+	}
+
+	candidates := []os.FileInfo{}
+	var total int64
+	for _, file := range files {
+		if !file.Mode().IsRegular() ||
+			!strings.HasPrefix(file.Name(), p.Prefix) ||
+			file.Name() > base {
+			continue
+		}
+		candidates = append(candidates, file)
+		total += file.Size()
+	}
+	sort.Sort(byName(candidates))
+
+	now := time.Now()
+	for _, file := range candidates {
+		expired := p.MaxAge > 0 && now.Sub(file.ModTime()) > p.MaxAge
+		oversize := p.MaxSize > 0 && total > p.MaxSize
+		if !expired && !oversize {
+			continue
+		}
+		err := os.Remove(path.Join(directory, file.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return filename, err
+		}
+		total -= file.Size()
+		if file.Name() == base {
+			filename = ""
+		}
+	}
+
+	return filename, nil
+}
+
+type byName []os.FileInfo
+
+func (f byName) Len() int           { return len(f) }
+func (f byName) Less(i, j int) bool { return f[i].Name() < f[j].Name() }
+func (f byName) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+
+// copyFileAtomic copies source to dest by way of a temporary file in
+// the destination directory, so dest only ever exists complete.  If
+// wrap is not nil the output is written through the writer it returns.
+func copyFileAtomic(source string, dest string,
+	wrap func(io.Writer) (io.WriteCloser, error)) error {
+
+	src, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := ioutil.TempFile(path.Dir(dest), "."+path.Base(dest)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	var out io.Writer = tmp
+	var wrapper io.WriteCloser
+	if wrap != nil {
+		if wrapper, err = wrap(tmp); err != nil {
+			return err
+		}
+		out = wrapper
+	}
+
+	if _, err := io.Copy(out, src); err != nil {
+		return err
+	}
+	if wrapper != nil {
+		if err := wrapper.Close(); err != nil {
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), dest)
+}
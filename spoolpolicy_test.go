@@ -0,0 +1,265 @@
+package unified2
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// Read records from the spool reader until EOF or another error.
+func readUntilError(reader *SpoolRecordReader) error {
+	for {
+		record, err := reader.Next()
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			return nil
+		}
+	}
+}
+
+func exists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
+func TestSpoolPolicyDelete(t *testing.T) {
+
+	test_filename := "test/multi-record-event.log"
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	first := fmt.Sprintf("%s/merged.log.1382627900", tmpdir)
+	second := fmt.Sprintf("%s/merged.log.1382627901", tmpdir)
+	copyFile(test_filename, first)
+
+	reader := NewSpoolRecordReader(tmpdir, "merged.log")
+	reader.Policies = []SpoolPolicy{DeletePolicy{}}
+
+	if err := readUntilError(reader); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+
+	// The file the reader is positioned in must not be deleted.
+	if !exists(first) {
+		t.Fatal("current file was deleted")
+	}
+
+	copyFile(test_filename, second)
+	if err := readUntilError(reader); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	if exists(first) {
+		t.Fatal("completed file was not deleted")
+	}
+	if !exists(second) {
+		t.Fatal("current file was deleted")
+	}
+}
+
+func TestSpoolPolicyCommit(t *testing.T) {
+
+	test_filename := "test/multi-record-event.log"
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	first := fmt.Sprintf("%s/merged.log.1382627900", tmpdir)
+	copyFile(test_filename, first)
+	copyFile(test_filename, fmt.Sprintf("%s/merged.log.1382627901", tmpdir))
+
+	reader := NewSpoolRecordReader(tmpdir, "merged.log")
+	reader.Policies = []SpoolPolicy{DeletePolicy{}}
+
+	// Commit a position in the first file, then read everything.
+	if _, err := reader.Next(); err != nil {
+		t.Fatal(err)
+	}
+	reader.Commit(reader.Offset())
+	if err := readUntilError(reader); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	if !exists(first) {
+		t.Fatal("file deleted while committed position points into it")
+	}
+
+	// Commit the current position, now in the second file.
+	reader.Commit(reader.Offset())
+	if err := readUntilError(reader); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	if exists(first) {
+		t.Fatal("completed file was not deleted")
+	}
+}
+
+func TestSpoolPolicyCompressArchive(t *testing.T) {
+
+	test_filename := "test/multi-record-event.log"
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	archive := fmt.Sprintf("%s/archive", tmpdir)
+	if err := os.Mkdir(archive, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	copyFile(test_filename, fmt.Sprintf("%s/merged.log.1382627900", tmpdir))
+	copyFile(test_filename, fmt.Sprintf("%s/merged.log.1382627901", tmpdir))
+
+	reader := NewSpoolRecordReader(tmpdir, "merged.log")
+	reader.Policies = []SpoolPolicy{
+		CompressPolicy{},
+		ArchivePolicy{archive},
+	}
+	if err := readUntilError(reader); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+
+	archived := fmt.Sprintf("%s/merged.log.1382627900.gz", archive)
+	file, err := os.Open(archived)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uncompressed, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := ioutil.ReadFile(test_filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(uncompressed) != string(original) {
+		t.Fatal("archived file does not match original")
+	}
+
+	if exists(fmt.Sprintf("%s/merged.log.1382627900", tmpdir)) {
+		t.Fatal("completed file was not moved")
+	}
+}
+
+func TestRetentionPolicy(t *testing.T) {
+
+	test_filename := "test/multi-record-event.log"
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for i := 0; i < 4; i++ {
+		copyFile(test_filename,
+			fmt.Sprintf("%s/merged.log.138262790%d", tmpdir, i))
+	}
+	info, err := os.Stat(test_filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep room for two files.  Files after the completed file must
+	// not be considered.
+	policy := RetentionPolicy{Prefix: "merged.log", MaxSize: info.Size() * 2}
+	filename, err := policy.Apply(fmt.Sprintf("%s/merged.log.1382627902", tmpdir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filename == "" {
+		t.Fatal("completed file should have been retained")
+	}
+	for i, expected := range []bool{false, true, true, true} {
+		if exists(fmt.Sprintf("%s/merged.log.138262790%d", tmpdir, i)) != expected {
+			t.Fatalf("unexpected retention for file %d", i)
+		}
+	}
+
+	// Everything up to the completed file has expired.
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(fmt.Sprintf("%s/merged.log.1382627902", tmpdir), old, old)
+	policy = RetentionPolicy{Prefix: "merged.log", MaxAge: time.Hour}
+	filename, err = policy.Apply(fmt.Sprintf("%s/merged.log.1382627902", tmpdir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filename != "" {
+		t.Fatal("expected completed file to be removed")
+	}
+	if !exists(fmt.Sprintf("%s/merged.log.1382627903", tmpdir)) {
+		t.Fatal("file after completed file was removed")
+	}
+
+	// Without a prefix nothing is removed.
+	policy = RetentionPolicy{MaxAge: time.Nanosecond}
+	filename = fmt.Sprintf("%s/merged.log.1382627903", tmpdir)
+	if _, err := policy.Apply(filename); err != MissingRetentionPrefix {
+		t.Fatalf("expected MissingRetentionPrefix, got %v", err)
+	}
+	if !exists(filename) {
+		t.Fatal("file removed by policy without prefix")
+	}
+}
+
+type failingPolicy struct {
+	failures int
+	calls    int
+}
+
+func (p *failingPolicy) Apply(filename string) (string, error) {
+	p.calls++
+	if p.calls <= p.failures {
+		return filename, errors.New("failed")
+	}
+	return filename, nil
+}
+
+func TestSpoolPolicyRetry(t *testing.T) {
+
+	test_filename := "test/multi-record-event.log"
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	copyFile(test_filename, fmt.Sprintf("%s/merged.log.1382627900", tmpdir))
+	copyFile(test_filename, fmt.Sprintf("%s/merged.log.1382627901", tmpdir))
+
+	policy := &failingPolicy{failures: 1}
+	reader := NewSpoolRecordReader(tmpdir, "merged.log")
+	reader.RetryInterval = time.Nanosecond
+	reader.Policies = []SpoolPolicy{policy}
+
+	err = readUntilError(reader)
+	if _, ok := err.(*SpoolPolicyError); !ok {
+		t.Fatalf("expected *SpoolPolicyError, got %v", err)
+	}
+
+	if err := readUntilError(reader); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	if policy.calls != 2 {
+		t.Fatalf("expected 2 calls, got %d", policy.calls)
+	}
+}
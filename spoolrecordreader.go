@@ -33,6 +33,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 )
 
 // SpoolRecordReader is a unified2 record reader that reads from a
@@ -48,10 +49,52 @@ type SpoolRecordReader struct {
 	// to delete or archive the file.
 	CloseHook func(string)
 
+	// Policies are applied in order to each spool file after it has
+	// been read to completion.  A file is not acted upon until the
+	// reader has moved on to a later file and, if Commit has been
+	// called, the committed position has also moved past it.
+	Policies []SpoolPolicy
+
+	// PartialRecordTimeout enables skipping an incomplete record at
+	// the end of a file that is not the latest in the spool, such as
+	// is left behind when a sensor crashes.  Once reading has been
+	// stalled on the record for this long, the reader moves on to the
+	// next file.  Zero disables skipping.
+	PartialRecordTimeout time.Duration
+
+	// RetryInterval is how long to wait before retrying a failed
+	// policy.  Defaults to 10 seconds.
+	RetryInterval time.Duration
+
 	directory string
 	prefix    string
 	logger    *log.Logger
 	reader    *RecordReader
+
+	// Position and time first seen of an incomplete record.
+	partialOffset int64
+	partialSince  time.Time
+
+	// Filename of the last committed position, if any.
+	committed string
+
+	// Completed files waiting to have policies applied.
+	completed []*completedFile
+}
+
+// completedFile tracks a completed spool file through its policies.
+type completedFile struct {
+	// The spool file name the file was read under.
+	name string
+
+	// The current path of the file, as returned by the last policy.
+	filename string
+
+	// Index of the next policy to apply.
+	policy int
+
+	// Earliest time the next policy may be attempted.
+	retry time.Time
 }
 
 // NewSpoolRecordReader creates a new RecordSpoolReader reading files
@@ -143,8 +186,17 @@ func (r *SpoolRecordReader) openNext() bool {
 		if r.CloseHook != nil {
 			r.CloseHook(r.reader.Name())
 		}
+
+		if len(r.Policies) > 0 {
+			r.completed = append(r.completed, &completedFile{
+				name:     path.Base(r.reader.Name()),
+				filename: r.reader.Name(),
+			})
+		}
 	}
 
+	r.partialSince = time.Time{}
+
 	r.log("Opening file %s", nextFilename)
 	r.reader, err = NewRecordReader(nextFilename, 0)
 	if err != nil {
@@ -156,6 +208,32 @@ func (r *SpoolRecordReader) openNext() bool {
 
 // Next returns the next record read from the spool.
 func (r *SpoolRecordReader) Next() (interface{}, error) {
+	var record interface{}
+	err := r.next(func(reader *RecordReader) (err error) {
+		record, err = reader.Next()
+		return err
+	})
+	return record, err
+}
+
+// NextRaw returns the next record read from the spool without
+// decoding it.
+func (r *SpoolRecordReader) NextRaw() (*RawRecord, error) {
+	var record *RawRecord
+	err := r.next(func(reader *RecordReader) (err error) {
+		record, err = reader.NextRaw()
+		return err
+	})
+	return record, err
+}
+
+// next reads from the spool with read, moving on to the next file at
+// the end of the current one.
+func (r *SpoolRecordReader) next(read func(*RecordReader) error) error {
+
+	if err := r.applyPolicies(); err != nil {
+		return err
+	}
 
 	for {
 
@@ -166,23 +244,43 @@ func (r *SpoolRecordReader) Next() (interface{}, error) {
 
 		// If we still don't have a current file, return.
 		if r.reader == nil {
-			return nil, nil
+			return nil
 		}
 
-		record, err := r.reader.Next()
+		err := read(r.reader)
 
 		if err == io.EOF {
 			if r.openNext() {
 				continue
 			}
+		} else if err == io.ErrUnexpectedEOF && r.partialStalled() {
+			r.log("Skipping incomplete record in %s at offset %d.",
+				r.reader.Name(), r.reader.Offset())
+			if r.openNext() {
+				continue
+			}
 		}
 
-		return record, err
+		return err
 
 	}
 
 }
 
+// partialStalled returns true if the reader has been stalled on an
+// incomplete record at its current position for PartialRecordTimeout.
+func (r *SpoolRecordReader) partialStalled() bool {
+	if r.PartialRecordTimeout == 0 {
+		return false
+	}
+	offset := r.reader.Offset()
+	if r.partialSince.IsZero() || offset != r.partialOffset {
+		r.partialOffset = offset
+		r.partialSince = time.Now()
+	}
+	return time.Since(r.partialSince) >= r.PartialRecordTimeout
+}
+
 // Offset returns the current filename that is being processed and its
 // read position (the offset).
 func (r *SpoolRecordReader) Offset() (string, int64) {
@@ -192,3 +290,79 @@ func (r *SpoolRecordReader) Offset() (string, int64) {
 		return "", 0
 	}
 }
+
+// Close closes the file currently being read.
+func (r *SpoolRecordReader) Close() {
+	if r.reader != nil {
+		r.reader.Close()
+	}
+}
+
+// SetOffset positions the reader at offset within the named spool
+// file, such as a position previously returned by Offset and saved as
+// a bookmark.  Reading continues from there on the next call to Next.
+func (r *SpoolRecordReader) SetOffset(filename string, offset int64) error {
+	reader, err := NewRecordReader(path.Join(r.directory, path.Base(filename)),
+		offset)
+	if err != nil {
+		return err
+	}
+	if r.reader != nil {
+		r.reader.Close()
+	}
+	r.reader = reader
+	return nil
+}
+
+// Commit records the position the consumer has durably processed up
+// to, typically the position just saved as a bookmark.  Once Commit
+// has been called, policies are only applied to files that come before
+// the committed file.
+func (r *SpoolRecordReader) Commit(filename string, offset int64) {
+	r.committed = path.Base(filename)
+}
+
+// applyPolicies applies the policies to completed files, in the order
+// they were completed, that are no longer referenced by the reader or
+// the committed position.  Returns a *SpoolPolicyError if a policy
+// fails.
+func (r *SpoolRecordReader) applyPolicies() error {
+	now := time.Now()
+
+	for len(r.completed) > 0 {
+		file := r.completed[0]
+
+		if r.committed != "" && file.name >= r.committed {
+			return nil
+		}
+		if r.reader != nil && file.name >= path.Base(r.reader.Name()) {
+			return nil
+		}
+		if now.Before(file.retry) {
+			return nil
+		}
+
+		for file.filename != "" && file.policy < len(r.Policies) {
+			if _, err := os.Stat(file.filename); os.IsNotExist(err) {
+				r.log("Completed file %s no longer exists.", file.filename)
+				break
+			}
+			filename, err := r.Policies[file.policy].Apply(file.filename)
+			if err != nil {
+				interval := r.RetryInterval
+				if interval == 0 {
+					interval = 10 * time.Second
+				}
+				file.retry = now.Add(interval)
+				r.log("Policy failed for %s: %s", file.filename, err)
+				return &SpoolPolicyError{file.filename, err}
+			}
+			file.filename = filename
+			file.policy++
+		}
+
+		r.completed = r.completed[1:]
+	}
+
+	return nil
+}
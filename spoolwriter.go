@@ -0,0 +1,177 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// SpoolWriter writes records into spool files, one spool directory per
+// sensor, that can be read with a SpoolRecordReader.  Its Write and
+// Sync methods can be used as a Receiver's Handler and Sync.
+//
+// SpoolWriters should be created with NewSpoolWriter().
+type SpoolWriter struct {
+	// MaxSize is the size at which a new spool file is started.  A new
+	// file is only started before an event record so events stay in
+	// the same file as their packets and extra data.  Zero disables
+	// rotation.
+	MaxSize int64
+
+	directory string
+	prefix    string
+
+	mutex sync.Mutex
+	files map[string]*spoolWriterFile
+
+	// The timestamp of the last file created in each directory.
+	last map[string]int64
+
+	// sync commits a file to stable storage, replaced in tests.
+	sync func(file *os.File) error
+}
+
+type spoolWriterFile struct {
+	file *os.File
+	size int64
+}
+
+// NewSpoolWriter creates a SpoolWriter writing files prefixed with
+// prefix into a directory for each sensor under directory.
+func NewSpoolWriter(directory string, prefix string) *SpoolWriter {
+	return &SpoolWriter{
+		directory: directory,
+		prefix:    prefix,
+		files:     map[string]*spoolWriterFile{},
+		last:      map[string]int64{},
+		sync:      (*os.File).Sync,
+	}
+}
+
+// Directory returns the spool directory records from sensor are
+// written to.  Records with an empty sensor name are written directly
+// into the directory the SpoolWriter was created with.
+func (w *SpoolWriter) Directory(sensor string) string {
+	return path.Join(w.directory, sensor)
+}
+
+// Write appends a record to the current spool file of sensor.
+func (w *SpoolWriter) Write(sensor string, record *RawRecord) error {
+	if sensor != "" && !ValidSensorName(sensor) {
+		return fmt.Errorf("invalid sensor name %q", sensor)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	size := int64(len(record.Data)) + 8
+
+	output := w.files[sensor]
+	if output != nil && w.MaxSize > 0 && output.size > 0 &&
+		output.size+size > w.MaxSize && isEventType(record.Type) {
+		// Records written since the last Sync are in the old file,
+		// which the next Sync will not cover.
+		if err := w.sync(output.file); err != nil {
+			return err
+		}
+		if err := output.file.Close(); err != nil {
+			return err
+		}
+		delete(w.files, sensor)
+		output = nil
+	}
+
+	if output == nil {
+		file, err := w.create(w.Directory(sensor))
+		if err != nil {
+			return err
+		}
+		output = &spoolWriterFile{file: file}
+		w.files[sensor] = output
+	}
+
+	if err := WriteRawRecord(output.file, record); err != nil {
+		return err
+	}
+	output.size += size
+	return nil
+}
+
+// create creates a new spool file in directory, named with the current
+// time or a later one if that name is taken.  Names never go back
+// below the last one used, even if files rotate faster than once a
+// second and the files ahead of the clock have been removed by a
+// reader, as a reader would skip a file named before its current one.
+func (w *SpoolWriter) create(directory string) (*os.File, error) {
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, err
+	}
+	timestamp := time.Now().Unix()
+	if last, ok := w.last[directory]; ok && timestamp <= last {
+		timestamp = last + 1
+	}
+	for ; ; timestamp++ {
+		filename := path.Join(directory,
+			fmt.Sprintf("%s.%d", w.prefix, timestamp))
+		file, err := os.OpenFile(filename,
+			os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		} else if err == nil {
+			w.last[directory] = timestamp
+		}
+		return file, err
+	}
+}
+
+// Sync commits the current spool file of sensor to stable storage.
+func (w *SpoolWriter) Sync(sensor string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if output := w.files[sensor]; output != nil {
+		return w.sync(output.file)
+	}
+	return nil
+}
+
+// Close closes all open spool files.
+func (w *SpoolWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	var err error
+	for sensor, output := range w.files {
+		if cerr := output.file.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(w.files, sensor)
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package unified2
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestSpoolWriterSyncOnRotate(t *testing.T) {
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	writer := NewSpoolWriter(tmpdir, "unified2.log")
+	writer.MaxSize = 1
+	var synced []string
+	writer.sync = func(file *os.File) error {
+		synced = append(synced, path.Base(file.Name()))
+		return file.Sync()
+	}
+	defer writer.Close()
+
+	records, err := NewGenerator(1).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := records[0]
+
+	if err := writer.Write("", event); err != nil {
+		t.Fatal(err)
+	}
+	first := path.Base(writer.files[""].file.Name())
+
+	// Rotates before the second event, so the first must be synced
+	// before its file is closed.
+	if err := writer.Write("", event); err != nil {
+		t.Fatal(err)
+	}
+	if len(synced) != 1 || synced[0] != first {
+		t.Fatalf("expected %s to be synced on rotation, got %v", first,
+			synced)
+	}
+
+	if err := writer.Sync(""); err != nil {
+		t.Fatal(err)
+	}
+	second := path.Base(writer.files[""].file.Name())
+	if len(synced) != 2 || synced[1] != second || second == first {
+		t.Fatalf("expected %s to be synced, got %v", second, synced)
+	}
+}
+
+func TestSpoolWriterNamesAfterDelete(t *testing.T) {
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	writer := NewSpoolWriter(tmpdir, "unified2.log")
+	writer.MaxSize = 1
+	defer writer.Close()
+
+	records, err := NewGenerator(1).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate faster than once a second so names run ahead of the
+	// clock, removing each file once closed as a reader would.
+	var last string
+	for i := 0; i < 5; i++ {
+		if err := writer.Write("", records[0]); err != nil {
+			t.Fatal(err)
+		}
+		name := writer.files[""].file.Name()
+		if last != "" {
+			if name <= last {
+				t.Fatalf("%s created after %s", name, last)
+			}
+			os.Remove(last)
+		}
+		last = name
+	}
+}
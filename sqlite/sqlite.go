@@ -0,0 +1,455 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+/*
+Package sqlite stores unified2 events in a SQLite database.
+
+The schema is normalized into events, packets, extra_data and
+signatures tables.  Events are keyed by their sensor id, event id and
+event second so storing the same event again has no effect, making it
+safe to re-process a spool after a restart.
+*/
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jasonish/go-unified2"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS signatures (
+		gid INTEGER NOT NULL,
+		sid INTEGER NOT NULL,
+		rev INTEGER NOT NULL DEFAULT 0,
+		msg TEXT,
+		classtype TEXT,
+		priority INTEGER,
+		PRIMARY KEY (gid, sid))`,
+	`CREATE TABLE IF NOT EXISTS events (
+		id INTEGER PRIMARY KEY,
+		sensor_id INTEGER NOT NULL,
+		event_id INTEGER NOT NULL,
+		event_second INTEGER NOT NULL,
+		event_microsecond INTEGER NOT NULL,
+		gid INTEGER NOT NULL,
+		sid INTEGER NOT NULL,
+		rev INTEGER NOT NULL,
+		classification_id INTEGER NOT NULL,
+		priority INTEGER NOT NULL,
+		src_ip TEXT NOT NULL,
+		dst_ip TEXT NOT NULL,
+		sport_itype INTEGER NOT NULL,
+		dport_icode INTEGER NOT NULL,
+		protocol INTEGER NOT NULL,
+		impact_flag INTEGER NOT NULL,
+		impact INTEGER NOT NULL,
+		blocked INTEGER NOT NULL,
+		mpls_label INTEGER NOT NULL,
+		vlan_id INTEGER NOT NULL,
+		app_id TEXT NOT NULL,
+		UNIQUE (sensor_id, event_id, event_second))`,
+	`CREATE INDEX IF NOT EXISTS events_time ON events (event_second)`,
+	`CREATE INDEX IF NOT EXISTS events_signature ON events (gid, sid)`,
+	`CREATE INDEX IF NOT EXISTS events_src_ip ON events (src_ip)`,
+	`CREATE INDEX IF NOT EXISTS events_dst_ip ON events (dst_ip)`,
+	`CREATE TABLE IF NOT EXISTS packets (
+		id INTEGER PRIMARY KEY,
+		event INTEGER NOT NULL REFERENCES events (id),
+		packet_second INTEGER NOT NULL,
+		packet_microsecond INTEGER NOT NULL,
+		linktype INTEGER NOT NULL,
+		length INTEGER NOT NULL,
+		data BLOB NOT NULL)`,
+	`CREATE INDEX IF NOT EXISTS packets_event ON packets (event)`,
+	`CREATE TABLE IF NOT EXISTS extra_data (
+		id INTEGER PRIMARY KEY,
+		event INTEGER NOT NULL REFERENCES events (id),
+		type INTEGER NOT NULL,
+		data_type INTEGER NOT NULL,
+		data BLOB NOT NULL)`,
+	`CREATE INDEX IF NOT EXISTS extra_data_event ON extra_data (event)`,
+}
+
+// Store stores events in a SQLite database.
+type Store struct {
+	// Signatures, if set, are used to fill in the signatures table.
+	Signatures *unified2.SignatureMap
+
+	db *sql.DB
+}
+
+// Open opens, creating if needed, the SQLite database filename.
+func Open(filename string) (*Store, error) {
+	db, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		return nil, err
+	}
+	store, err := New(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// New creates a Store using an already open database, creating the
+// schema if needed.
+func New(db *sql.DB) (*Store, error) {
+	for _, statement := range schema {
+		if _, err := db.Exec(statement); err != nil {
+			return nil, err
+		}
+	}
+	return &Store{db: db}, nil
+}
+
+// DB returns the underlying database for other queries.
+func (s *Store) DB() *sql.DB {
+	return s.db
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// ipString formats an address for storage.  IPv4-mapped IPv6
+// addresses, as found in IPv6 events, keep their ::ffff: prefix so
+// they are loaded as IPv6 addresses again.
+func ipString(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil && len(ip) == net.IPv6len {
+		return "::ffff:" + ip4.String()
+	}
+	return ip.String()
+}
+
+// Insert stores a batch of events in a single transaction.  Events
+// already stored are skipped along with their packets and extra data.
+//
+// Insert can be used as the Send function of a SpoolBatcher.
+func (s *Store) Insert(events []*unified2.AggregatedEvent) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := s.insert(tx, events); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Store) insert(tx *sql.Tx, events []*unified2.AggregatedEvent) error {
+	insertEvent, err := tx.Prepare(`INSERT OR IGNORE INTO events (
+		sensor_id, event_id, event_second, event_microsecond, gid, sid,
+		rev, classification_id, priority, src_ip, dst_ip, sport_itype,
+		dport_icode, protocol, impact_flag, impact, blocked, mpls_label,
+		vlan_id, app_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insertEvent.Close()
+
+	insertPacket, err := tx.Prepare(`INSERT INTO packets (event,
+		packet_second, packet_microsecond, linktype, length, data)
+		VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insertPacket.Close()
+
+	insertExtra, err := tx.Prepare(`INSERT INTO extra_data (event, type,
+		data_type, data) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insertExtra.Close()
+
+	for _, event := range events {
+		record := event.Event
+
+		if err := s.insertSignature(tx, record); err != nil {
+			return err
+		}
+
+		result, err := insertEvent.Exec(record.SensorId, record.EventId,
+			record.EventSecond, record.EventMicrosecond,
+			record.GeneratorId, record.SignatureId,
+			record.SignatureRevision, record.ClassificationId,
+			record.Priority, ipString(record.IpSource),
+			ipString(record.IpDestination), record.SportItype,
+			record.DportIcode, record.Protocol, record.ImpactFlag,
+			record.Impact, record.Blocked, record.MplsLabel,
+			record.VlanId, record.AppId)
+		if err != nil {
+			return err
+		}
+		if rows, err := result.RowsAffected(); err != nil {
+			return err
+		} else if rows == 0 {
+			// Already stored.
+			continue
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		for _, packet := range event.Packets {
+			_, err := insertPacket.Exec(id, packet.PacketSecond,
+				packet.PacketMicrosecond, packet.LinkType,
+				packet.Length, packet.Data)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, extra := range event.ExtraData {
+			_, err := insertExtra.Exec(id, extra.Type, extra.DataType,
+				extra.Data)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// insertSignature makes sure the signatures table has an entry for
+// the event's signature, with its details if known.
+func (s *Store) insertSignature(tx *sql.Tx, record *unified2.EventRecord) error {
+	if s.Signatures != nil {
+		signature := s.Signatures.Lookup(record.GeneratorId,
+			record.SignatureId)
+		if signature != nil {
+			_, err := tx.Exec(`INSERT OR REPLACE INTO signatures
+				(gid, sid, rev, msg, classtype, priority)
+				VALUES (?, ?, ?, ?, ?, ?)`,
+				record.GeneratorId, record.SignatureId,
+				record.SignatureRevision, signature.Msg,
+				signature.Classtype, signature.Priority)
+			return err
+		}
+	}
+	_, err := tx.Exec(`INSERT OR IGNORE INTO signatures (gid, sid, rev)
+		VALUES (?, ?, ?)`, record.GeneratorId, record.SignatureId,
+		record.SignatureRevision)
+	return err
+}
+
+// Query selects events with Find.  Zero valued fields are not used to
+// filter.
+type Query struct {
+	// Events at or after Start and before End.
+	Start time.Time
+	End   time.Time
+
+	GeneratorId uint32
+	SignatureId uint32
+
+	// Events with Address as their source or destination.
+	Address net.IP
+
+	// Limit is the maximum number of events to return.
+	Limit int
+}
+
+// Find returns the events matching query, with their packets and
+// extra data, in time order.
+func (s *Store) Find(query Query) ([]*unified2.AggregatedEvent, error) {
+	where := []string{}
+	args := []interface{}{}
+
+	if !query.Start.IsZero() {
+		where = append(where, "event_second >= ?")
+		args = append(args, query.Start.Unix())
+	}
+	if !query.End.IsZero() {
+		where = append(where, "event_second < ?")
+		args = append(args, query.End.Unix())
+	}
+	if query.GeneratorId != 0 {
+		where = append(where, "gid = ?")
+		args = append(args, query.GeneratorId)
+	}
+	if query.SignatureId != 0 {
+		where = append(where, "sid = ?")
+		args = append(args, query.SignatureId)
+	}
+	if query.Address != nil {
+		// net.ParseIP returns IPv4 addresses in IPv4-mapped form, so
+		// match addresses stored either way.
+		addresses := []interface{}{ipString(query.Address),
+			query.Address.String()}
+		where = append(where, "(src_ip IN (?, ?) OR dst_ip IN (?, ?))")
+		args = append(append(args, addresses...), addresses...)
+	}
+
+	statement := `SELECT id, sensor_id, event_id, event_second,
+		event_microsecond, gid, sid, rev, classification_id, priority,
+		src_ip, dst_ip, sport_itype, dport_icode, protocol, impact_flag,
+		impact, blocked, mpls_label, vlan_id, app_id FROM events`
+	if len(where) > 0 {
+		statement += " WHERE " + strings.Join(where, " AND ")
+	}
+	statement += " ORDER BY event_second, event_microsecond, id"
+	if query.Limit > 0 {
+		statement += fmt.Sprintf(" LIMIT %d", query.Limit)
+	}
+
+	rows, err := s.db.Query(statement, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []*unified2.AggregatedEvent{}
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		var src, dst string
+		record := &unified2.EventRecord{}
+		err := rows.Scan(&id, &record.SensorId, &record.EventId,
+			&record.EventSecond, &record.EventMicrosecond,
+			&record.GeneratorId, &record.SignatureId,
+			&record.SignatureRevision, &record.ClassificationId,
+			&record.Priority, &src, &dst, &record.SportItype,
+			&record.DportIcode, &record.Protocol, &record.ImpactFlag,
+			&record.Impact, &record.Blocked, &record.MplsLabel,
+			&record.VlanId, &record.AppId)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		record.IpSource = parseIP(src)
+		record.IpDestination = parseIP(dst)
+		events = append(events, &unified2.AggregatedEvent{Event: record})
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, event := range events {
+		if err := s.load(ids[i], event); err != nil {
+			return nil, err
+		}
+	}
+
+	return events, nil
+}
+
+// parseIP parses a stored address, returning IPv4 addresses as 4
+// bytes.
+func parseIP(address string) net.IP {
+	ip := net.ParseIP(address)
+	if ip4 := ip.To4(); ip4 != nil && !strings.Contains(address, ":") {
+		return ip4
+	}
+	return ip
+}
+
+// load loads the packets and extra data of an event.
+func (s *Store) load(id int64, event *unified2.AggregatedEvent) error {
+	record := event.Event
+
+	rows, err := s.db.Query(`SELECT packet_second, packet_microsecond,
+		linktype, length, data FROM packets WHERE event = ? ORDER BY id`, id)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		packet := &unified2.PacketRecord{
+			SensorId:    record.SensorId,
+			EventId:     record.EventId,
+			EventSecond: record.EventSecond,
+		}
+		err := rows.Scan(&packet.PacketSecond, &packet.PacketMicrosecond,
+			&packet.LinkType, &packet.Length, &packet.Data)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		event.Packets = append(event.Packets, packet)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = s.db.Query(`SELECT type, data_type, data FROM extra_data
+		WHERE event = ? ORDER BY id`, id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		extra := &unified2.ExtraDataRecord{
+			SensorId:    record.SensorId,
+			EventId:     record.EventId,
+			EventSecond: record.EventSecond,
+		}
+		if err := rows.Scan(&extra.Type, &extra.DataType,
+			&extra.Data); err != nil {
+			return err
+		}
+		extra.DataLength = uint32(len(extra.Data)) + 8
+		extra.EventLength = uint32(len(extra.Data)) +
+			unified2.EXTRA_DATA_RECORD_HDR_LEN
+		extra.EventType = unified2.EXTRA_DATA_EVENT_TYPE
+		event.ExtraData = append(event.ExtraData, extra)
+	}
+	return rows.Err()
+}
+
+// Signature returns the stored signature for the generator and
+// signature id, or nil if not stored.
+func (s *Store) Signature(generatorId uint32, signatureId uint32) (*unified2.Signature, error) {
+	signature := &unified2.Signature{}
+	var msg, classtype sql.NullString
+	var priority sql.NullInt64
+	err := s.db.QueryRow(`SELECT gid, sid, rev, msg, classtype, priority
+		FROM signatures WHERE gid = ? AND sid = ?`, generatorId,
+		signatureId).Scan(&signature.GeneratorId, &signature.SignatureId,
+		&signature.Revision, &msg, &classtype, &priority)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	signature.Msg = msg.String
+	signature.Classtype = classtype.String
+	signature.Priority = uint32(priority.Int64)
+	return signature, nil
+}
@@ -0,0 +1,243 @@
+package sqlite
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jasonish/go-unified2"
+)
+
+func readEvents(t *testing.T, filename string) []*unified2.AggregatedEvent {
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var aggregator unified2.EventAggregator
+	events := []*unified2.AggregatedEvent{}
+	for {
+		record, err := unified2.ReadRecord(file)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if event := aggregator.Add(record); event != nil {
+			events = append(events, event)
+		}
+	}
+	if event := aggregator.Flush(); event != nil {
+		events = append(events, event)
+	}
+	return events
+}
+
+// setEventId changes the event id of an event and its packets and
+// extra data.
+func setEventId(event *unified2.AggregatedEvent, eventId uint32) {
+	event.Event.EventId = eventId
+	for _, packet := range event.Packets {
+		packet.EventId = eventId
+	}
+	for _, extra := range event.ExtraData {
+		extra.EventId = eventId
+	}
+}
+
+func openStore(t *testing.T) (*Store, string) {
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := Open(path.Join(tmpdir, "events.db"))
+	if err != nil {
+		os.RemoveAll(tmpdir)
+		t.Fatal(err)
+	}
+	return store, tmpdir
+}
+
+func TestStoreInsertFind(t *testing.T) {
+	store, tmpdir := openStore(t)
+	defer os.RemoveAll(tmpdir)
+	defer store.Close()
+
+	events := readEvents(t, "../test/multi-record-event-x2.log")
+
+	// The test file contains the same event twice, give the second a
+	// new event id so it is stored separately.
+	setEventId(events[1], events[1].Event.EventId+1)
+
+	if err := store.Insert(events); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := store.Find(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(found))
+	}
+	for i := range events {
+		if !reflect.DeepEqual(found[i], events[i]) {
+			t.Fatalf("event %d does not match: %v", i, found[i].Event)
+		}
+	}
+}
+
+func TestStoreInsertDuplicate(t *testing.T) {
+	store, tmpdir := openStore(t)
+	defer os.RemoveAll(tmpdir)
+	defer store.Close()
+
+	events := readEvents(t, "../test/multi-record-event-x2.log")
+	if err := store.Insert(events); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Insert(events[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := store.Find(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(found))
+	}
+	if len(found[0].Packets) != len(events[0].Packets) {
+		t.Fatalf("expected %d packets, got %d", len(events[0].Packets),
+			len(found[0].Packets))
+	}
+}
+
+func TestStoreQuery(t *testing.T) {
+	store, tmpdir := openStore(t)
+	defer os.RemoveAll(tmpdir)
+	defer store.Close()
+
+	events := readEvents(t, "../test/multi-record-event-x2.log")
+	events[1].Event.EventId++
+	events[1].Event.EventSecond += 60
+	events[1].Event.SignatureId++
+	events[1].Event.IpSource = net.ParseIP("10.1.1.1").To4()
+	events[1].Event.IpDestination = net.ParseIP("::ffff:10.1.1.2")
+	if err := store.Insert(events); err != nil {
+		t.Fatal(err)
+	}
+
+	first := events[0].Event
+	second := time.Unix(int64(events[1].Event.EventSecond), 0)
+
+	tests := []struct {
+		query    Query
+		expected int
+	}{
+		{Query{SignatureId: first.SignatureId}, 1},
+		{Query{GeneratorId: first.GeneratorId}, 2},
+		{Query{SignatureId: 1}, 0},
+		{Query{Address: first.IpSource}, 1},
+		{Query{Address: first.IpDestination}, 1},
+		{Query{Address: net.ParseIP("::ffff:10.1.1.1")}, 1},
+		{Query{Address: net.ParseIP("10.1.1.2")}, 1},
+		{Query{Start: second}, 1},
+		{Query{End: second}, 1},
+		{Query{Start: second, End: second.Add(time.Second)}, 1},
+		{Query{Limit: 1}, 1},
+	}
+	for i, test := range tests {
+		found, err := store.Find(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != test.expected {
+			t.Fatalf("query %d: expected %d events, got %d", i,
+				test.expected, len(found))
+		}
+	}
+}
+
+func TestStoreIPv4MappedAddress(t *testing.T) {
+	store, tmpdir := openStore(t)
+	defer os.RemoveAll(tmpdir)
+	defer store.Close()
+
+	// An IPv6 event with an IPv4-mapped address is loaded unchanged.
+	events := readEvents(t, "../test/multi-record-event.log")
+	events[0].Event.IpSource = net.ParseIP("::ffff:10.1.1.1")
+	if err := store.Insert(events); err != nil {
+		t.Fatal(err)
+	}
+	found, err := store.Find(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(found))
+	}
+	source, destination := found[0].Event.IpSource,
+		found[0].Event.IpDestination
+	if len(source) != net.IPv6len || !source.Equal(events[0].Event.IpSource) {
+		t.Fatalf("unexpected source %v", []byte(source))
+	}
+	if len(destination) != net.IPv4len {
+		t.Fatalf("unexpected destination %v", []byte(destination))
+	}
+}
+
+func TestStoreSignatures(t *testing.T) {
+	store, tmpdir := openStore(t)
+	defer os.RemoveAll(tmpdir)
+	defer store.Close()
+
+	events := readEvents(t, "../test/multi-record-event-x2.log")
+	event := events[0].Event
+
+	if err := store.Insert(events[:1]); err != nil {
+		t.Fatal(err)
+	}
+	signature, err := store.Signature(event.GeneratorId, event.SignatureId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signature == nil || signature.Msg != "" {
+		t.Fatalf("unexpected signature: %v", signature)
+	}
+
+	store.Signatures = unified2.NewSignatureMap()
+	store.Signatures.Add(&unified2.Signature{
+		GeneratorId: event.GeneratorId,
+		SignatureId: event.SignatureId,
+		Revision:    event.SignatureRevision,
+		Msg:         "TEST SIGNATURE",
+		Classtype:   "misc-activity",
+		Priority:    3,
+	})
+	if err := store.Insert(events[:1]); err != nil {
+		t.Fatal(err)
+	}
+	signature, err = store.Signature(event.GeneratorId, event.SignatureId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signature == nil || signature.Msg != "TEST SIGNATURE" ||
+		signature.Classtype != "misc-activity" || signature.Priority != 3 {
+		t.Fatalf("unexpected signature: %v", signature)
+	}
+
+	signature, err = store.Signature(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signature != nil {
+		t.Fatal("expected no signature")
+	}
+}
@@ -0,0 +1,238 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Stats collects summary statistics over unified2 records.
+//
+// Stats should be created with NewStats().
+type Stats struct {
+	// Records is the number of records of each record type.
+	Records map[uint32]int
+
+	Events    int
+	Packets   int // Packet and buffer records.
+	ExtraData int
+
+	// Event counts keyed by "gid:sid", source and destination
+	// address, source and destination port, protocol number, sensor
+	// id, blocked status, VLAN id and MPLS label.  Ports are only
+	// counted for protocols that have them.
+	Signatures           map[string]int
+	SourceAddresses      map[string]int
+	DestinationAddresses map[string]int
+	SourcePorts          map[string]int
+	DestinationPorts     map[string]int
+	Protocols            map[string]int
+	Sensors              map[string]int
+	Blocked              map[string]int
+	Vlans                map[string]int
+	MplsLabels           map[string]int
+
+	// The earliest and latest event times seen.
+	FirstEvent time.Time
+	LastEvent  time.Time
+}
+
+// NewStats creates a new empty Stats.
+func NewStats() *Stats {
+	return &Stats{
+		Records:              map[uint32]int{},
+		Signatures:           map[string]int{},
+		SourceAddresses:      map[string]int{},
+		DestinationAddresses: map[string]int{},
+		SourcePorts:          map[string]int{},
+		DestinationPorts:     map[string]int{},
+		Protocols:            map[string]int{},
+		Sensors:              map[string]int{},
+		Blocked:              map[string]int{},
+		Vlans:                map[string]int{},
+		MplsLabels:           map[string]int{},
+	}
+}
+
+// AddRaw adds a raw record, counting its type then decoding it and
+// adding the decoded record.
+func (s *Stats) AddRaw(record *RawRecord) error {
+	s.Records[record.Type]++
+
+	var decoded interface{}
+	var err error
+
+	switch {
+	case isEventType(record.Type):
+		decoded, err = DecodeEventRecord(record.Type, record.Data)
+	case record.Type == UNIFIED2_PACKET:
+		decoded, err = DecodePacketRecord(record.Data)
+	case record.Type == UNIFIED2_BUFFER:
+		decoded, err = DecodeBufferRecord(record.Data)
+	case record.Type == UNIFIED2_EXTRA_DATA:
+		decoded, err = DecodeExtraDataRecord(record.Data)
+	}
+	if err != nil {
+		return err
+	}
+	if decoded != nil {
+		s.Add(decoded)
+	}
+	return nil
+}
+
+// Add adds a decoded record as returned by ReadRecord.
+func (s *Stats) Add(record interface{}) {
+	switch record := record.(type) {
+	case *EventRecord:
+		s.addEvent(record)
+	case *PacketRecord, *BufferRecord:
+		s.Packets++
+	case *ExtraDataRecord:
+		s.ExtraData++
+	}
+}
+
+func (s *Stats) addEvent(event *EventRecord) {
+	s.Events++
+
+	s.Signatures[fmt.Sprintf("%d:%d", event.GeneratorId,
+		event.SignatureId)]++
+	s.SourceAddresses[event.IpSource.String()]++
+	s.DestinationAddresses[event.IpDestination.String()]++
+	if event.HasPorts() {
+		s.SourcePorts[strconv.Itoa(int(event.SourcePort()))]++
+		s.DestinationPorts[strconv.Itoa(int(event.DestinationPort()))]++
+	}
+	s.Protocols[strconv.Itoa(int(event.Protocol))]++
+	s.Sensors[strconv.Itoa(int(event.SensorId))]++
+
+	if status := event.BlockedStatus(); status == BLOCKED_STATUS_NOT_BLOCKED {
+		s.Blocked["alerted"]++
+	} else {
+		s.Blocked[status.String()]++
+	}
+
+	s.Vlans[strconv.Itoa(int(event.VlanId))]++
+	s.MplsLabels[strconv.Itoa(int(event.MplsLabel))]++
+
+	timestamp := time.Unix(int64(event.EventSecond),
+		int64(event.EventMicrosecond)*1000).UTC()
+	if s.FirstEvent.IsZero() || timestamp.Before(s.FirstEvent) {
+		s.FirstEvent = timestamp
+	}
+	if timestamp.After(s.LastEvent) {
+		s.LastEvent = timestamp
+	}
+}
+
+// StatsCount is a key and its count.
+type StatsCount struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// Top returns the n keys with the highest counts, highest first.  Keys
+// with the same count are ordered by key.
+func Top(counts map[string]int, n int) []StatsCount {
+	top := make([]StatsCount, 0, len(counts))
+	for key, count := range counts {
+		top = append(top, StatsCount{key, count})
+	}
+	sort.Sort(byCount(top))
+	if n > 0 && len(top) > n {
+		top = top[0:n]
+	}
+	return top
+}
+
+type byCount []StatsCount
+
+func (c byCount) Len() int      { return len(c) }
+func (c byCount) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c byCount) Less(i, j int) bool {
+	if c[i].Count != c[j].Count {
+		return c[i].Count > c[j].Count
+	}
+	return c[i].Key < c[j].Key
+}
+
+// StatsReport is a summary of Stats suitable for display or encoding
+// as JSON.
+type StatsReport struct {
+	Records   map[string]int `json:"records"`
+	Events    int            `json:"events"`
+	Packets   int            `json:"packets"`
+	ExtraData int            `json:"extra_data"`
+
+	FirstEvent *time.Time `json:"first_event,omitempty"`
+	LastEvent  *time.Time `json:"last_event,omitempty"`
+
+	TopSignatures           []StatsCount `json:"top_signatures"`
+	TopSourceAddresses      []StatsCount `json:"top_source_addresses"`
+	TopDestinationAddresses []StatsCount `json:"top_destination_addresses"`
+	TopSourcePorts          []StatsCount `json:"top_source_ports"`
+	TopDestinationPorts     []StatsCount `json:"top_destination_ports"`
+
+	Protocols  []StatsCount `json:"protocols"`
+	Sensors    []StatsCount `json:"sensors"`
+	Blocked    []StatsCount `json:"blocked"`
+	Vlans      []StatsCount `json:"vlans"`
+	MplsLabels []StatsCount `json:"mpls_labels"`
+}
+
+// Report returns a summary of the statistics, limiting the top lists
+// to n entries.
+func (s *Stats) Report(n int) *StatsReport {
+	report := &StatsReport{
+		Records:                 map[string]int{},
+		Events:                  s.Events,
+		Packets:                 s.Packets,
+		ExtraData:               s.ExtraData,
+		TopSignatures:           Top(s.Signatures, n),
+		TopSourceAddresses:      Top(s.SourceAddresses, n),
+		TopDestinationAddresses: Top(s.DestinationAddresses, n),
+		TopSourcePorts:          Top(s.SourcePorts, n),
+		TopDestinationPorts:     Top(s.DestinationPorts, n),
+		Protocols:               Top(s.Protocols, 0),
+		Sensors:                 Top(s.Sensors, 0),
+		Blocked:                 Top(s.Blocked, 0),
+		Vlans:                   Top(s.Vlans, 0),
+		MplsLabels:              Top(s.MplsLabels, 0),
+	}
+	for recordType, count := range s.Records {
+		report.Records[strconv.Itoa(int(recordType))] = count
+	}
+	if s.Events > 0 {
+		report.FirstEvent = &s.FirstEvent
+		report.LastEvent = &s.LastEvent
+	}
+	return report
+}
@@ -0,0 +1,76 @@
+package unified2
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+
+	file, err := os.Open("test/multi-record-event-x2.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	stats := NewStats()
+	for {
+		record, err := ReadRawRecord(file)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if err := stats.AddRaw(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if stats.Events != 2 || stats.Packets != 30 || stats.ExtraData != 2 {
+		t.Fatalf("unexpected counts: %d %d %d", stats.Events,
+			stats.Packets, stats.ExtraData)
+	}
+	if stats.Records[UNIFIED2_EVENT_V2] != 2 {
+		t.Fatalf("unexpected record type counts: %v", stats.Records)
+	}
+
+	report := stats.Report(10)
+	if len(report.TopSignatures) != 1 ||
+		report.TopSignatures[0] != (StatsCount{"120:3", 2}) {
+		t.Fatalf("unexpected top signatures: %v", report.TopSignatures)
+	}
+	if report.TopSourceAddresses[0].Key != "207.25.71.28" {
+		t.Fatalf("unexpected top source: %v", report.TopSourceAddresses)
+	}
+	if report.Blocked[0] != (StatsCount{"alerted", 2}) {
+		t.Fatalf("unexpected blocked: %v", report.Blocked)
+	}
+	if report.FirstEvent == nil || report.FirstEvent.Unix() != 964798804 {
+		t.Fatalf("unexpected first event: %v", report.FirstEvent)
+	}
+
+	// ICMP type and code are not counted as ports.
+	stats.Add(&EventRecord{Protocol: IPPROTO_ICMP, SportItype: 8})
+	stats.Add(&BufferRecord{})
+	if stats.SourcePorts["8"] != 0 || stats.DestinationPorts["0"] != 0 {
+		t.Fatalf("unexpected ports: %v %v", stats.SourcePorts,
+			stats.DestinationPorts)
+	}
+	if stats.Events != 3 || stats.Packets != 31 {
+		t.Fatalf("unexpected counts: %d %d", stats.Events, stats.Packets)
+	}
+}
+
+func TestTop(t *testing.T) {
+	top := Top(map[string]int{"a": 1, "b": 3, "c": 2, "d": 3}, 3)
+	expected := []StatsCount{{"b", 3}, {"d", 3}, {"c", 2}}
+	if len(top) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, top)
+	}
+	for i := range top {
+		if top[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, top)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Syslog message formats.
+const (
+	SYSLOG_RFC5424 = iota
+	SYSLOG_RFC3164
+)
+
+// Syslog facilities used for alerts.
+const (
+	SYSLOG_FACILITY_AUTH   = 4
+	SYSLOG_FACILITY_LOCAL0 = 16
+	SYSLOG_FACILITY_LOCAL7 = 23
+)
+
+// SyslogBufferFull is the error returned when a message is written to
+// a SyslogWriter with a full buffer.  The message is dropped.
+var SyslogBufferFull = errors.New("syslog buffer full")
+
+// SyslogClosed is the error returned when writing to a closed
+// SyslogWriter.
+var SyslogClosed = errors.New("syslog writer closed")
+
+// EventMessage formats an event as a single line in the style of the
+// Snort alert_syslog output, for example:
+//
+//	[1:2000001:3] ET TEST Message [Classification: 2] [Priority: 1] {TCP} 10.0.0.1:80 -> 10.0.0.2:1024
+//
+// If signatures is not nil it is used to look up the signature
+// message.
+func EventMessage(event *EventRecord, signatures *SignatureMap) string {
+	msg := "Snort Alert"
+	if signatures != nil {
+		if signature := signatures.Lookup(event.GeneratorId,
+			event.SignatureId); signature != nil {
+			msg = signature.Msg
+		}
+	}
+
+	protocol, ok := protocolNames[event.Protocol]
+	if !ok {
+		protocol = fmt.Sprintf("PROTO:%03d", event.Protocol)
+	}
+
+	var addresses string
+	if event.HasPorts() {
+		addresses = fmt.Sprintf("%s -> %s",
+			net.JoinHostPort(event.IpSource.String(),
+				fmt.Sprint(event.SportItype)),
+			net.JoinHostPort(event.IpDestination.String(),
+				fmt.Sprint(event.DportIcode)))
+	} else {
+		addresses = fmt.Sprintf("%s -> %s", event.IpSource,
+			event.IpDestination)
+	}
+
+	return fmt.Sprintf("[%d:%d:%d] %s [Classification: %d] [Priority: %d] {%s} %s",
+		event.GeneratorId, event.SignatureId, event.SignatureRevision, msg,
+		event.ClassificationId, event.Priority, protocol, addresses)
+}
+
+// SyslogSeverity maps an event priority to a syslog severity.
+// Priority 1 is critical, 2 error, 3 warning and anything else notice.
+func SyslogSeverity(priority uint32) int {
+	switch priority {
+	case 1:
+		return 2
+	case 2:
+		return 3
+	case 3:
+		return 4
+	}
+	return 5
+}
+
+type syslogMessage struct {
+	timestamp time.Time
+	severity  int
+	msg       []byte
+}
+
+// SyslogWriter sends messages to a syslog server over UDP, TCP or a
+// Unix socket.
+//
+// Messages are queued in a buffer of BufferSize messages and sent in
+// the background, reconnecting to the server as needed.  Messages
+// sent over TCP are framed with octet-counting (RFC 6587).
+//
+// SyslogWriters should be created with NewSyslogWriter().  The
+// exported fields may be changed before the first message is written.
+type SyslogWriter struct {
+	// Format is SYSLOG_RFC5424 or SYSLOG_RFC3164.
+	Format int
+
+	Facility int
+	Hostname string
+
+	// AppName is the RFC 5424 APP-NAME or RFC 3164 TAG.
+	AppName string
+
+	// Signatures, if set, are used to add the signature message to
+	// events written with WriteEvent.
+	Signatures *SignatureMap
+
+	// BufferSize is the maximum number of queued messages.
+	BufferSize int
+
+	// ReconnectInterval is the maximum time to wait between attempts
+	// to connect.
+	ReconnectInterval time.Duration
+
+	network string
+	address string
+
+	once   sync.Once
+	lock   sync.Mutex
+	closed bool
+	queue  chan *syslogMessage
+	done   chan bool
+	exited chan bool
+	conn   net.Conn
+}
+
+// NewSyslogWriter creates a new SyslogWriter sending to address using
+// network, which is one of "udp", "tcp" or "unix" (or their variants
+// accepted by net.Dial).  No connection is made until the first message
+// is written.
+func NewSyslogWriter(network string, address string) *SyslogWriter {
+	hostname, _ := os.Hostname()
+	return &SyslogWriter{
+		Format:            SYSLOG_RFC5424,
+		Facility:          SYSLOG_FACILITY_AUTH,
+		Hostname:          hostname,
+		AppName:           "snort",
+		BufferSize:        1024,
+		ReconnectInterval: 30 * time.Second,
+		network:           network,
+		address:           address,
+	}
+}
+
+func (w *SyslogWriter) start() {
+	w.queue = make(chan *syslogMessage, w.BufferSize)
+	w.done = make(chan bool)
+	w.exited = make(chan bool)
+	go w.run()
+}
+
+// Write queues p to be sent as the MSG part of a syslog message with
+// the current time and a severity of notice.  This allows any
+// formatter writing to an io.Writer to send to syslog.  A trailing
+// newline is removed.
+func (w *SyslogWriter) Write(p []byte) (int, error) {
+	msg := make([]byte, len(p))
+	copy(msg, p)
+	msg = []byte(strings.TrimRight(string(msg), "\n"))
+	if err := w.enqueue(&syslogMessage{time.Now(), 5, msg}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WriteEvent queues an event to be sent, formatted with EventMessage
+// and with a severity based on its priority.
+func (w *SyslogWriter) WriteEvent(event *EventRecord) error {
+	timestamp := time.Unix(int64(event.EventSecond),
+		int64(event.EventMicrosecond)*1000)
+	return w.enqueue(&syslogMessage{timestamp,
+		SyslogSeverity(event.Priority),
+		[]byte(EventMessage(event, w.Signatures))})
+}
+
+func (w *SyslogWriter) enqueue(msg *syslogMessage) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		return SyslogClosed
+	}
+	w.once.Do(w.start)
+	select {
+	case w.queue <- msg:
+		return nil
+	default:
+		return SyslogBufferFull
+	}
+}
+
+// Close sends any queued messages that can be sent without waiting to
+// reconnect, then closes the connection.
+func (w *SyslogWriter) Close() error {
+	w.lock.Lock()
+	if w.closed {
+		w.lock.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.lock.Unlock()
+
+	w.once.Do(w.start)
+	close(w.done)
+	<-w.exited
+	return nil
+}
+
+// format formats a message, including any framing required by the
+// current connection.
+func (w *SyslogWriter) format(msg *syslogMessage) []byte {
+	pri := w.Facility*8 + msg.severity
+	hostname := w.Hostname
+	if hostname == "" {
+		hostname = "-"
+	}
+
+	var line string
+	switch w.Format {
+	case SYSLOG_RFC3164:
+		line = fmt.Sprintf("<%d>%s %s %s: %s", pri,
+			msg.timestamp.Format(time.Stamp), hostname, w.AppName, msg.msg)
+	default:
+		appName := w.AppName
+		if appName == "" {
+			appName = "-"
+		}
+		line = fmt.Sprintf("<%d>1 %s %s %s - - - %s", pri,
+			msg.timestamp.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+			hostname, appName, msg.msg)
+	}
+
+	// Stream connections need framing, datagrams do not.
+	switch w.conn.RemoteAddr().Network() {
+	case "tcp":
+		return []byte(fmt.Sprintf("%d %s", len(line), line))
+	case "unix":
+		return []byte(line + "\n")
+	}
+	return []byte(line)
+}
+
+// dial connects to the server.  For "unix" a datagram socket is tried
+// first, as used by most local syslog daemons.
+func (w *SyslogWriter) dial() (net.Conn, error) {
+	if w.network == "unix" {
+		if conn, err := net.Dial("unixgram", w.address); err == nil {
+			return conn, nil
+		}
+	}
+	return net.DialTimeout(w.network, w.address, 10*time.Second)
+}
+
+// run sends queued messages until closed.
+func (w *SyslogWriter) run() {
+	defer close(w.exited)
+	defer func() {
+		if w.conn != nil {
+			w.conn.Close()
+		}
+	}()
+
+	var interval time.Duration
+
+	for {
+		var msg *syslogMessage
+		select {
+		case msg = <-w.queue:
+		case <-w.done:
+			w.flush()
+			return
+		}
+
+		// Send the message, reconnecting until it is sent or the
+		// writer is closed.
+		for {
+			if w.send(msg) == nil {
+				interval = 0
+				break
+			}
+			if interval == 0 {
+				interval = 100 * time.Millisecond
+			} else if interval *= 2; interval > w.ReconnectInterval {
+				interval = w.ReconnectInterval
+			}
+			select {
+			case <-w.done:
+				return
+			case <-time.After(interval):
+			}
+		}
+	}
+}
+
+// flush sends the remaining queued messages, giving up on the first
+// failure.
+func (w *SyslogWriter) flush() {
+	for {
+		select {
+		case msg := <-w.queue:
+			if w.send(msg) != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// send sends a single message, connecting if needed.  On failure the
+// connection is closed so the next attempt reconnects.
+func (w *SyslogWriter) send(msg *syslogMessage) error {
+	if w.conn == nil {
+		conn, err := w.dial()
+		if err != nil {
+			return err
+		}
+		w.conn = conn
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := w.conn.Write(w.format(msg)); err != nil {
+		w.conn.Close()
+		w.conn = nil
+		return err
+	}
+	return nil
+}
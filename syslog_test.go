@@ -0,0 +1,189 @@
+package unified2
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Load the event from the multi record event test file.
+func loadTestEvent(t *testing.T) *EventRecord {
+	event, _ := loadTemplateRecords(t)
+	decoded, err := DecodeEventRecord(event.Type, event.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+const testEventMessage = "[120:3:1] Snort Alert [Classification: 2] [Priority: 3] {TCP} 207.25.71.28:80 -> 10.20.11.123:2651"
+
+func TestEventMessage(t *testing.T) {
+	event := loadTestEvent(t)
+	if msg := EventMessage(event, nil); msg != testEventMessage {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+
+	signatures := NewSignatureMap()
+	signatures.Add(&Signature{GeneratorId: 120, SignatureId: 3,
+		Msg: "Test Message"})
+	msg := EventMessage(event, signatures)
+	if !strings.HasPrefix(msg, "[120:3:1] Test Message [") {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+}
+
+func TestSyslogWriterUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	writer := NewSyslogWriter("udp", conn.LocalAddr().String())
+	writer.Hostname = "sensor"
+	if err := writer.WriteEvent(loadTestEvent(t)); err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "<36>1 2000-07-28T15:40:04.267362Z sensor snort - - - " +
+		testEventMessage
+	if string(buf[0:n]) != expected {
+		t.Fatalf("unexpected message: %s", buf[0:n])
+	}
+}
+
+func TestSyslogWriterUnix(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	conn, err := net.ListenPacket("unixgram", tmpdir+"/log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	writer := NewSyslogWriter("unix", tmpdir+"/log")
+	writer.Format = SYSLOG_RFC3164
+	writer.Hostname = "sensor"
+	writer.Write([]byte("hello\n"))
+	defer writer.Close()
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(buf[0:n]), "<37>") ||
+		!strings.HasSuffix(string(buf[0:n]), " sensor snort: hello") {
+		t.Fatalf("unexpected message: %s", buf[0:n])
+	}
+}
+
+// Read an octet-counted syslog message.
+func readOctetCounted(t *testing.T, reader *bufio.Reader) string {
+	length, err := reader.ReadString(' ')
+	if err != nil {
+		return ""
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(length))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, n)
+	if _, err := reader.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func TestSyslogWriterTCPReconnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	writer := NewSyslogWriter("tcp", listener.Addr().String())
+	writer.ReconnectInterval = 10 * time.Millisecond
+	defer writer.Close()
+
+	writer.Write([]byte("first"))
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msg := readOctetCounted(t, bufio.NewReader(conn))
+	if !strings.HasSuffix(msg, " first") {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+
+	// Drop the connection.  Writing to the closed connection may
+	// appear to succeed, so keep writing until a message arrives on
+	// a new connection.
+	conn.Close()
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	var reconnected net.Conn
+	deadline := time.After(5 * time.Second)
+	for reconnected == nil {
+		writer.Write([]byte("again"))
+		select {
+		case reconnected = <-accepted:
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("timed out waiting for reconnect")
+		}
+	}
+	defer reconnected.Close()
+
+	reconnected.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msg = readOctetCounted(t, bufio.NewReader(reconnected))
+	if !strings.HasSuffix(msg, " again") {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+}
+
+func TestSyslogWriterBufferFull(t *testing.T) {
+	// Find a port with nothing listening.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	writer := NewSyslogWriter("tcp", address)
+	writer.BufferSize = 2
+	defer writer.Close()
+
+	for i := 0; i < 4; i++ {
+		_, err = writer.Write([]byte("message"))
+		if err == SyslogBufferFull {
+			return
+		}
+	}
+	t.Fatal("expected SyslogBufferFull")
+}
@@ -0,0 +1,506 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Threshold types, as in the type option of a threshold.conf
+// event_filter.
+const (
+	// Log the first count events of each interval.
+	THRESHOLD_TYPE_LIMIT = 1
+
+	// Log every count'th event, each logged event starting a new
+	// interval.
+	THRESHOLD_TYPE_THRESHOLD = 2
+
+	// Log once per interval, after count events.
+	THRESHOLD_TYPE_BOTH = 3
+)
+
+// What a threshold or suppression is tracked by.
+const (
+	THRESHOLD_TRACK_NONE   = 0
+	THRESHOLD_TRACK_BY_SRC = 1
+	THRESHOLD_TRACK_BY_DST = 2
+)
+
+// ThresholdRule limits the rate at which events of a signature are
+// logged.  A SignatureId of 0 applies to all signatures of the
+// generator, and a GeneratorId and SignatureId of 0 to all events.
+// The rule for the most specific ids is the only one applied to an
+// event.
+type ThresholdRule struct {
+	GeneratorId uint32
+	SignatureId uint32
+	Type        int
+	Track       int
+
+	// Count is the number of events, a negative count disables the
+	// rule.
+	Count int
+
+	// Seconds is the length of the interval.
+	Seconds uint32
+}
+
+// SuppressRule drops events of a signature.  If Track is set only
+// events whose tracked address is in Networks, and not in
+// ExcludedNetworks, are dropped.  Ids of 0 match as for ThresholdRule,
+// but all matching suppressions are applied.
+type SuppressRule struct {
+	GeneratorId      uint32
+	SignatureId      uint32
+	Track            int
+	Networks         []*net.IPNet
+	ExcludedNetworks []*net.IPNet
+}
+
+// matches returns true if the rule suppresses event.
+func (r *SuppressRule) matches(event *EventRecord) bool {
+	var ip net.IP
+	switch r.Track {
+	case THRESHOLD_TRACK_NONE:
+		return true
+	case THRESHOLD_TRACK_BY_SRC:
+		ip = event.IpSource
+	case THRESHOLD_TRACK_BY_DST:
+		ip = event.IpDestination
+	}
+	for _, network := range r.ExcludedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	if len(r.Networks) == 0 {
+		// Only exclusions were given.
+		return true
+	}
+	for _, network := range r.Networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type thresholdKey struct {
+	generatorId uint32
+	signatureId uint32
+}
+
+type thresholdStateKey struct {
+	rule    *ThresholdRule
+	address string
+}
+
+type thresholdState struct {
+	start uint32
+	count int
+}
+
+// How many events to check between removals of expired threshold
+// state.
+const thresholdSweepInterval = 10000
+
+// Thresholder applies Snort threshold.conf style suppression and
+// thresholding to a stream of events after the fact, using the event
+// times rather than the time they are processed.
+//
+// Thresholders should be created with NewThresholder().
+type Thresholder struct {
+	thresholds map[thresholdKey]*ThresholdRule
+	suppress   map[thresholdKey][]*SuppressRule
+	state      map[thresholdStateKey]*thresholdState
+
+	// Whether the records following the last event are to be dropped.
+	dropping bool
+
+	events uint64
+}
+
+// NewThresholder creates a Thresholder with no rules.
+func NewThresholder() *Thresholder {
+	return &Thresholder{
+		thresholds: map[thresholdKey]*ThresholdRule{},
+		suppress:   map[thresholdKey][]*SuppressRule{},
+		state:      map[thresholdStateKey]*thresholdState{},
+	}
+}
+
+// AddThreshold adds a threshold rule.  Only one threshold rule may be
+// given for a generator and signature id.
+func (t *Thresholder) AddThreshold(rule ThresholdRule) error {
+	key := thresholdKey{rule.GeneratorId, rule.SignatureId}
+	if _, ok := t.thresholds[key]; ok {
+		return fmt.Errorf("duplicate threshold for %d:%d",
+			rule.GeneratorId, rule.SignatureId)
+	}
+	if rule.Count == 0 {
+		return fmt.Errorf("threshold for %d:%d has a count of 0",
+			rule.GeneratorId, rule.SignatureId)
+	}
+	t.thresholds[key] = &rule
+	return nil
+}
+
+// AddSuppress adds a suppression rule.
+func (t *Thresholder) AddSuppress(rule SuppressRule) {
+	key := thresholdKey{rule.GeneratorId, rule.SignatureId}
+	t.suppress[key] = append(t.suppress[key], &rule)
+}
+
+// ruleKeys returns the keys rules for an event may be found under,
+// most specific first.
+func ruleKeys(event *EventRecord) []thresholdKey {
+	return []thresholdKey{
+		{event.GeneratorId, event.SignatureId},
+		{event.GeneratorId, 0},
+		{0, 0},
+	}
+}
+
+// Allow returns true if an event should be logged.  Events must be
+// given in time order.
+func (t *Thresholder) Allow(event *EventRecord) bool {
+	t.events++
+	if t.events%thresholdSweepInterval == 0 {
+		t.sweep(event.EventSecond)
+	}
+
+	for _, key := range ruleKeys(event) {
+		for _, rule := range t.suppress[key] {
+			if rule.matches(event) {
+				return false
+			}
+		}
+	}
+
+	for _, key := range ruleKeys(event) {
+		if rule, ok := t.thresholds[key]; ok {
+			return t.allowThreshold(rule, event)
+		}
+	}
+
+	return true
+}
+
+func (t *Thresholder) allowThreshold(rule *ThresholdRule,
+	event *EventRecord) bool {
+	if rule.Count < 0 {
+		return true
+	}
+
+	key := thresholdStateKey{rule: rule}
+	switch rule.Track {
+	case THRESHOLD_TRACK_BY_SRC:
+		key.address = string(event.IpSource.To16())
+	case THRESHOLD_TRACK_BY_DST:
+		key.address = string(event.IpDestination.To16())
+	}
+
+	// As in Snort, an interval ends once more than Seconds have
+	// passed since it started.
+	state, ok := t.state[key]
+	if !ok || event.EventSecond-state.start > rule.Seconds {
+		state = &thresholdState{start: event.EventSecond}
+		t.state[key] = state
+	}
+	state.count++
+
+	switch rule.Type {
+	case THRESHOLD_TYPE_LIMIT:
+		return state.count <= rule.Count
+	case THRESHOLD_TYPE_THRESHOLD:
+		if state.count < rule.Count {
+			return false
+		}
+		state.count = 0
+		state.start = event.EventSecond
+		return true
+	case THRESHOLD_TYPE_BOTH:
+		return state.count == rule.Count
+	}
+	return true
+}
+
+// sweep removes state whose interval has expired.
+func (t *Thresholder) sweep(now uint32) {
+	for key, state := range t.state {
+		if now-state.start > key.rule.Seconds {
+			delete(t.state, key)
+		}
+	}
+}
+
+// Filter returns true if a record read with ReadRecord, or a reader's
+// Next method, should be kept.  Packet, buffer and extra data records
+// are dropped along with the event before them.
+func (t *Thresholder) Filter(record interface{}) bool {
+	switch record := record.(type) {
+	case *EventRecord:
+		t.dropping = !t.Allow(record)
+		return !t.dropping
+	case *PacketRecord, *BufferRecord, *ExtraDataRecord:
+		return !t.dropping
+	}
+	return true
+}
+
+// AllowEvent returns true if an aggregated event should be logged.
+func (t *Thresholder) AllowEvent(event *AggregatedEvent) bool {
+	return t.Allow(event.Event)
+}
+
+// LoadThresholdConf loads event_filter, threshold and suppress rules
+// from a Snort threshold.conf.  Addresses must be given as literal
+// addresses or networks, or lists of them, as variables are not
+// known.  rate_filter rules are ignored as they change rule actions,
+// which can not be done after the fact.
+func (t *Thresholder) LoadThresholdConf(reader io.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	lineno := 0
+	var line string
+
+	for scanner.Scan() {
+		lineno++
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+
+		// Lines ending in a backslash are continued.
+		if strings.HasSuffix(text, "\\") {
+			line += strings.TrimSuffix(text, "\\") + " "
+			continue
+		}
+		line += text
+		if line == "" {
+			continue
+		}
+
+		err := t.parseThresholdLine(line)
+		line = ""
+		if err != nil {
+			return fmt.Errorf("line %d: %s", lineno, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (t *Thresholder) parseThresholdLine(line string) error {
+	fields := strings.Fields(line)
+	keyword := fields[0]
+	options, err := parseThresholdOptions(
+		strings.TrimSpace(strings.TrimPrefix(line, keyword)))
+	if err != nil {
+		return err
+	}
+
+	var generatorId, signatureId uint64
+	if generatorId, err = strconv.ParseUint(options["gen_id"], 10, 32); err != nil {
+		return fmt.Errorf("bad or missing gen_id")
+	}
+	if signatureId, err = strconv.ParseUint(options["sig_id"], 10, 32); err != nil {
+		return fmt.Errorf("bad or missing sig_id")
+	}
+
+	var track int
+	switch options["track"] {
+	case "":
+		track = THRESHOLD_TRACK_NONE
+	case "by_src":
+		track = THRESHOLD_TRACK_BY_SRC
+	case "by_dst":
+		track = THRESHOLD_TRACK_BY_DST
+	default:
+		return fmt.Errorf("bad track: %s", options["track"])
+	}
+
+	switch keyword {
+	case "threshold", "event_filter":
+		rule := ThresholdRule{
+			GeneratorId: uint32(generatorId),
+			SignatureId: uint32(signatureId),
+			Track:       track,
+		}
+		switch options["type"] {
+		case "limit":
+			rule.Type = THRESHOLD_TYPE_LIMIT
+		case "threshold":
+			rule.Type = THRESHOLD_TYPE_THRESHOLD
+		case "both":
+			rule.Type = THRESHOLD_TYPE_BOTH
+		default:
+			return fmt.Errorf("bad or missing type: %s", options["type"])
+		}
+		if track == THRESHOLD_TRACK_NONE {
+			return fmt.Errorf("missing track")
+		}
+		if rule.Count, err = strconv.Atoi(options["count"]); err != nil {
+			return fmt.Errorf("bad or missing count")
+		}
+		seconds, err := strconv.ParseUint(options["seconds"], 10, 32)
+		if err != nil {
+			return fmt.Errorf("bad or missing seconds")
+		}
+		rule.Seconds = uint32(seconds)
+		return t.AddThreshold(rule)
+	case "suppress":
+		rule := SuppressRule{
+			GeneratorId: uint32(generatorId),
+			SignatureId: uint32(signatureId),
+			Track:       track,
+		}
+		if ip, ok := options["ip"]; ok {
+			if track == THRESHOLD_TRACK_NONE {
+				return fmt.Errorf("ip given without track")
+			}
+			if err := parseThresholdAddresses(ip, false, &rule); err != nil {
+				return err
+			}
+		} else if track != THRESHOLD_TRACK_NONE {
+			return fmt.Errorf("track given without ip")
+		}
+		t.AddSuppress(rule)
+		return nil
+	case "rate_filter":
+		return nil
+	}
+
+	return fmt.Errorf("unsupported keyword: %s", keyword)
+}
+
+// parseThresholdOptions parses the comma separated "name value"
+// options of a threshold.conf line.  Commas inside brackets are part
+// of an address list.
+func parseThresholdOptions(value string) (map[string]string, error) {
+	options := map[string]string{}
+	depth := 0
+	start := 0
+	for i := 0; i <= len(value); i++ {
+		if i < len(value) {
+			switch value[i] {
+			case '[':
+				depth++
+				continue
+			case ']':
+				depth--
+				continue
+			case ',':
+				if depth > 0 {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+		option := strings.TrimSpace(value[start:i])
+		start = i + 1
+		if option == "" {
+			continue
+		}
+		parts := strings.Fields(option)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("option without value: %s", option)
+		}
+		options[parts[0]] = strings.Join(parts[1:], "")
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced brackets")
+	}
+	return options, nil
+}
+
+// parseThresholdAddresses parses an address, network or bracketed list
+// of them, any of which may be negated with !, into the networks of a
+// suppress rule.
+func parseThresholdAddresses(value string, negated bool,
+	rule *SuppressRule) error {
+	value = strings.TrimSpace(value)
+	for strings.HasPrefix(value, "!") {
+		negated = !negated
+		value = strings.TrimSpace(value[1:])
+	}
+
+	if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+		value = value[1 : len(value)-1]
+		depth := 0
+		start := 0
+		for i := 0; i <= len(value); i++ {
+			if i < len(value) {
+				switch value[i] {
+				case '[':
+					depth++
+				case ']':
+					depth--
+				}
+				if value[i] != ',' || depth > 0 {
+					continue
+				}
+			}
+			err := parseThresholdAddresses(value[start:i], negated, rule)
+			if err != nil {
+				return err
+			}
+			start = i + 1
+		}
+		return nil
+	}
+
+	var network *net.IPNet
+	if strings.Contains(value, "/") {
+		var err error
+		if _, network, err = net.ParseCIDR(value); err != nil {
+			return fmt.Errorf("bad network: %s", value)
+		}
+	} else if ip := net.ParseIP(value); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			network = &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+		} else {
+			network = &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+		}
+	} else {
+		return fmt.Errorf("bad address: %s", value)
+	}
+
+	if negated {
+		rule.ExcludedNetworks = append(rule.ExcludedNetworks, network)
+	} else {
+		rule.Networks = append(rule.Networks, network)
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package unified2
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func thresholdEvent(sid uint32, second uint32, src string,
+	dst string) *EventRecord {
+	return &EventRecord{
+		GeneratorId:   1,
+		SignatureId:   sid,
+		EventSecond:   second,
+		IpSource:      net.ParseIP(src).To4(),
+		IpDestination: net.ParseIP(dst).To4(),
+	}
+}
+
+// allowed returns the seconds of the events, one per second from 0,
+// that are allowed.
+func allowed(thresholder *Thresholder, sid uint32, seconds uint32,
+	src string) []uint32 {
+	var allowed []uint32
+	for second := uint32(0); second < seconds; second++ {
+		event := thresholdEvent(sid, second, src, "10.0.0.1")
+		if thresholder.Allow(event) {
+			allowed = append(allowed, second)
+		}
+	}
+	return allowed
+}
+
+func equalSeconds(a []uint32, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestThresholdTypes(t *testing.T) {
+	tests := []struct {
+		conf     string
+		expected []uint32
+	}{
+		{"event_filter gen_id 1, sig_id 1, type limit, track by_src, count 2, seconds 10",
+			[]uint32{0, 1, 11, 12}},
+		{"threshold gen_id 1, sig_id 1, type threshold, track by_src, count 3, seconds 10",
+			[]uint32{2, 5, 8, 11, 14, 17}},
+		{"event_filter gen_id 1, sig_id 1, type both, track by_src, count 3, seconds 10",
+			[]uint32{2, 13}},
+		{"event_filter gen_id 1, sig_id 1, type limit, track by_src, count -1, seconds 10",
+			[]uint32{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+				16, 17, 18, 19}},
+	}
+	for _, test := range tests {
+		thresholder := NewThresholder()
+		if err := thresholder.LoadThresholdConf(
+			strings.NewReader(test.conf)); err != nil {
+			t.Fatal(err)
+		}
+		result := allowed(thresholder, 1, 20, "192.168.1.1")
+		if !equalSeconds(result, test.expected) {
+			t.Fatalf("%s: expected %v, got %v", test.conf,
+				test.expected, result)
+		}
+
+		// Other signatures are not affected.
+		if result := allowed(thresholder, 2, 20, "192.168.1.1"); len(result) != 20 {
+			t.Fatalf("%s: other signature filtered: %v", test.conf,
+				result)
+		}
+	}
+}
+
+func TestThresholdTrack(t *testing.T) {
+	thresholder := NewThresholder()
+	if err := thresholder.LoadThresholdConf(strings.NewReader(`
+event_filter gen_id 1, sig_id 1, type limit, track by_src, count 1, seconds 60
+event_filter gen_id 1, sig_id 2, type limit, track by_dst, count 1, seconds 60
+`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tracked by source, so each source gets its own limit.
+	for _, src := range []string{"192.168.1.1", "192.168.1.2"} {
+		if !thresholder.Allow(thresholdEvent(1, 0, src, "10.0.0.1")) {
+			t.Fatalf("first event from %s not allowed", src)
+		}
+	}
+	if thresholder.Allow(thresholdEvent(1, 1, "192.168.1.1", "10.0.0.2")) {
+		t.Fatal("second event from source allowed")
+	}
+
+	// Tracked by destination.
+	if !thresholder.Allow(thresholdEvent(2, 0, "192.168.1.1", "10.0.0.1")) {
+		t.Fatal("first event to destination not allowed")
+	}
+	if thresholder.Allow(thresholdEvent(2, 1, "192.168.1.2", "10.0.0.1")) {
+		t.Fatal("second event to destination allowed")
+	}
+	if !thresholder.Allow(thresholdEvent(2, 1, "192.168.1.2", "10.0.0.2")) {
+		t.Fatal("first event to other destination not allowed")
+	}
+}
+
+func TestThresholdPrecedence(t *testing.T) {
+	thresholder := NewThresholder()
+	if err := thresholder.LoadThresholdConf(strings.NewReader(`
+# Global limit, overridden for 1:1.
+event_filter gen_id 0, sig_id 0, type limit, track by_src, count 1, seconds 60
+event_filter gen_id 1, sig_id 1, type limit, track by_src, count 3, seconds 60
+`)); err != nil {
+		t.Fatal(err)
+	}
+	if result := allowed(thresholder, 1, 10, "192.168.1.1"); len(result) != 3 {
+		t.Fatalf("expected 3 events, got %v", result)
+	}
+	if result := allowed(thresholder, 2, 10, "192.168.1.1"); len(result) != 1 {
+		t.Fatalf("expected 1 event, got %v", result)
+	}
+}
+
+func TestSuppress(t *testing.T) {
+	thresholder := NewThresholder()
+	if err := thresholder.LoadThresholdConf(strings.NewReader(`
+suppress gen_id 1, sig_id 1
+suppress gen_id 1, sig_id 2, track by_src, ip 192.168.1.0/24
+suppress gen_id 1, sig_id 3, track by_dst, \
+    ip [10.0.0.0/8, !10.1.0.0/16, 172.16.0.1]
+suppress gen_id 1, sig_id 4, track by_src, ip !192.168.1.1
+`)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sid     uint32
+		src     string
+		dst     string
+		allowed bool
+	}{
+		{1, "192.168.1.1", "10.0.0.1", false},
+		{2, "192.168.1.1", "10.0.0.1", false},
+		{2, "192.168.2.1", "10.0.0.1", true},
+		{3, "192.168.1.1", "10.0.0.1", false},
+		{3, "192.168.1.1", "10.1.0.1", true},
+		{3, "192.168.1.1", "172.16.0.1", false},
+		{3, "192.168.1.1", "172.16.0.2", true},
+		{4, "192.168.1.1", "10.0.0.1", true},
+		{4, "192.168.1.2", "10.0.0.1", false},
+		{5, "192.168.1.1", "10.0.0.1", true},
+	}
+	for _, test := range tests {
+		event := thresholdEvent(test.sid, 0, test.src, test.dst)
+		if thresholder.Allow(event) != test.allowed {
+			t.Fatalf("%+v: expected allowed to be %v", test,
+				test.allowed)
+		}
+	}
+}
+
+func TestThresholdFilter(t *testing.T) {
+	thresholder := NewThresholder()
+	thresholder.AddSuppress(SuppressRule{GeneratorId: 1, SignatureId: 1})
+
+	records := []interface{}{
+		thresholdEvent(1, 0, "192.168.1.1", "10.0.0.1"),
+		&PacketRecord{},
+		&ExtraDataRecord{},
+		thresholdEvent(2, 0, "192.168.1.1", "10.0.0.1"),
+		&PacketRecord{},
+	}
+	expected := []bool{false, false, false, true, true}
+	for i, record := range records {
+		if thresholder.Filter(record) != expected[i] {
+			t.Fatalf("record %d: expected %v", i, expected[i])
+		}
+	}
+}
+
+func TestSpoolBatcherFilterAll(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	copyFile("test/multi-record-event-x2.log",
+		fmt.Sprintf("%s/merged.log.1382627900", tmpdir))
+
+	// With every event suppressed there is nothing to send, but the
+	// position must still be checkpointed.
+	thresholder := NewThresholder()
+	thresholder.AddSuppress(SuppressRule{GeneratorId: 120, SignatureId: 3})
+
+	bookmarks := make(chan *Bookmark, 10)
+	batcher := &SpoolBatcher{
+		Reader: NewSpoolRecordReader(tmpdir, "merged.log"),
+		Send: func(events []*AggregatedEvent) error {
+			t.Errorf("unexpected send of %d events", len(events))
+			return nil
+		},
+		Filter: func(event *AggregatedEvent) bool {
+			return thresholder.Allow(event.Event)
+		},
+		FlushInterval:    time.Millisecond,
+		PollInterval:     time.Millisecond,
+		BookmarkFilename: tmpdir + "/bookmark",
+		Checkpoint: func(bookmark *Bookmark) {
+			bookmarks <- bookmark
+		},
+	}
+
+	stop := make(chan bool)
+	done := make(chan error)
+	go func() {
+		done <- batcher.Run(stop)
+	}()
+
+	info, err := os.Stat("test/multi-record-event-x2.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Bookmark{"merged.log.1382627900", info.Size()}
+	deadline := time.After(5 * time.Second)
+	for bookmark := (&Bookmark{}); *bookmark != expected; {
+		select {
+		case bookmark = <-bookmarks:
+		case <-deadline:
+			t.Fatal("timed out")
+		}
+	}
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	bookmark, err := ReadBookmark(tmpdir + "/bookmark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *bookmark != expected {
+		t.Fatalf("unexpected bookmark: %+v", bookmark)
+	}
+}
+
+func TestLoadThresholdConfErrors(t *testing.T) {
+	tests := []string{
+		"event_filter gen_id 1, sig_id 1, type limit, track by_src, count 1",
+		"event_filter gen_id 1, sig_id 1, type bad, track by_src, count 1, seconds 1",
+		"event_filter gen_id 1, type limit, track by_src, count 1, seconds 1",
+		"suppress gen_id 1, sig_id 1, track by_src, ip $HOME_NET",
+		"suppress gen_id 1, sig_id 1, track by_src",
+		"suppress gen_id 1, sig_id 1, track by_src, ip [10.0.0.1",
+		"unknown gen_id 1, sig_id 1",
+		"event_filter gen_id 1, sig_id 1, type limit, track by_src, count 1, seconds 1\n" +
+			"event_filter gen_id 1, sig_id 1, type limit, track by_src, count 1, seconds 1",
+	}
+	for _, test := range tests {
+		err := NewThresholder().LoadThresholdConf(strings.NewReader(test))
+		if err == nil {
+			t.Fatalf("expected error loading %q", test)
+		}
+	}
+
+	// rate_filter is ignored.
+	if err := NewThresholder().LoadThresholdConf(strings.NewReader(
+		"rate_filter gen_id 135, sig_id 1, track by_src, count 100, seconds 1, new_action drop, timeout 10")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -40,14 +40,35 @@ import (
 
 // Unified2 record types.
 const (
-	UNIFIED2_PACKET          = 2
-	UNIFIED2_EVENT           = 7
-	UNIFIED2_EVENT_IP6       = 72
-	UNIFIED2_EVENT_V2        = 104
-	UNIFIED2_EVENT_V2_IP6    = 105
-	UNIFIED2_EXTRA_DATA      = 110
-	UNIFIED2_EVENT_APPID     = 111
-	UNIFIED2_EVENT_APPID_IP6 = 112
+	UNIFIED2_PACKET            = 2
+	UNIFIED2_BUFFER            = 3
+	UNIFIED2_EVENT             = 7
+	UNIFIED2_EVENT_IP6         = 72
+	UNIFIED2_EVENT_MPLS        = 99
+	UNIFIED2_EVENT_MPLS_IP6    = 100
+	UNIFIED2_EVENT_V2          = 104
+	UNIFIED2_EVENT_V2_IP6      = 105
+	UNIFIED2_EXTRA_DATA        = 110
+	UNIFIED2_EVENT_APPID       = 111
+	UNIFIED2_EVENT_APPID_IP6   = 112
+	UNIFIED2_IDS_EVENT_APPSTAT = 113
+	UNIFIED2_EVENT_V3          = 114
+)
+
+// Snort 3 event v3 status values.
+const (
+	EVENT_STATUS_ALLOW = 0
+	EVENT_STATUS_CANT  = 1
+	EVENT_STATUS_WOULD = 2
+	EVENT_STATUS_FORCE = 3
+)
+
+// Snort 3 event v3 action values.
+const (
+	EVENT_ACTION_PASS  = 0
+	EVENT_ACTION_DROP  = 1
+	EVENT_ACTION_BLOCK = 2
+	EVENT_ACTION_RESET = 3
 )
 
 // RawHeader is the raw unified2 record header.
@@ -67,6 +88,9 @@ type RawRecord struct {
 // This struct is used to represent the decoded form of all the event
 // types.  The difference between an IPv4 and IPv6 event will be the
 // length of the IP address IpSource and IpDestination.
+//
+// The policy ids, Status and Action are only set by Snort 3 event v3
+// records.
 type EventRecord struct {
 	SensorId          uint32
 	EventId           uint32
@@ -89,6 +113,11 @@ type EventRecord struct {
 	VlanId            uint16
 	Pad2              uint16
 	AppId             string
+	PolicyIdContext   uint32
+	PolicyIdInspect   uint32
+	PolicyIdDetect    uint32
+	Status            uint8
+	Action            uint8
 }
 
 // PacketRecord is a struct representing a decoded packet record.
@@ -106,6 +135,11 @@ type PacketRecord struct {
 // The length of a PacketRecord before variable length data.
 const PACKET_RECORD_HDR_LEN = 28
 
+// BufferRecord is a decoded buffer record, as logged by Snort 3 for
+// inspection buffers such as normalized HTTP data.  It has the same
+// layout as a PacketRecord.
+type BufferRecord PacketRecord
+
 // ExtraDataRecord is a struct representing a decoded extra data record.
 type ExtraDataRecord struct {
 	EventType   uint32
@@ -122,6 +156,34 @@ type ExtraDataRecord struct {
 // The length of an ExtraDataRecord before variable length data.
 const EXTRA_DATA_RECORD_HDR_LEN = 32
 
+// The EventType Snort writes in extra data records.
+const EXTRA_DATA_EVENT_TYPE = 4
+
+// AppStatRecord is a decoded application statistics record, as logged
+// periodically by Snort 2.9 with OpenAppId.
+type AppStatRecord struct {
+	TimestampSeconds uint32
+	Stats            []AppStat
+}
+
+// AppStat is the traffic seen for one application in an
+// AppStatRecord.
+type AppStat struct {
+	AppName string
+	TxBytes uint32
+	RxBytes uint32
+}
+
+// The length of an AppStatRecord before its stats, and of each stat.
+const (
+	APPSTAT_RECORD_HDR_LEN = 8
+	APPSTAT_LEN            = 72
+)
+
+// Records longer than this are checked against the size of the file
+// by ReadRawRecord before their data is read.
+const MAX_UNCHECKED_RECORD_LEN = 1024 * 1024
+
 // ReadRawRecord reads a raw record from the provided file.
 //
 // On error, err will no non-nil.  Expected error values are io.EOF
@@ -145,6 +207,23 @@ func ReadRawRecord(file io.ReadWriteSeeker) (*RawRecord, error) {
 		return nil, err
 	}
 
+	/* A corrupt length could ask for gigabytes, so check large
+	/* records against the size of the file before allocating. */
+	if header.Len > MAX_UNCHECKED_RECORD_LEN {
+		end, err := file.Seek(0, 2)
+		if err == nil {
+			_, err = file.Seek(offset+8, 0)
+		}
+		if err != nil {
+			file.Seek(offset, 0)
+			return nil, err
+		}
+		if end-(offset+8) < int64(header.Len) {
+			file.Seek(offset, 0)
+			return nil, io.ErrUnexpectedEOF
+		}
+	}
+
 	/* Create a buffer to hold the raw record data and read the
 	/* record data into it */
 	data := make([]byte, header.Len)
@@ -176,6 +255,8 @@ func ReadRawRecord(file io.ReadWriteSeeker) (*RawRecord, error) {
 // If an error occurred during decoding of the read data a
 // DecodingError will be returned.  This likely means the input is
 // corrupt.
+//
+// Records of unknown types are returned as a *RawRecord.
 func ReadRecord(file io.ReadWriteSeeker) (interface{}, error) {
 
 	record, err := ReadRawRecord(file)
@@ -191,20 +272,25 @@ func ReadRecord(file io.ReadWriteSeeker) (interface{}, error) {
 		UNIFIED2_EVENT_V2,
 		UNIFIED2_EVENT_V2_IP6,
 		UNIFIED2_EVENT_APPID,
-		UNIFIED2_EVENT_APPID_IP6:
+		UNIFIED2_EVENT_APPID_IP6,
+		UNIFIED2_EVENT_MPLS,
+		UNIFIED2_EVENT_MPLS_IP6,
+		UNIFIED2_EVENT_V3:
 		decoded, err = DecodeEventRecord(record.Type, record.Data)
 	case UNIFIED2_PACKET:
 		decoded, err = DecodePacketRecord(record.Data)
+	case UNIFIED2_BUFFER:
+		decoded, err = DecodeBufferRecord(record.Data)
 	case UNIFIED2_EXTRA_DATA:
 		decoded, err = DecodeExtraDataRecord(record.Data)
+	case UNIFIED2_IDS_EVENT_APPSTAT:
+		decoded, err = DecodeAppStatRecord(record.Data)
+	default:
+		decoded = record
 	}
 
 	if err != nil {
 		return nil, err
-	} else if decoded != nil {
-		return decoded, nil
-	} else {
-		// Unknown record type.
-		return nil, nil
 	}
+	return decoded, nil
 }
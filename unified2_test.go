@@ -1,11 +1,22 @@
 package unified2
 
 import (
+	"bytes"
+	"errors"
 	"io"
 	"os"
 	"testing"
 )
 
+// memoryFile is a read only, in memory io.ReadWriteSeeker.
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (f memoryFile) Write(p []byte) (int, error) {
+	return 0, errors.New("read only")
+}
+
 // Check that we get EOF at the end of a file.
 func TestReadRecordEOF(t *testing.T) {
 
@@ -109,7 +120,7 @@ func TestRecordReaderWithOffset(t *testing.T) {
 
 	offset := reader.Offset()
 	if offset == 0 {
-		t.Fatal("unpexpected offset %d", offset)
+		t.Fatalf("unpexpected offset %d", offset)
 	}
 
 	// Close and reopen with offset, check offset and make sure the
@@ -132,3 +143,30 @@ func TestRecordReaderWithOffset(t *testing.T) {
 	}
 
 }
+
+func TestReadRawRecordCorruptLength(t *testing.T) {
+	// A header claiming a 4GB record followed by a few bytes.
+	buf := []byte{0, 0, 0, 2, 0xff, 0xff, 0xff, 0xff, 1, 2, 3, 4}
+	file := memoryFile{bytes.NewReader(buf)}
+	if _, err := ReadRawRecord(file); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if offset, _ := file.Seek(0, 1); offset != 0 {
+		t.Fatalf("expected offset 0, got %d", offset)
+	}
+}
+
+func TestDecodeShortRecords(t *testing.T) {
+	if _, err := DecodeEventRecord(UNIFIED2_PACKET, make([]byte, 60)); err != DecodingError {
+		t.Fatalf("expected DecodingError, got %v", err)
+	}
+	if _, err := DecodeEventRecord(UNIFIED2_EVENT_V2, make([]byte, 40)); err == nil {
+		t.Fatal("expected error decoding short event")
+	}
+	if _, err := DecodePacketRecord(make([]byte, 27)); err != DecodingError {
+		t.Fatalf("expected DecodingError, got %v", err)
+	}
+	if _, err := DecodeExtraDataRecord(make([]byte, 31)); err != DecodingError {
+		t.Fatalf("expected DecodingError, got %v", err)
+	}
+}
@@ -0,0 +1,263 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"fmt"
+	"io"
+)
+
+// Kinds of problems reported by Validate.
+const (
+	ISSUE_UNKNOWN_TYPE     = "unknown-record-type"
+	ISSUE_LENGTH_MISMATCH  = "length-mismatch"
+	ISSUE_ORPHAN_RECORD    = "orphan-record"
+	ISSUE_TIMESTAMP_ORDER  = "non-monotonic-timestamp"
+	ISSUE_TRUNCATED_RECORD = "truncated-record"
+	ISSUE_ADDRESS_FAMILY   = "address-family-mismatch"
+	ISSUE_DECODING_ERROR   = "decoding-error"
+)
+
+// The length of each fixed length event record type.
+var eventRecordLengths = map[uint32]int{
+	UNIFIED2_EVENT:           52,
+	UNIFIED2_EVENT_IP6:       76,
+	UNIFIED2_EVENT_V2:        60,
+	UNIFIED2_EVENT_V2_IP6:    84,
+	UNIFIED2_EVENT_APPID:     124,
+	UNIFIED2_EVENT_APPID_IP6: 148,
+	UNIFIED2_EVENT_MPLS:      60,
+	UNIFIED2_EVENT_MPLS_IP6:  84,
+	UNIFIED2_EVENT_V3:        160,
+}
+
+// The IPv6 equivalent of each IPv4 event record type.
+var eventRecordIP6Types = map[uint32]uint32{
+	UNIFIED2_EVENT:       UNIFIED2_EVENT_IP6,
+	UNIFIED2_EVENT_V2:    UNIFIED2_EVENT_V2_IP6,
+	UNIFIED2_EVENT_APPID: UNIFIED2_EVENT_APPID_IP6,
+	UNIFIED2_EVENT_MPLS:  UNIFIED2_EVENT_MPLS_IP6,
+}
+
+// ValidationIssue describes a problem found in a unified2 file.
+type ValidationIssue struct {
+	// Offset is the file offset of the record with the problem.
+	Offset int64 `json:"offset"`
+
+	// Type is the record type, if the record header could be read.
+	Type uint32 `json:"type"`
+
+	// Kind is one of the ISSUE_ constants.
+	Kind string `json:"kind"`
+
+	Message string `json:"message"`
+}
+
+func (i ValidationIssue) String() string {
+	return fmt.Sprintf("offset %d: type %d: %s: %s", i.Offset, i.Type,
+		i.Kind, i.Message)
+}
+
+// ValidationReport is the result of validating a unified2 file.
+type ValidationReport struct {
+	// Records is the number of complete records read.
+	Records int `json:"records"`
+
+	// RecordTypes is the number of records read of each type.
+	RecordTypes map[uint32]int `json:"record_types"`
+
+	// IssueCounts is the number of issues found of each kind.
+	IssueCounts map[string]int `json:"issue_counts"`
+
+	Issues []ValidationIssue `json:"issues"`
+}
+
+// Valid returns true if no issues were found.
+func (r *ValidationReport) Valid() bool {
+	return len(r.Issues) == 0
+}
+
+func (r *ValidationReport) add(offset int64, recordType uint32, kind string,
+	format string, v ...interface{}) {
+	r.Issues = append(r.Issues, ValidationIssue{offset, recordType, kind,
+		fmt.Sprintf(format, v...)})
+	r.IssueCounts[kind]++
+}
+
+type eventKey struct {
+	eventId     uint32
+	eventSecond uint32
+}
+
+// Validate reads all the records in file checking that it is a sound
+// unified2 file.  It reports unknown record types, records whose
+// length does not match their type, packet and extra data records that
+// do not follow an event they reference, events that are earlier than
+// the event before them, IPv4 event records that are the length of an
+// IPv6 event or have IPv4 mapped addresses in an IPv6 event, and
+// incomplete records at the end of the file.
+//
+// An error is only returned if the file could not be read; problems
+// with its content are returned in the report.
+func Validate(file io.ReadWriteSeeker) (*ValidationReport, error) {
+	report := &ValidationReport{
+		RecordTypes: map[uint32]int{},
+		IssueCounts: map[string]int{},
+		Issues:      []ValidationIssue{},
+	}
+
+	events := map[eventKey]bool{}
+	var lastTime uint64
+
+	for {
+		offset, err := file.Seek(0, 1)
+		if err != nil {
+			return report, err
+		}
+
+		record, err := ReadRawRecord(file)
+		if err == io.EOF {
+			break
+		} else if err == io.ErrUnexpectedEOF {
+			end, _ := file.Seek(0, 2)
+			report.add(offset, 0, ISSUE_TRUNCATED_RECORD,
+				"%d bytes of incomplete record at end of file",
+				end-offset)
+			break
+		} else if err != nil {
+			return report, err
+		}
+
+		report.Records++
+		report.RecordTypes[record.Type]++
+
+		switch {
+
+		case isEventType(record.Type):
+			length := len(record.Data)
+			if ip6Type, ok := eventRecordIP6Types[record.Type]; ok &&
+				length == eventRecordLengths[ip6Type] {
+				report.add(offset, record.Type, ISSUE_ADDRESS_FAMILY,
+					"IPv4 event record has the length of an IPv6 event record")
+				continue
+			}
+			if length != eventRecordLengths[record.Type] {
+				report.add(offset, record.Type, ISSUE_LENGTH_MISMATCH,
+					"expected length %d, got %d",
+					eventRecordLengths[record.Type], length)
+				continue
+			}
+
+			event, err := DecodeEventRecord(record.Type, record.Data)
+			if err != nil {
+				report.add(offset, record.Type, ISSUE_DECODING_ERROR,
+					"%s", err)
+				continue
+			}
+			events[eventKey{event.EventId, event.EventSecond}] = true
+
+			if len(event.IpSource) == 16 &&
+				(event.IpSource.To4() != nil ||
+					event.IpDestination.To4() != nil) {
+				report.add(offset, record.Type, ISSUE_ADDRESS_FAMILY,
+					"IPv6 event record has IPv4 addresses %s -> %s",
+					event.IpSource, event.IpDestination)
+			}
+
+			eventTime := uint64(event.EventSecond)*1000000 +
+				uint64(event.EventMicrosecond)
+			if eventTime < lastTime {
+				report.add(offset, record.Type, ISSUE_TIMESTAMP_ORDER,
+					"event %d.%06d is earlier than the previous event",
+					event.EventSecond, event.EventMicrosecond)
+			}
+			lastTime = eventTime
+
+		case isPacketType(record.Type):
+			if len(record.Data) < PACKET_RECORD_HDR_LEN {
+				report.add(offset, record.Type, ISSUE_LENGTH_MISMATCH,
+					"record of %d bytes is shorter than the packet header",
+					len(record.Data))
+				continue
+			}
+			packet, err := DecodePacketRecord(record.Data)
+			if err != nil {
+				report.add(offset, record.Type, ISSUE_DECODING_ERROR,
+					"%s", err)
+				continue
+			}
+			if int(packet.Length) != len(packet.Data) {
+				report.add(offset, record.Type, ISSUE_LENGTH_MISMATCH,
+					"packet length %d, but record has %d bytes of packet data",
+					packet.Length, len(packet.Data))
+			}
+			if !events[eventKey{packet.EventId, packet.EventSecond}] {
+				report.add(offset, record.Type, ISSUE_ORPHAN_RECORD,
+					"packet references event %d at second %d which does not precede it",
+					packet.EventId, packet.EventSecond)
+			}
+
+		case record.Type == UNIFIED2_EXTRA_DATA:
+			if len(record.Data) < EXTRA_DATA_RECORD_HDR_LEN {
+				report.add(offset, record.Type, ISSUE_LENGTH_MISMATCH,
+					"record of %d bytes is shorter than the extra data header",
+					len(record.Data))
+				continue
+			}
+			extra, err := DecodeExtraDataRecord(record.Data)
+			if err != nil {
+				report.add(offset, record.Type, ISSUE_DECODING_ERROR,
+					"%s", err)
+				continue
+			}
+
+			// The data length includes the type and length fields.
+			if int(extra.DataLength) != len(extra.Data)+8 {
+				report.add(offset, record.Type, ISSUE_LENGTH_MISMATCH,
+					"extra data length %d, but record has %d bytes of data",
+					extra.DataLength, len(extra.Data))
+			}
+			if !events[eventKey{extra.EventId, extra.EventSecond}] {
+				report.add(offset, record.Type, ISSUE_ORPHAN_RECORD,
+					"extra data references event %d at second %d which does not precede it",
+					extra.EventId, extra.EventSecond)
+			}
+
+		case record.Type == UNIFIED2_IDS_EVENT_APPSTAT:
+			if _, err := DecodeAppStatRecord(record.Data); err != nil {
+				report.add(offset, record.Type, ISSUE_DECODING_ERROR,
+					"%s", err)
+			}
+
+		default:
+			report.add(offset, record.Type, ISSUE_UNKNOWN_TYPE,
+				"unknown record type %d", record.Type)
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,88 @@
+package unified2
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func validateFile(t *testing.T, filename string) *ValidationReport {
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	report, err := Validate(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestValidate(t *testing.T) {
+	report := validateFile(t, "test/multi-record-event.log")
+	if !report.Valid() {
+		t.Fatalf("unexpected issues: %v", report.Issues)
+	}
+	if report.Records != 17 {
+		t.Fatalf("expected 17 records, got %d", report.Records)
+	}
+	if report.RecordTypes[UNIFIED2_PACKET] != 15 {
+		t.Fatalf("expected 15 packets, got %d",
+			report.RecordTypes[UNIFIED2_PACKET])
+	}
+}
+
+func TestValidateTruncated(t *testing.T) {
+	report := validateFile(t, "test/short-read-on-body.log")
+	if report.IssueCounts[ISSUE_TRUNCATED_RECORD] != 1 {
+		t.Fatalf("expected truncated record, got %v", report.Issues)
+	}
+}
+
+func TestValidateIssues(t *testing.T) {
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	event, packet := loadTemplateRecords(t)
+
+	writeTestFile(t, tmpdir+"/test.log", []uint32{200, 100})
+	file, err := os.OpenFile(tmpdir+"/test.log", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A packet for an event not in the file.
+	WriteRawRecord(file, packet)
+
+	// An unknown record type.
+	WriteRawRecord(file, &RawRecord{999, []byte{1, 2, 3, 4}})
+
+	// An IPv4 event with the length of an IPv6 event.
+	WriteRawRecord(file, &RawRecord{UNIFIED2_EVENT_V2,
+		append(event.Data, make([]byte, 24)...)})
+
+	// A short event.
+	WriteRawRecord(file, &RawRecord{UNIFIED2_EVENT_V2, event.Data[0:40]})
+
+	file.Close()
+
+	report := validateFile(t, tmpdir+"/test.log")
+	for kind, count := range map[string]int{
+		ISSUE_TIMESTAMP_ORDER:  1,
+		ISSUE_ORPHAN_RECORD:    1,
+		ISSUE_UNKNOWN_TYPE:     1,
+		ISSUE_ADDRESS_FAMILY:   1,
+		ISSUE_LENGTH_MISMATCH:  1,
+		ISSUE_TRUNCATED_RECORD: 0,
+	} {
+		if report.IssueCounts[kind] != count {
+			t.Fatalf("expected %d %s issues, got %v", count, kind,
+				report.Issues)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebhookSink posts events as JSON to an HTTP endpoint.
+//
+// Delivery is at-least-once.  If QueueDirectory is set, events that
+// can not be delivered are saved there and delivered, in order, before
+// any new events once the endpoint is reachable again.  Send only
+// returns once events have been delivered or queued, so its use as the
+// Send function of a SpoolBatcher only advances the spool bookmark
+// after then.
+//
+// Requests the endpoint rejects as invalid, with 400 Bad Request or
+// 422 Unprocessable Entity, are not retried.  Send returns a
+// *PermanentError for them, and they are saved in, or moved aside
+// within, the queue with a .failed suffix.  Any other failure,
+// including 401 Unauthorized, is retried.
+//
+// WebhookSinks should be created with NewWebhookSink().
+type WebhookSink struct {
+	URL string
+
+	// Headers are added to every request.
+	Headers http.Header
+
+	// Token, if set, is sent as a bearer token in the Authorization
+	// header.
+	Token string
+
+	// Batch posts each batch of events as a JSON array rather than an
+	// object per request.
+	Batch bool
+
+	// QueueDirectory, if set, is where undelivered requests are saved.
+	QueueDirectory string
+
+	// MaxQueued, if greater than 0, is the maximum number of requests
+	// saved in QueueDirectory.  Once it is reached Send returns
+	// WebhookQueueFull rather than queueing more.
+	MaxQueued int
+
+	// RetryInterval is the minimum time between attempts to deliver
+	// queued requests after a failure.
+	RetryInterval time.Duration
+
+	// Signatures, if set, are used to add signature messages.
+	Signatures *SignatureMap
+
+	Client *http.Client
+
+	// Failed is the number of requests the endpoint rejected as
+	// invalid.
+	Failed int
+
+	lock      sync.Mutex
+	lastError time.Time
+	sequence  int
+}
+
+// WebhookQueueFull is returned by WebhookSink.Send when events can not
+// be delivered and the queue already holds MaxQueued requests.
+var WebhookQueueFull = errors.New("webhook queue is full")
+
+// NewWebhookSink creates a new WebhookSink posting to url.  If
+// tlsConfig is not nil it is used for https connections.
+func NewWebhookSink(url string, tlsConfig *tls.Config) *WebhookSink {
+	client := http.DefaultClient
+	if tlsConfig != nil {
+		client = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		}
+	}
+	return &WebhookSink{
+		URL:           url,
+		Headers:       http.Header{},
+		RetryInterval: 10 * time.Second,
+		MaxQueued:     10000,
+		Client:        client,
+	}
+}
+
+// post makes a single request.
+func (s *WebhookSink) post(body []byte) error {
+	request, err := http.NewRequest("POST", s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range s.Headers {
+		for _, value := range values {
+			request.Header.Add(key, value)
+		}
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if s.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+
+	response, err := s.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(response.Body, 1<<20))
+	response.Body.Close()
+	if response.StatusCode == http.StatusBadRequest ||
+		response.StatusCode == http.StatusUnprocessableEntity {
+		return &PermanentError{fmt.Errorf("webhook: %s", response.Status)}
+	} else if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook: %s", response.Status)
+	}
+	return nil
+}
+
+// queued returns the queued request files, oldest first.
+func (s *WebhookSink) queued() ([]string, error) {
+	if s.QueueDirectory == "" {
+		return nil, nil
+	}
+	files, err := ioutil.ReadDir(s.QueueDirectory)
+	if err != nil {
+		return nil, err
+	}
+	filenames := []string{}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".json") {
+			filenames = append(filenames, file.Name())
+		}
+	}
+	sort.Strings(filenames)
+	return filenames, nil
+}
+
+// enqueue saves a request body to the queue, or aside from it with a
+// suffix of ".failed".
+func (s *WebhookSink) enqueue(body []byte, suffix string) error {
+	if err := os.MkdirAll(s.QueueDirectory, 0700); err != nil {
+		return err
+	}
+	s.sequence++
+	filename := path.Join(s.QueueDirectory,
+		fmt.Sprintf("%020d-%06d.json%s", time.Now().UnixNano(), s.sequence,
+			suffix))
+
+	// Written under a temporary name so a partial file is never
+	// delivered.
+	tmp := path.Join(s.QueueDirectory, "."+path.Base(filename))
+	if err := ioutil.WriteFile(tmp, body, 0600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// drain delivers queued requests in order, returning the number that
+// remain.
+func (s *WebhookSink) drain() (int, error) {
+	filenames, err := s.queued()
+	if err != nil {
+		return 0, err
+	}
+	if len(filenames) > 0 && time.Since(s.lastError) < s.RetryInterval {
+		return len(filenames), nil
+	}
+	for i, name := range filenames {
+		filename := path.Join(s.QueueDirectory, name)
+		body, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return len(filenames) - i, err
+		}
+		err = s.post(body)
+		if _, ok := err.(*PermanentError); ok {
+			// Moved aside so it doesn't hold up the rest of the
+			// queue.
+			s.Failed++
+			if err := os.Rename(filename, filename+".failed"); err != nil {
+				return len(filenames) - i, err
+			}
+			continue
+		} else if err != nil {
+			s.lastError = time.Now()
+			return len(filenames) - i, err
+		}
+		if err := os.Remove(filename); err != nil {
+			return len(filenames) - i - 1, err
+		}
+	}
+	return 0, nil
+}
+
+// Drain attempts to deliver any queued requests, waiting at least
+// RetryInterval after a failure.  It is suitable for use as the
+// IdleHook of a SpoolBatcher.
+func (s *WebhookSink) Drain() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err := s.drain()
+	return err
+}
+
+// Send delivers a batch of events, queueing those that can not be
+// delivered if QueueDirectory is set.  An error is returned if events
+// were neither delivered nor queued, or a *PermanentError if the
+// endpoint refused any.
+func (s *WebhookSink) Send(events []*AggregatedEvent) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	bodies := [][]byte{}
+	if s.Batch {
+		documents := make([]*EventDocument, len(events))
+		for i, event := range events {
+			documents[i] = NewEventDocument(event, s.Signatures)
+		}
+		body, err := json.Marshal(documents)
+		if err != nil {
+			return err
+		}
+		bodies = append(bodies, body)
+	} else {
+		for _, event := range events {
+			body, err := json.Marshal(NewEventDocument(event,
+				s.Signatures))
+			if err != nil {
+				return err
+			}
+			bodies = append(bodies, body)
+		}
+	}
+
+	// Queued requests go first to keep delivery in order.
+	var failed error
+	remaining, err := s.drain()
+	if remaining == 0 && err == nil {
+		for len(bodies) > 0 {
+			err = s.post(bodies[0])
+			if _, ok := err.(*PermanentError); ok {
+				s.Failed++
+				failed = err
+				if s.QueueDirectory != "" {
+					if err := s.enqueue(bodies[0], ".failed"); err != nil {
+						return err
+					}
+				}
+				err = nil
+			} else if err != nil {
+				s.lastError = time.Now()
+				break
+			}
+			bodies = bodies[1:]
+		}
+	}
+	if len(bodies) == 0 {
+		return failed
+	}
+
+	if s.QueueDirectory == "" {
+		return err
+	}
+	if s.MaxQueued > 0 && remaining+len(bodies) > s.MaxQueued {
+		return WebhookQueueFull
+	}
+	for _, body := range bodies {
+		if err := s.enqueue(body, ""); err != nil {
+			return err
+		}
+	}
+	return failed
+}
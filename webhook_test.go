@@ -0,0 +1,219 @@
+package unified2
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+type fakeWebhook struct {
+	lock     sync.Mutex
+	fail     bool
+	status   int
+	bodies   [][]byte
+	headers  []http.Header
+	requests int
+}
+
+func (f *fakeWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.requests++
+	if f.fail {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	} else if f.status != 0 {
+		w.WriteHeader(f.status)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	f.bodies = append(f.bodies, body)
+	f.headers = append(f.headers, r.Header)
+}
+
+func TestWebhookSink(t *testing.T) {
+	events := readAggregatedEvents(t, "test/multi-record-event-x2.log")
+
+	fake := &fakeWebhook{}
+	server := httptest.NewTLSServer(fake)
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, &tls.Config{
+		RootCAs: server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs,
+	})
+	sink.Token = "secret"
+	sink.Headers.Set("X-Sensor", "sensor1")
+
+	if err := sink.Send(events); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.bodies) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(fake.bodies))
+	}
+	if fake.headers[0].Get("Authorization") != "Bearer secret" ||
+		fake.headers[0].Get("X-Sensor") != "sensor1" ||
+		fake.headers[0].Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected headers: %v", fake.headers[0])
+	}
+	var document EventDocument
+	if err := json.Unmarshal(fake.bodies[0], &document); err != nil {
+		t.Fatal(err)
+	}
+	if document.EventId != 89 || len(document.ExtraData) != 1 {
+		t.Fatalf("unexpected document: %+v", document)
+	}
+
+	sink.Batch = true
+	if err := sink.Send(events); err != nil {
+		t.Fatal(err)
+	}
+	var documents []EventDocument
+	if err := json.Unmarshal(fake.bodies[2], &documents); err != nil {
+		t.Fatal(err)
+	}
+	if len(documents) != 2 {
+		t.Fatalf("expected 2 documents, got %d", len(documents))
+	}
+}
+
+func TestWebhookSinkQueue(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	events := readAggregatedEvents(t, "test/multi-record-event-x2.log")
+
+	fake := &fakeWebhook{fail: true}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, nil)
+	sink.QueueDirectory = tmpdir
+	sink.RetryInterval = 0
+
+	// Without a queue, failure is an error.
+	sink.QueueDirectory = ""
+	if err := sink.Send(events[0:1]); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// With a queue, the events are saved.
+	sink.QueueDirectory = tmpdir
+	if err := sink.Send(events[0:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(events[1:2]); err != nil {
+		t.Fatal(err)
+	}
+	queued, err := sink.queued()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 2 {
+		t.Fatalf("expected 2 queued requests, got %d", len(queued))
+	}
+
+	// Still failing, so nothing is delivered.
+	if err := sink.Drain(); err == nil {
+		t.Fatal("expected error")
+	}
+
+	fake.lock.Lock()
+	fake.fail = false
+	fake.lock.Unlock()
+
+	if err := sink.Drain(); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.bodies) != 2 {
+		t.Fatalf("expected 2 delivered requests, got %d", len(fake.bodies))
+	}
+	queued, err = sink.queued()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 0 {
+		t.Fatalf("expected empty queue, got %d", len(queued))
+	}
+}
+
+func TestWebhookSinkRefused(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	events := readAggregatedEvents(t, "test/multi-record-event-x2.log")
+
+	fake := &fakeWebhook{fail: true}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, nil)
+	sink.QueueDirectory = tmpdir
+	sink.RetryInterval = 0
+	sink.MaxQueued = 2
+
+	if err := sink.Send(events); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(events[0:1]); err != WebhookQueueFull {
+		t.Fatalf("expected WebhookQueueFull, got %v", err)
+	}
+
+	// A request refused as unauthorized is kept to be retried.
+	fake.lock.Lock()
+	fake.fail = false
+	fake.status = http.StatusUnauthorized
+	fake.lock.Unlock()
+
+	if err := sink.Drain(); err == nil {
+		t.Fatal("expected error")
+	}
+	if queued, _ := sink.queued(); len(queued) != 2 || sink.Failed != 0 {
+		t.Fatalf("expected 2 queued and none failed, got %d and %d",
+			len(queued), sink.Failed)
+	}
+
+	// Invalid requests are moved aside rather than blocking the queue.
+	fake.lock.Lock()
+	fake.status = http.StatusBadRequest
+	fake.lock.Unlock()
+
+	if err := sink.Drain(); err != nil {
+		t.Fatal(err)
+	}
+	queued, err := sink.queued()
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed, err := filepath.Glob(filepath.Join(tmpdir, "*.json.failed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 0 || len(failed) != 2 || sink.Failed != 2 {
+		t.Fatalf("expected 0 queued and 2 failed, got %d, %d and %d",
+			len(queued), len(failed), sink.Failed)
+	}
+
+	err = sink.Send(events[0:1])
+	if _, ok := err.(*PermanentError); !ok {
+		t.Fatalf("expected permanent error, got %v", err)
+	}
+	if queued, _ := sink.queued(); len(queued) != 0 {
+		t.Fatalf("expected empty queue, got %d", len(queued))
+	}
+	failed, _ = filepath.Glob(filepath.Join(tmpdir, "*.json.failed"))
+	if len(failed) != 3 {
+		t.Fatalf("expected 3 failed requests, got %d", len(failed))
+	}
+}
@@ -0,0 +1,203 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+)
+
+// EncodingError is the error returned when a record can not be encoded
+// as the requested type, such as an IPv6 address in an IPv4 event.
+var EncodingError = errors.New("EncodingError")
+
+// WriteRawRecord writes a raw record, with its header, to the provided
+// writer in unified2 format.
+func WriteRawRecord(writer io.Writer, record *RawRecord) error {
+	header := RawHeader{record.Type, uint32(len(record.Data))}
+	if err := binary.Write(writer, binary.BigEndian, &header); err != nil {
+		return err
+	}
+
+	n, err := writer.Write(record.Data)
+	if err != nil {
+		return err
+	} else if n != len(record.Data) {
+		return io.ErrShortWrite
+	}
+
+	return nil
+}
+
+// EncodeEventRecord encodes an event as a raw record of the given event
+// type.  The addresses must be of the family of the event type, except
+// for event v3 records which hold either.
+func EncodeEventRecord(eventType uint32, event *EventRecord) (*RawRecord, error) {
+	if !isEventType(eventType) {
+		return nil, EncodingError
+	}
+	if eventType == UNIFIED2_EVENT_V3 {
+		return encodeEventV3Record(event)
+	}
+
+	ip6 := false
+	switch eventType {
+	case UNIFIED2_EVENT_IP6, UNIFIED2_EVENT_V2_IP6, UNIFIED2_EVENT_APPID_IP6,
+		UNIFIED2_EVENT_MPLS_IP6:
+		ip6 = true
+	}
+
+	var source, destination []byte
+	if ip6 {
+		source, destination = event.IpSource.To16(), event.IpDestination.To16()
+	} else {
+		source, destination = event.IpSource.To4(), event.IpDestination.To4()
+	}
+	if source == nil || destination == nil {
+		return nil, EncodingError
+	}
+
+	data := make([]byte, 0, eventRecordLengths[eventType])
+	for _, value := range []uint32{event.SensorId, event.EventId,
+		event.EventSecond, event.EventMicrosecond, event.SignatureId,
+		event.GeneratorId, event.SignatureRevision,
+		event.ClassificationId, event.Priority} {
+		data = appendUint32(data, value)
+	}
+	data = append(data, source...)
+	data = append(data, destination...)
+	data = appendUint16(data, event.SportItype)
+	data = appendUint16(data, event.DportIcode)
+	data = append(data, event.Protocol, event.ImpactFlag, event.Impact,
+		event.Blocked)
+
+	switch eventType {
+	case UNIFIED2_EVENT, UNIFIED2_EVENT_IP6:
+	default:
+		data = appendUint32(data, event.MplsLabel)
+		data = appendUint16(data, event.VlanId)
+		data = appendUint16(data, event.Pad2)
+	}
+
+	switch eventType {
+	case UNIFIED2_EVENT_APPID, UNIFIED2_EVENT_APPID_IP6:
+		data = appendAppId(data, event.AppId)
+	}
+
+	return &RawRecord{eventType, data}, nil
+}
+
+func encodeEventV3Record(event *EventRecord) (*RawRecord, error) {
+	data := make([]byte, 0, EVENT_V3_RECORD_LEN)
+	for _, value := range []uint32{event.SensorId, event.EventId,
+		event.EventSecond, event.EventMicrosecond, event.GeneratorId,
+		event.SignatureId, event.SignatureRevision,
+		event.ClassificationId, event.Priority, event.PolicyIdContext,
+		event.PolicyIdInspect, event.PolicyIdDetect} {
+		data = appendUint32(data, value)
+	}
+
+	var version uint8
+	for i, address := range []net.IP{event.IpSource, event.IpDestination} {
+		ip := address.To16()
+		if ip == nil {
+			return nil, EncodingError
+		}
+		addressVersion := uint8(6)
+		if address.To4() != nil {
+			addressVersion = 4
+		}
+		version |= addressVersion << (4 * uint(1-i))
+		data = append(data, ip...)
+	}
+
+	data = appendUint32(data, event.MplsLabel)
+	data = appendUint16(data, event.SportItype)
+	data = appendUint16(data, event.DportIcode)
+	data = appendUint16(data, event.VlanId)
+	data = appendUint16(data, event.Pad2)
+	data = append(data, version, event.Protocol, event.Status, event.Action)
+	data = appendAppId(data, event.AppId)
+
+	return &RawRecord{UNIFIED2_EVENT_V3, data}, nil
+}
+
+// EncodePacketRecord encodes a packet as a raw record.  The Length
+// field is taken from the packet data.
+func EncodePacketRecord(packet *PacketRecord) *RawRecord {
+	data := make([]byte, 0, PACKET_RECORD_HDR_LEN+len(packet.Data))
+	for _, value := range []uint32{packet.SensorId, packet.EventId,
+		packet.EventSecond, packet.PacketSecond,
+		packet.PacketMicrosecond, packet.LinkType,
+		uint32(len(packet.Data))} {
+		data = appendUint32(data, value)
+	}
+	return &RawRecord{UNIFIED2_PACKET, append(data, packet.Data...)}
+}
+
+// EncodeBufferRecord encodes a buffer as a raw record.
+func EncodeBufferRecord(buffer *BufferRecord) *RawRecord {
+	record := EncodePacketRecord((*PacketRecord)(buffer))
+	record.Type = UNIFIED2_BUFFER
+	return record
+}
+
+// EncodeExtraDataRecord encodes extra data as a raw record.  The
+// length fields are taken from the data, and an EventType of 0 is
+// written as EXTRA_DATA_EVENT_TYPE.
+func EncodeExtraDataRecord(extra *ExtraDataRecord) *RawRecord {
+	eventType := extra.EventType
+	if eventType == 0 {
+		eventType = EXTRA_DATA_EVENT_TYPE
+	}
+	data := make([]byte, 0, EXTRA_DATA_RECORD_HDR_LEN+len(extra.Data))
+	for _, value := range []uint32{eventType,
+		uint32(EXTRA_DATA_RECORD_HDR_LEN + len(extra.Data)),
+		extra.SensorId, extra.EventId, extra.EventSecond, extra.Type,
+		extra.DataType, uint32(len(extra.Data) + 8)} {
+		data = appendUint32(data, value)
+	}
+	return &RawRecord{UNIFIED2_EXTRA_DATA, append(data, extra.Data...)}
+}
+
+func appendUint32(data []byte, value uint32) []byte {
+	return append(data, byte(value>>24), byte(value>>16), byte(value>>8),
+		byte(value))
+}
+
+func appendUint16(data []byte, value uint16) []byte {
+	return append(data, byte(value>>8), byte(value))
+}
+
+// appendAppId appends an appid as a 64 byte nul padded field.
+func appendAppId(data []byte, appid string) []byte {
+	field := make([]byte, 64)
+	copy(field[:63], appid)
+	return append(data, field...)
+}